@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"strings"
+)
+
+/* operations for the admin pages and commands; callers check that the
+ * actor is allowed to use them (see IsElectionManager).
+ */
+
+var ErrorInvalidElectionName = newFieldError("invalid_election_name", "name", "Invalid election name")
+var ErrorInvalidEmail = newFieldError("invalid_email", "email", "Invalid email address")
+var ErrorMemberVoted = newError("member_voted", "Members who already voted can't be removed")
+
+// listed member of an election
+type Member struct {
+	Uid   int64
+	Name  string
+	Email string
+	Group string
+	Voted bool
+}
+
+// names are used in URL paths (with suffixes like "/results")
+func validElectionName(name string) bool {
+	return 0 != len(name) && !strings.Contains(name, "/") && strings.TrimSpace(name) == name
+}
+
+// random token for registered users
+func NewUserToken() (string, error) {
+	if token, err := randomBytes(16); nil != err {
+		return "", err
+	} else {
+		return hex.EncodeToString(token), nil
+	}
+}
+
+// candidates can only be added, removed or reordered before voting
+// opened (see candidatesLocked); afterwards see ChangeCandidates. the RON
+// candidate is kept (see SetElectionRon).
+func (etx *ElectionsTx) SetElectionDetails(e *Election, actor *User, title string, candidates []Candidate) error {
+	candidates = keepReserved(e, candidates)
+	if err := checkCandidates(candidates, minimumCandidates(e)); nil != err {
+		return err
+	}
+	resize := len(candidates) != len(e.Candidates)
+	if !sameCandidates(candidates, e.Candidates) {
+		if locked, err := etx.candidatesLocked(e); nil != err {
+			return err
+		} else if locked {
+			return ErrorCandidatesLocked
+		}
+	}
+	e.Title = title
+	if err := etx.st.UpdateElection(e); nil != err {
+		return internalError(err)
+	} else if err := etx.setCandidates(e, candidates); nil != err {
+		return err
+	}
+	if resize {
+		if err := etx.resetTally(e); nil != err {
+			return err
+		}
+	}
+	return etx.audit(AuditElectionEdited, e, actor, map[string]interface{}{
+		"title":      title,
+		"candidates": e.Candidates,
+	})
+}
+
+func (etx *ElectionsTx) SetElectionAccess(e *Election, actor *User, public, open, editOpen bool) error {
+	e.Public = public
+	e.Open = open
+	e.EditOpen = editOpen
+	if err := etx.st.UpdateElection(e); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditElectionAccess, e, actor, map[string]interface{}{
+		"public":   public,
+		"open":     open,
+		"editopen": editOpen,
+	})
+}
+
+func (etx *ElectionsTx) ElectionMembers(e *Election) ([]Member, error) {
+	if members, err := etx.st.ListedMembers(e.Eid, e.Secret); nil != err {
+		return nil, internalError(err)
+	} else {
+		return members, nil
+	}
+}
+
+// only members who didn't vote yet can be removed
+func (etx *ElectionsTx) RemoveElectionMember(e *Election, user *User, actor *User) error {
+	if voted, err := etx.hasVoted(user, e); nil != err {
+		return err
+	} else if voted {
+		return ErrorMemberVoted
+	} else if err := etx.st.RemoveMember(e.Eid, user.Uid); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditMemberRemoved, e, actor, map[string]interface{}{"uid": user.Uid})
+}
+
+func (etx *ElectionsTx) RegisteredUsers() ([]*User, error) {
+	if users, err := etx.st.RegisteredUsers(); nil != err {
+		return nil, internalError(err)
+	} else {
+		return users, nil
+	}
+}
+
+// the token is not logged
+func (etx *ElectionsTx) SetUserToken(user *User, actor *User, token string) error {
+	user.Token = sql.NullString{String: token, Valid: true}
+	if err := etx.st.UpdateUser(user); errStorageConflict == err {
+		return ErrorUserExists
+	} else if nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditUserToken, nil, actor, map[string]interface{}{"uid": user.Uid})
+}
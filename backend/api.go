@@ -3,40 +3,99 @@ package backend
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/stbuehler/go-vote/types"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"net/url"
 )
 
-var ApiInternalError = errors.New("Internal Server Error")
+var ErrorAdminOnly = newError("admin_only", "Only available to site admins")
+var ErrorBallotsNotPublished = newError("ballots_not_published", "Ballots are published after the election closed")
 
-func apiInternalError() (int, interface{}, error) {
-	return 500, nil, ApiInternalError
+// HTTP status codes for error codes; other codes are internal errors
+var apiErrorStatus = map[string]int{
+	CodeInvalidRequest:                 400,
+	ErrorInvalidRanking.Code:           400,
+	ErrorEmptyBallot.Code:              400,
+	ErrorInvalidSchedule.Code:          400,
+	ErrorInvalidResultsPolicy.Code:     400,
+	ErrorInvalidUnrankedPolicy.Code:    400,
+	ErrorInvalidElectionName.Code:      400,
+	ErrorInvalidCandidates.Code:        400,
+	ErrorInvalidCandidateUrl.Code:      400,
+	ErrorInvalidEmail.Code:             400,
+	ErrorInvalidBallotCodeCount.Code:   400,
+	ErrorInvalidNomination.Code:        400,
+	ErrorInvalidNominationSeconds.Code: 400,
+	ErrorInvalidNominationStatus.Code:  400,
+	ErrorUserNotFound.Code:             401,
+	ErrorInvalidUsername.Code:          401,
+	ErrorInvalidBallotCode.Code:        401,
+	ErrorInvalidAuthorization.Code:     401,
+	ErrorInvalidTicket.Code:            401,
+	ErrorBallotCodeUsed.Code:           403,
+	ErrorElectionMembersOnly.Code:      403,
+	ErrorElectionMembersOnlyEdit.Code:  403,
+	ErrorElectionClosed.Code:           403,
+	ErrorElectionNotOpenYet.Code:       403,
+	ErrorElectionNominating.Code:       403,
+	ErrorNominationsClosed.Code:        403,
+	ErrorNominationsRegistered.Code:    403,
+	ErrorOwnNomination.Code:            403,
+	ErrorManagersOnly.Code:             403,
+	ErrorAlreadyVoted.Code:             403,
+	ErrorResultsNotVisible.Code:        403,
+	ErrorTurnoutNotVisible.Code:        403,
+	ErrorAdminOnly.Code:                403,
+	ErrorElectionNotFound.Code:         404,
+	ErrorBallotsNotPublished.Code:      404,
+	ErrorFeatureDisabled.Code:          404,
+	ErrorNotFound.Code:                 404,
+	ErrorNominationNotFound.Code:       404,
+	ErrorMethodNotAllowed.Code:         405,
+	ErrorNotAcceptable.Code:            406,
+	ErrorUnsupportedMediaType.Code:     415,
+	ErrorElectionExists.Code:           409,
+	ErrorUserExists.Code:               409,
+	ErrorCandidatesLocked.Code:         409,
+	ErrorRevoteSecret.Code:             409,
+	ErrorUnrankedPolicyLocked.Code:     409,
+	ErrorRonLocked.Code:                409,
+	ErrorMemberVoted.Code:              409,
+	ErrorNominationDecided.Code:        409,
+	ErrorNotEnoughSeconds.Code:         409,
+	CodeBusy:                           503,
 }
 
-func apiInvalidRequest(err error) (int, interface{}, error) {
-	return 400, nil, fmt.Errorf("Invalid request: %v", err)
+// body of all error responses
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
 }
 
-func apiUnauthorizedRequest(err error) (int, interface{}, error) {
-	return 401, nil, fmt.Errorf("Unauthorized request: %v", err)
-}
-
-func apiNotFound(err error) (int, interface{}, error) {
-	return 404, nil, fmt.Errorf("Not found: %v", err)
+// status code and response body for err; details of internal errors are
+// only logged
+func apiErrorResponse(err error) (int, apiError) {
+	var e *Error
+	if errors.As(err, &e) {
+		if status, ok := apiErrorStatus[e.Code]; ok {
+			return status, apiError{Code: e.Code, Message: e.Message, Field: e.Field}
+		}
+	}
+	return 500, apiError{Code: CodeInternal, Message: "Internal server error"}
 }
 
 type auth struct {
 	Token string `json:",omitempty"`
 	Name  string `json:",omitempty"`
+	Code  string `json:",omitempty"` // ballot code
 }
 
 func (etx ElectionsTx) findAuth(a auth) (*User, error) {
 	if 0 != len(a.Token) {
 		return etx.FindUserByToken(a.Token)
+	} else if 0 != len(a.Code) {
+		return etx.FindOrCreateBallotCodeUser(a.Code)
 	} else {
 		return nil, nil
 	}
@@ -45,6 +104,8 @@ func (etx ElectionsTx) findAuth(a auth) (*User, error) {
 func (etx ElectionsTx) findOrCreateAuth(a auth) (*User, error) {
 	if 0 != len(a.Token) {
 		return etx.FindUserByToken(a.Token)
+	} else if 0 != len(a.Code) {
+		return etx.FindOrCreateBallotCodeUser(a.Code)
 	} else {
 		return etx.FindOrCreateUnregisteredUser(a.Name)
 	}
@@ -55,104 +116,264 @@ type voteReq struct {
 	RankGroups types.RankGroups
 }
 
-func (edb ElectionsDb) apiHandleVote(query url.Values, jsonBody []byte) (int, interface{}, error) {
-	var req voteReq
-	if err := json.Unmarshal(jsonBody, &req); nil != err {
-		return apiInvalidRequest(err)
-	} else if etx, err := edb.StartTransaction(); nil != err {
-		return apiInternalError()
+func (edb ElectionsDb) apiVote(election string, a auth, rankGroups types.RankGroups) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findOrCreateAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.FindElectionByName(election, user); nil != err {
+		return nil, err
+	} else if ranking, err := rankGroups.PartialRanking(len(e.Candidates)); nil != err {
+		// candidates missing from the groups are unranked
+		return nil, invalidField("rankgroups", err)
+	} else if receipt, err := etx.ElectionVote(e, user, ranking); nil != err {
+		return nil, err
+	} else if err := etx.Commit(); nil != err {
+		return nil, internalError(err)
+	} else if e.Secret {
+		// no uid: the log time must not link the voter to result changes
+		logInfof("Committed secret ballot: eid=%d", e.Eid)
+		return receipt, nil
 	} else {
-		defer etx.Rollback()
-
-		if user, err := etx.findOrCreateAuth(req.Auth); nil != err {
-			return apiUnauthorizedRequest(err)
-		} else if e := etx.FindElectionByName(query.Get("election"), user); nil == e {
-			return apiNotFound(fmt.Errorf("Election not found"))
-		} else if err := req.RankGroups.Check(len(e.Candidates)); nil != err {
-			return apiInvalidRequest(err)
-		} else if ranking, err := req.RankGroups.Ranking(); nil != err {
-			return apiInvalidRequest(err)
-		} else if err := etx.ElectionVote(e, user, ranking); nil != err {
-			return apiInvalidRequest(err)
-		} else if err := etx.Commit(); nil != err {
-			log.Printf("Vote commit failed: %v", err)
-			return apiInternalError()
-		} else {
-			rankingJson := types.JsonMustEncodeString(ranking)
-			log.Printf("Committed vote: eid=%d uid=%d ranking=%s", e.Eid, user.Uid, rankingJson)
-			return 200, nil, nil
-		}
+		rankingJson := types.JsonMustEncodeString(ranking)
+		logDebugf("Committed vote: eid=%d uid=%d ranking=%s", e.Eid, user.Uid, rankingJson)
+		return receipt, nil
 	}
 }
 
+// cast a ballot with a token, a ballot code or as unregistered user
+// (name), like PUT /api/v1/elections/{name}/ballot (for form posts)
+func (edb ElectionsDb) Vote(election string, token, name, code string, rankGroups types.RankGroups) (*types.Receipt, error) {
+	var result interface{}
+	if err := edb.Retry(func() (err error) {
+		result, err = edb.apiVote(election, auth{Token: token, Name: name, Code: code}, rankGroups)
+		return
+	}); nil != err {
+		return nil, err
+	}
+	return result.(*types.Receipt), nil
+}
+
+func (edb ElectionsDb) apiHandleVote(req *http.Request, jsonBody []byte) (interface{}, error) {
+	var body voteReq
+	if err := json.Unmarshal(jsonBody, &body); nil != err {
+		return nil, invalidRequest(err)
+	}
+	return edb.apiVote(req.URL.Query().Get("election"), body.Auth, body.RankGroups)
+}
+
 func (edb ElectionsDb) ApiVoteHandler() http.HandlerFunc {
-	return makeApiHandler(edb.apiHandleVote)
+	return edb.makeApiHandler(edb.apiHandleVote)
 }
 
 type resultsReq struct {
 	Auth auth
 }
 
-func (edb ElectionsDb) apiHandleResults(query url.Values, jsonBody []byte) (int, interface{}, error) {
-	var req resultsReq
-	if err := json.Unmarshal(jsonBody, &req); nil != err {
-		return apiInvalidRequest(err)
-	} else if etx, err := edb.StartTransaction(); nil != err {
-		return apiInternalError()
+// election the user may see the results of
+func (etx *ElectionsTx) findResultsElection(name string, user *User) (*Election, error) {
+	if e, err := etx.FindElectionByName(name, user); nil != err {
+		return nil, err
+	} else if visible, err := etx.CanSeeResults(user, e); nil != err {
+		return nil, err
+	} else if !visible {
+		return nil, ErrorResultsNotVisible
 	} else {
-		defer etx.Rollback()
-
-		if user, err := etx.findAuth(req.Auth); nil != err {
-			return apiUnauthorizedRequest(err)
-		} else if e := etx.FindElectionByName(query.Get("election"), user); nil == e {
-			return apiNotFound(fmt.Errorf("Election not found"))
-		} else if pairPrefs, err := etx.ElectionPairwisePreferences(e); nil != err {
-			log.Printf("ApiResults failure: %v", err)
-			return apiInternalError()
-		} else if err := etx.Commit(); nil != err {
-			log.Printf("ApiResults failure: %v", err)
-			return apiInternalError()
-		} else {
-			result := make(map[string]interface{})
-			result["preferences"] = pairPrefs
-			if winner := pairPrefs.Winner(); -1 != winner {
-				result["winner"] = winner
-			} else {
-				paths := pairPrefs.StrongestPaths()
-				result["paths"] = paths
-				if winner := paths.Winner(); -1 != winner {
-					result["winner"] = winner
-				}
-			}
-
-			return 200, result, nil
+		return e, nil
+	}
+}
+
+func (edb ElectionsDb) apiResults(election string, a auth) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.findResultsElection(election, user); nil != err {
+		return nil, err
+	} else if pairPrefs, err := etx.ElectionResults(e); nil != err {
+		return nil, err
+	} else if err := etx.Commit(); nil != err {
+		return nil, internalError(err)
+	} else {
+		ron := e.Ron()
+		r := pairPrefs.Result(ron)
+		result := make(map[string]interface{})
+		result["preferences"] = pairPrefs
+		if nil != r.Paths {
+			result["paths"] = r.Paths
+		}
+		if -1 != r.Winner {
+			result["winner"] = r.Winner
 		}
+		if -1 != ron {
+			result["ron"] = ron
+			result["failed"] = r.Failed
+			result["ineligible"] = append([]int{}, r.Ineligible...)
+		}
+
+		return result, nil
 	}
 }
 
+func (edb ElectionsDb) apiHandleResults(req *http.Request, jsonBody []byte) (interface{}, error) {
+	var body resultsReq
+	if err := json.Unmarshal(jsonBody, &body); nil != err {
+		return nil, invalidRequest(err)
+	}
+	return edb.apiResults(req.URL.Query().Get("election"), body.Auth)
+}
+
 func (edb ElectionsDb) ApiResultsHandler() http.HandlerFunc {
-	return makeApiHandler(edb.apiHandleResults)
+	return edb.makeApiHandler(edb.apiHandleResults)
+}
+
+type ballotsReq struct {
+	Auth auth
 }
 
-func makeApiHandler(api func(query url.Values, jsonBody []byte) (int, interface{}, error)) http.HandlerFunc {
+// anonymised ballots are only published after the election closed
+func (edb ElectionsDb) apiBallots(election string, a auth) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.findResultsElection(election, user); nil != err {
+		return nil, err
+	} else if !etx.IsElectionClosed(e) {
+		return nil, ErrorBallotsNotPublished
+	} else if ballots, err := etx.ElectionBallots(e); nil != err {
+		return nil, err
+	} else if adjusted, err := etx.AdjustedBallotHashes(e); nil != err {
+		return nil, err
+	} else if err := etx.Commit(); nil != err {
+		return nil, internalError(err)
+	} else {
+		result := make(map[string]interface{})
+		result["candidates"] = CandidateNames(e.Candidates)
+		// needed to count partial ballots
+		result["unranked"] = e.Unranked
+		result["ballots"] = ballots
+		// receipts issued before the candidates changed carry old hashes
+		result["adjusted"] = adjusted
+		return result, nil
+	}
+}
+
+func (edb ElectionsDb) apiHandleBallots(req *http.Request, jsonBody []byte) (interface{}, error) {
+	var body ballotsReq
+	if err := json.Unmarshal(jsonBody, &body); nil != err {
+		return nil, invalidRequest(err)
+	}
+	return edb.apiBallots(req.URL.Query().Get("election"), body.Auth)
+}
+
+func (edb ElectionsDb) ApiBallotsHandler() http.HandlerFunc {
+	return edb.makeApiHandler(edb.apiHandleBallots)
+}
+
+type auditReq struct {
+	Auth auth
+}
+
+func (edb ElectionsDb) apiAudit(a auth) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if nil == user || !user.SiteAdmin {
+		return nil, ErrorAdminOnly
+	} else if entries, err := etx.AuditLog(); nil != err {
+		return nil, err
+	} else {
+		return entries, nil
+	}
+}
+
+func (edb ElectionsDb) apiHandleAudit(req *http.Request, jsonBody []byte) (interface{}, error) {
+	var body auditReq
+	if err := json.Unmarshal(jsonBody, &body); nil != err {
+		return nil, invalidRequest(err)
+	}
+	return edb.apiAudit(body.Auth)
+}
+
+func (edb ElectionsDb) ApiAuditHandler() http.HandlerFunc {
+	return edb.makeApiHandler(edb.apiHandleAudit)
+}
+
+func logApiError(req *http.Request, status int, err error) {
+	if status >= 500 {
+		logErrorf("Request[%+q] failed: %d %v", req.URL.EscapedPath(), status, err)
+	} else {
+		logInfof("Request[%+q] failed: %d %v", req.URL.EscapedPath(), status, err)
+	}
+}
+
+// logs err and returns the status code and the error to show to users
+// (details of internal errors are only logged)
+func RequestError(req *http.Request, err error) (int, *Error) {
+	status, body := apiErrorResponse(err)
+	logApiError(req, status, err)
+	return status, &Error{Code: body.Code, Message: body.Message, Field: body.Field}
+}
+
+func writeApiResponse(w http.ResponseWriter, req *http.Request, result interface{}, err error) {
+	w.Header().Add("Content-Type", "application/json")
+	if nil != err {
+		status, body := apiErrorResponse(err)
+		logApiError(req, status, err)
+		w.WriteHeader(status)
+		w.Write(types.JsonMustEncode(body))
+	} else {
+		w.WriteHeader(200)
+		w.Write(types.JsonMustEncode(result))
+	}
+}
+
+// handler for the original API (POST with the election in the query string),
+// kept for compatibility; see api_v1.go for the current one.
+// api runs a complete transaction; it is retried if the database was busy
+func (edb ElectionsDb) makeApiHandler(api func(req *http.Request, jsonBody []byte) (interface{}, error)) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
+		var result interface{}
 		jsonBody, err := ioutil.ReadAll(req.Body)
 		if nil != err {
-			log.Printf("Couldn't read json request body: %v", err)
-			http.Error(w, "400 Bad Request", 400)
-			return
-		} else if code, result, err := api(req.URL.Query(), jsonBody); nil != err {
-			log.Printf("Request[%+q] failed: %d %+q", req.URL.EscapedPath(), code, err)
-			http.Error(w, err.Error(), code)
+			err = invalidRequest(err)
 		} else {
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(code)
-			w.Write(types.JsonMustEncode(result))
+			err = edb.Retry(func() (err error) {
+				result, err = api(req, jsonBody)
+				return
+			})
 		}
+		writeApiResponse(w, req, result, err)
 	}
 }
 
 func (edb ElectionsDb) BindServeMux(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc(prefix+"/openapi.json", OpenApiHandler(prefix))
+	mux.HandleFunc(prefix+apiV1Path, edb.ApiV1Handler(prefix))
 	mux.HandleFunc(prefix+"/vote", edb.ApiVoteHandler())
 	mux.HandleFunc(prefix+"/result", edb.ApiResultsHandler())
+	if edb.features.PublishBallots {
+		mux.HandleFunc(prefix+"/ballots", edb.ApiBallotsHandler())
+	}
+	if edb.features.AuditApi {
+		mux.HandleFunc(prefix+"/audit", edb.ApiAuditHandler())
+	}
 }
@@ -0,0 +1,256 @@
+package backend
+
+import (
+	"encoding/json"
+	"github.com/stbuehler/go-vote/types"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/* resource oriented API:
+ *
+ *   GET /api/v1/elections/{name}          election details
+ *   PUT /api/v1/elections/{name}/ballot   cast or change a ballot
+ *   GET /api/v1/elections/{name}/results  results
+ *   GET /api/v1/elections/{name}/ballots  anonymised ballots (closed elections)
+ *   GET /api/v1/elections/{name}/events   results as server-sent events
+ *   GET /api/v1/elections/{name}/turnout  turnout (election managers)
+ *   GET /api/v1/elections/{name}/turnout/events
+ *                                         turnout as server-sent events
+ *   GET/POST /api/v1/elections/{name}/nominations
+ *                                         list or add nominations
+ *   GET/PUT /api/v1/elections/{name}/nominations/{id}
+ *                                         nomination; approve or reject
+ *                                         (election managers)
+ *   PUT /api/v1/elections/{name}/nominations/{id}/second
+ *                                         second a nomination
+ *   GET /api/v1/audit                     audit log (site admins)
+ *   POST /api/v1/tickets                  ticket for the event streams
+ *
+ * users authenticate with "Authorization: Bearer TOKEN" or
+ * "Authorization: Ballot-Code CODE"; unregistered users in open elections
+ * pass their name as "auth" in the ballot (like the original API).
+ */
+
+const apiV1Path = "/api/v1/"
+
+var ErrorNotFound = newError("not_found", "Resource not found")
+var ErrorMethodNotAllowed = newError("method_not_allowed", "Method not allowed")
+var ErrorNotAcceptable = newError("not_acceptable", "Responses are only available as application/json")
+var ErrorUnsupportedMediaType = newError("unsupported_media_type", "Request body must be application/json")
+var ErrorInvalidAuthorization = newError("invalid_authorization", "Invalid Authorization header")
+
+// a complete transaction; a is the auth from the Authorization header
+type apiV1Handler func(a auth, jsonBody []byte) (interface{}, error)
+
+type ballotReq struct {
+	Auth       auth // only used without Authorization header
+	RankGroups types.RankGroups
+}
+
+// handlers by method for the resource at path (relative to /api/v1/)
+func (edb ElectionsDb) apiV1Resource(path string) (map[string]apiV1Handler, error) {
+	parts := strings.Split(path, "/")
+	if 1 == len(parts) && "audit" == parts[0] {
+		if !edb.features.AuditApi {
+			return nil, ErrorFeatureDisabled
+		}
+		return map[string]apiV1Handler{
+			"GET": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiAudit(a)
+			},
+		}, nil
+	} else if 1 == len(parts) && "tickets" == parts[0] {
+		return map[string]apiV1Handler{
+			"POST": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiTicket(a)
+			},
+		}, nil
+	} else if len(parts) < 2 || "elections" != parts[0] || 0 == len(parts[1]) {
+		return nil, ErrorNotFound
+	}
+
+	election := parts[1]
+	if len(parts) > 2 && "nominations" == parts[2] {
+		return edb.apiV1Nominations(election, parts[3:])
+	} else if len(parts) > 3 {
+		return nil, ErrorNotFound
+	}
+	if 2 == len(parts) {
+		return map[string]apiV1Handler{
+			"GET": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiElection(election, a)
+			},
+		}, nil
+	}
+	switch parts[2] {
+	case "ballot":
+		return map[string]apiV1Handler{
+			"PUT": func(a auth, jsonBody []byte) (interface{}, error) {
+				var body ballotReq
+				if err := json.Unmarshal(jsonBody, &body); nil != err {
+					return nil, invalidRequest(err)
+				} else if (auth{}) == a {
+					a = body.Auth
+				}
+				return edb.apiVote(election, a, body.RankGroups)
+			},
+		}, nil
+	case "results":
+		return map[string]apiV1Handler{
+			"GET": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiResults(election, a)
+			},
+		}, nil
+	case "turnout":
+		return map[string]apiV1Handler{
+			"GET": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiTurnout(election, a)
+			},
+		}, nil
+	case "ballots":
+		if !edb.features.PublishBallots {
+			return nil, ErrorFeatureDisabled
+		}
+		return map[string]apiV1Handler{
+			"GET": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiBallots(election, a)
+			},
+		}, nil
+	}
+	return nil, ErrorNotFound
+}
+
+func (edb ElectionsDb) apiElection(name string, a auth) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.FindElectionByName(name, user); nil != err {
+		return nil, err
+	} else {
+		result := make(map[string]interface{})
+		result["name"] = e.Name
+		result["title"] = e.Title
+		// names (by index, like in rankings and results) and the records
+		result["candidates"] = CandidateNames(e.Candidates)
+		result["candidate_details"] = e.Candidates
+		result["closed"] = etx.IsElectionClosed(e)
+		result["nominating"] = e.Nominating
+		result["unranked"] = e.Unranked
+		if e.Nominating {
+			result["nomination_seconds"] = e.NominationSeconds
+		}
+		if !e.OpensAt.IsZero() {
+			result["opens_at"] = e.OpensAt.Unix()
+		}
+		if !e.ClosesAt.IsZero() {
+			result["closes_at"] = e.ClosesAt.Unix()
+		}
+		if review, err := etx.BallotReview(user, e); nil != err {
+			return nil, err
+		} else if "" != review {
+			result["ballot_review"] = review
+		}
+		return result, nil
+	}
+}
+
+// "Bearer TOKEN" or "Ballot-Code CODE"
+func parseAuthorization(header string) (auth, error) {
+	if 0 == len(header) {
+		return auth{}, nil
+	}
+	// ballot codes may contain spaces
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if 2 != len(fields) || 0 == len(strings.TrimSpace(fields[1])) {
+		return auth{}, ErrorInvalidAuthorization
+	} else if strings.EqualFold("Bearer", fields[0]) {
+		return auth{Token: strings.TrimSpace(fields[1])}, nil
+	} else if strings.EqualFold("Ballot-Code", fields[0]) {
+		return auth{Code: fields[1]}, nil
+	}
+	return auth{}, ErrorInvalidAuthorization
+}
+
+// whether the Accept header allows responses of type (like "application/json")
+func accepts(header string, contentType string) bool {
+	if 0 == len(header) {
+		return true
+	}
+	mainType := strings.SplitN(contentType, "/", 2)[0]
+	for _, mediaRange := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if nil != err {
+			continue
+		} else if q, ok := params["q"]; ok && strings.Trim(q, "0.") == "" {
+			continue // q=0: not acceptable
+		}
+		switch mediaType {
+		case contentType, mainType + "/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+func isJson(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return nil == err && "application/json" == mediaType
+}
+
+func allowedMethods(handlers map[string]apiV1Handler) string {
+	var methods []string
+	for method := range handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+func (edb ElectionsDb) ApiV1Handler(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var result interface{}
+		path := strings.TrimPrefix(req.URL.Path, prefix+apiV1Path)
+		if election, stream, ok := eventsResource(path); ok {
+			edb.serveEvents(w, req, election, stream)
+			return
+		}
+		err := func() error {
+			handlers, err := edb.apiV1Resource(path)
+			if nil != err {
+				return err
+			}
+			handler, ok := handlers[req.Method]
+			if !ok {
+				w.Header().Set("Allow", allowedMethods(handlers))
+				return ErrorMethodNotAllowed
+			} else if !accepts(req.Header.Get("Accept"), "application/json") {
+				return ErrorNotAcceptable
+			}
+			a, err := parseAuthorization(req.Header.Get("Authorization"))
+			if nil != err {
+				return err
+			}
+			jsonBody, err := ioutil.ReadAll(req.Body)
+			if nil != err {
+				return invalidRequest(err)
+			} else if 0 != len(jsonBody) && !isJson(req.Header.Get("Content-Type")) {
+				// some PUT requests (like seconding a nomination) have no body
+				return ErrorUnsupportedMediaType
+			}
+			return edb.Retry(func() (err error) {
+				result, err = handler(a, jsonBody)
+				return
+			})
+		}()
+		writeApiResponse(w, req, result, err)
+	}
+}
@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/stbuehler/go-vote/types"
+)
+
+/* the audit log is append-only; each entry contains the hash over its own
+ * content and the hash of the previous entry, so modifying or removing
+ * entries (except at the end) breaks the chain.
+ */
+
+const (
+	AuditVote                = "vote"
+	AuditVoteChanged         = "vote-changed"
+	AuditSecretBallot        = "secret-ballot"
+	AuditElectionClosed      = "election-closed"
+	AuditElectionOpened      = "election-reopened"
+	AuditBallotCodes         = "ballot-codes"
+	AuditElectionScheduled   = "election-scheduled"
+	AuditElectionStarted     = "election-started"
+	AuditResultsPolicy       = "results-policy"
+	AuditElectionCreated     = "election-created"
+	AuditElectionEdited      = "election-edited"
+	AuditElectionAccess      = "election-access"
+	AuditMemberAdded         = "member-added"
+	AuditMemberRemoved       = "member-removed"
+	AuditUserCreated         = "user-created"
+	AuditUserToken           = "user-token"
+	AuditNominated           = "nominated"
+	AuditNominationSeconded  = "nomination-seconded"
+	AuditNominationApproved  = "nomination-approved"
+	AuditNominationRejected  = "nomination-rejected"
+	AuditElectionNominations = "election-nominations"
+	AuditCandidatesChanged   = "candidates-changed"
+	AuditUnrankedPolicy      = "unranked-policy"
+	AuditElectionRon         = "election-ron"
+)
+
+type AuditEntry struct {
+	Seq    int64
+	Time   int64 // unix timestamp; 0: not recorded (secret ballots)
+	Action string
+	Eid    int64  // 0: not related to an election
+	Uid    int64  // 0: no user (e.g. command line)
+	Data   string // json
+	Hash   string
+}
+
+func (a AuditEntry) computeHash(prevHash string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%d\n%s\n%d\n%d\n%s", prevHash, a.Seq, a.Time, a.Action, a.Eid, a.Uid, a.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// entries must be complete and ordered by Seq
+func VerifyAuditChain(entries []AuditEntry) error {
+	prevHash := ""
+	for i, a := range entries {
+		if a.Seq != int64(i+1) {
+			return fmt.Errorf("audit entry %d: unexpected sequence number %d", i+1, a.Seq)
+		} else if a.Hash != a.computeHash(prevHash) {
+			return fmt.Errorf("audit entry %d: hash mismatch", a.Seq)
+		}
+		prevHash = a.Hash
+	}
+	return nil
+}
+
+func (etx *ElectionsTx) audit(action string, e *Election, actor *User, data interface{}) error {
+	return etx.appendAudit(etx.now().Unix(), action, e, actor, data)
+}
+
+// timestamp 0 doesn't record the time
+func (etx *ElectionsTx) appendAudit(timestamp int64, action string, e *Election, actor *User, data interface{}) error {
+	a := AuditEntry{
+		Seq:    1,
+		Time:   timestamp,
+		Action: action,
+		Data:   types.JsonMustEncodeString(data),
+	}
+	if nil != e {
+		a.Eid = e.Eid
+	}
+	if nil != actor {
+		a.Uid = actor.Uid
+	}
+	prevHash := ""
+	if last, err := etx.st.LastAuditEntry(); nil != err {
+		return internalError(err)
+	} else if nil != last {
+		a.Seq = last.Seq + 1
+		prevHash = last.Hash
+	}
+	a.Hash = a.computeHash(prevHash)
+	if err := etx.st.AppendAuditEntry(a); nil != err {
+		return internalError(err)
+	}
+	return nil
+}
+
+func (etx *ElectionsTx) AuditLog() ([]AuditEntry, error) {
+	if entries, err := etx.st.AuditLog(); nil != err {
+		return nil, internalError(err)
+	} else {
+		return entries, nil
+	}
+}
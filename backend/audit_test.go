@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/stbuehler/go-vote/types"
+	"sync"
+	"testing"
+)
+
+func TestAuditChain(t *testing.T) {
+	edb := NewMemoryDatabase()
+	const voters = 8
+	var users []*User
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		t.Fatal(err)
+	}
+	e := &Election{Name: "audited", Candidates: []Candidate{{Name: "A"}, {Name: "B"}}, Secret: true}
+	if err := etx.CreateElection(e, nil); nil != err {
+		t.Fatal(err)
+	}
+	for i := 0; i < voters; i++ {
+		user := &User{Name: fmt.Sprintf("voter%d", i), Email: sql.NullString{String: fmt.Sprintf("voter%d@example.com", i), Valid: true}}
+		if err := etx.CreateUser(user, nil); nil != err {
+			t.Fatal(err)
+		} else if err := etx.AddElectionMember(e, user, "", nil); nil != err {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	if err := etx.Commit(); nil != err {
+		t.Fatal(err)
+	}
+
+	// concurrent votes must still produce a single chain
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user *User) {
+			defer wg.Done()
+			err := edb.Retry(func() error {
+				etx, err := edb.StartTransaction()
+				if nil != err {
+					return err
+				}
+				defer etx.Rollback()
+				if e, err := etx.ElectionByName("audited"); nil != err {
+					return err
+				} else if _, err := etx.ElectionVote(e, user, types.Ranking{0, 1}); nil != err {
+					return err
+				}
+				return etx.Commit()
+			})
+			if nil != err {
+				t.Error(err)
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	etx, err = edb.StartTransaction()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer etx.Rollback()
+	entries, err := etx.AuditLog()
+	if nil != err {
+		t.Fatal(err)
+	} else if err := VerifyAuditChain(entries); nil != err {
+		t.Fatal(err)
+	}
+	secretBallots := 0
+	for _, a := range entries {
+		if AuditSecretBallot == a.Action {
+			secretBallots++
+			if 0 != a.Time {
+				t.Errorf("secret ballot entry %d records the time", a.Seq)
+			}
+		}
+	}
+	if voters != secretBallots {
+		t.Errorf("expected %d secret ballot entries, got %d", voters, secretBallots)
+	}
+
+	// modified entries break the chain
+	entries[1].Data = `{}`
+	if nil == VerifyAuditChain(entries) {
+		t.Error("modified audit entry not detected")
+	}
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+/* ballot codes are handed out on paper slips; a code can be used to vote
+ * in a single election without an account. the code is not linked to a
+ * person: on first use an anonymous user is created for the code.
+ */
+
+// no 0/O or 1/I to avoid confusion when typing codes from paper
+const ballotCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const ballotCodeLength = 12
+const ballotCodeGroupLength = 4
+
+// per request
+const maxBallotCodes = 10000
+
+var ErrorInvalidBallotCodeCount = newFieldError("invalid_ballot_code_count", "count", "Invalid number of ballot codes")
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); nil != err {
+		return nil, err
+	}
+	return b, nil
+}
+
+func newBallotCode() (string, error) {
+	b, err := randomBytes(ballotCodeLength)
+	if nil != err {
+		return "", err
+	}
+	for i, v := range b {
+		// len(ballotCodeAlphabet) divides 256: no bias
+		b[i] = ballotCodeAlphabet[int(v)%len(ballotCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// strip separators and whitespace, uppercase
+func NormalizeBallotCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if '-' == r || ' ' == r || '\t' == r {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+// split code into groups for printing
+func FormatBallotCode(code string) string {
+	var groups []string
+	for len(code) > ballotCodeGroupLength {
+		groups = append(groups, code[:ballotCodeGroupLength])
+		code = code[ballotCodeGroupLength:]
+	}
+	return strings.Join(append(groups, code), "-")
+}
+
+func (etx *ElectionsTx) GenerateBallotCodes(e *Election, actor *User, count int) ([]string, error) {
+	if count <= 0 || count > maxBallotCodes {
+		return nil, ErrorInvalidBallotCodeCount
+	}
+	codes := make([]string, 0, count)
+	for len(codes) < count {
+		code, err := newBallotCode()
+		if nil != err {
+			return nil, internalError(err)
+		}
+		if err := etx.st.CreateBallotCode(e.Eid, code); errStorageConflict == err {
+			// collision, try again
+		} else if nil != err {
+			return nil, internalError(err)
+		} else {
+			codes = append(codes, code)
+		}
+	}
+	if err := etx.audit(AuditBallotCodes, e, actor, map[string]int{"count": count}); nil != err {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (etx *ElectionsTx) FindOrCreateBallotCodeUser(code string) (*User, error) {
+	if !etx.features.BallotCodes {
+		return nil, ErrorFeatureDisabled
+	}
+	code = NormalizeBallotCode(code)
+	eid, uid, err := etx.st.BallotCode(code)
+	if errStorageNotFound == err {
+		return nil, ErrorInvalidBallotCode
+	} else if nil != err {
+		return nil, internalError(err)
+	}
+	if 0 == uid {
+		// random name: must not be linkable to the position of the code on the printed sheets
+		suffix, err := randomBytes(8)
+		if nil != err {
+			return nil, internalError(err)
+		}
+		created, err := etx.st.CreateUser(&User{Name: "Ballot " + hex.EncodeToString(suffix)})
+		if nil != err {
+			return nil, internalError(err)
+		}
+		if err := etx.st.SetBallotCodeUser(code, created); errStorageConflict == err {
+			// another request used the code first (and committed); use
+			// its user, so the code still only casts one ballot
+			if err := etx.st.DeleteUser(created); nil != err {
+				return nil, internalError(err)
+			} else if _, uid, err = etx.st.BallotCode(code); nil != err {
+				return nil, internalError(err)
+			}
+		} else if nil != err {
+			return nil, internalError(err)
+		} else {
+			uid = created
+		}
+	}
+	if user, err := etx.st.UserByUid(uid); nil != err {
+		return nil, internalError(err)
+	} else {
+		user.BallotCodeEid = eid
+		return user, nil
+	}
+}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"testing"
+)
+
+// reads the ballot code as unused once, like a transaction that read it
+// before a concurrent first use committed
+type staleBallotCodeTx struct {
+	StorageTx
+	stale bool
+}
+
+func (t *staleBallotCodeTx) BallotCode(code string) (int64, int64, error) {
+	eid, uid, err := t.StorageTx.BallotCode(code)
+	if t.stale {
+		t.stale = false
+		uid = 0
+	}
+	return eid, uid, err
+}
+
+func TestBallotCodeClaimedTwice(t *testing.T) {
+	edb := NewMemoryDatabase()
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer etx.Rollback()
+	e := &Election{Name: "codes", Candidates: []Candidate{{Name: "A"}, {Name: "B"}}}
+	if err := etx.CreateElection(e, nil); nil != err {
+		t.Fatal(err)
+	}
+	codes, err := etx.GenerateBallotCodes(e, nil, 1)
+	if nil != err {
+		t.Fatal(err)
+	}
+	first, err := etx.FindOrCreateBallotCodeUser(codes[0])
+	if nil != err {
+		t.Fatal(err)
+	}
+	users := len(etx.st.(*memoryStorageTx).state.users)
+
+	// the storage only claims unused codes
+	if err := etx.st.SetBallotCodeUser(codes[0], first.Uid+1); errStorageConflict != err {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	// the second use must end up with the user of the first one
+	etx.st = &staleBallotCodeTx{StorageTx: etx.st, stale: true}
+	second, err := etx.FindOrCreateBallotCodeUser(codes[0])
+	if nil != err {
+		t.Fatal(err)
+	} else if first.Uid != second.Uid {
+		t.Fatalf("code claimed by users %d and %d", first.Uid, second.Uid)
+	} else if _, uid, err := etx.st.BallotCode(codes[0]); nil != err || first.Uid != uid {
+		t.Fatalf("code belongs to user %d (%v), expected %d", uid, err, first.Uid)
+	} else if n := len(etx.st.(*staleBallotCodeTx).StorageTx.(*memoryStorageTx).state.users); users != n {
+		t.Fatalf("expected %d users, got %d", users, n)
+	}
+}
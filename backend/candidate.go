@@ -0,0 +1,357 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stbuehler/go-vote/types"
+	"net/url"
+	"strings"
+)
+
+/* candidates are stored as records; their position in
+ * Election.Candidates is the index used in rankings, tallies and results.
+ * the id stays the same when candidates are edited.
+ *
+ * after voting opened candidates can only be withdrawn or added (see
+ * ChangeCandidates); the cast ballots are rewritten for the new list, and
+ * the voters are flagged to review their ballot. rewritten ballots get new
+ * hashes; the audit entry maps the hashes of issued receipts to them, and
+ * the mappings are published with the ballots.
+ *
+ * the reserved "reopen nominations" (RON) candidate is always the last
+ * one; it is only added or removed with SetElectionRon.
+ */
+
+var ErrorInvalidCandidates = newFieldError("invalid_candidates", "candidates", "Need at least two distinct, non-empty candidates")
+var ErrorInvalidCandidateUrl = newFieldError("invalid_candidate_url", "candidates", "Candidate links and images need http or https URLs")
+var ErrorCandidatesLocked = newFieldError("candidates_locked", "candidates", "Candidates can only be withdrawn or added after voting opened")
+var ErrorRevoteSecret = newFieldError("revote_secret", "revote", "Secret ballots can't be reset for a new vote")
+var ErrorRonLocked = newError("ron_locked", "Reopen nominations (RON) can only be enabled or disabled before voting opened")
+
+// what changed for a voter since they cast their ballot
+type BallotReview string
+
+const (
+	// withdrawn candidates were removed, new candidates ranked last
+	BallotAdjusted BallotReview = "adjusted"
+	// the ballot was reset; the voter needs to vote again
+	BallotReset BallotReview = "revote"
+)
+
+type Candidate struct {
+	Id          int64  `json:"id"` // 0 for new candidates
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"` // Markdown
+	Url         string `json:"url,omitempty"`
+	Image       string `json:"image,omitempty"`    // URL
+	Reserved    bool   `json:"reserved,omitempty"` // RON pseudo-candidate
+}
+
+// the reserved "reopen nominations" candidate
+func RonCandidate() Candidate {
+	return Candidate{
+		Name:        "Reopen nominations (RON)",
+		Description: "None of the above: rank RON above the candidates you don't want to be elected. If RON wins the election failed, and candidates ranked below RON in the result are ineligible.",
+		Reserved:    true,
+	}
+}
+
+// index of the RON candidate, -1 if the election doesn't have one
+func (e *Election) Ron() int {
+	if n := len(e.Candidates); 0 != n && e.Candidates[n-1].Reserved {
+		return n - 1
+	}
+	return -1
+}
+
+// replaces the reserved candidates in candidates with the ones of e (at
+// the end)
+func keepReserved(e *Election, candidates []Candidate) []Candidate {
+	reserved := make(map[int64]bool)
+	for _, c := range e.Candidates {
+		if c.Reserved {
+			reserved[c.Id] = true
+		}
+	}
+	var result []Candidate
+	for _, c := range candidates {
+		if !c.Reserved && (0 == c.Id || !reserved[c.Id]) {
+			result = append(result, c)
+		}
+	}
+	if ron := e.Ron(); -1 != ron {
+		result = append(result, e.Candidates[ron])
+	}
+	return result
+}
+
+func CandidateNames(candidates []Candidate) []string {
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// empty or absolute http(s) URL
+func validCandidateUrl(rawUrl string) bool {
+	if 0 == len(rawUrl) {
+		return true
+	}
+	u, err := url.Parse(rawUrl)
+	return nil == err && ("http" == u.Scheme || "https" == u.Scheme) && 0 != len(u.Host)
+}
+
+// candidates are nominated during the nomination phase; voting needs at
+// least two
+func minimumCandidates(e *Election) int {
+	if e.Nominating {
+		return 0
+	}
+	return 2
+}
+
+func checkCandidates(candidates []Candidate, minimum int) error {
+	if len(candidates) < minimum {
+		return ErrorInvalidCandidates
+	}
+	seen := make(map[string]bool)
+	for i, c := range candidates {
+		if 0 == len(strings.TrimSpace(c.Name)) || seen[c.Name] {
+			return ErrorInvalidCandidates
+		} else if c.Reserved && i != len(candidates)-1 {
+			// only RON, as last candidate
+			return ErrorInvalidCandidates
+		} else if !validCandidateUrl(c.Url) || !validCandidateUrl(c.Image) {
+			return ErrorInvalidCandidateUrl
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// whether candidates are the same records in the same order (details can
+// differ)
+func sameCandidates(a, b []Candidate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if 0 == a[i].Id || a[i].Id != b[i].Id {
+			return false
+		}
+	}
+	return true
+}
+
+// whether candidates can still be added, removed or reordered: before
+// voting opens, and while no ballots were cast (an election could have
+// been rescheduled)
+func (etx *ElectionsTx) candidatesLocked(e *Election) (bool, error) {
+	if state := etx.ElectionState(e); ElectionOpen == state || ElectionClosed == state {
+		return true, nil
+	} else if ballots, err := etx.st.Ballots(e.Eid, e.Secret); nil != err {
+		return false, internalError(err)
+	} else {
+		return 0 != len(ballots), nil
+	}
+}
+
+// after candidates were added or removed (without ballots): start with an
+// empty tally of the new size
+func (etx *ElectionsTx) resetTally(e *Election) error {
+	if err := etx.st.SetTally(e.Eid, types.PairwisePreferences(types.NewPairwise(len(e.Candidates)))); nil != err {
+		return internalError(err)
+	} else if e.Closed {
+		return etx.freezeResults(e)
+	}
+	return nil
+}
+
+func (etx *ElectionsTx) setCandidates(e *Election, candidates []Candidate) error {
+	if stored, err := etx.st.SetCandidates(e.Eid, candidates); errStorageNotFound == err {
+		// ids of other elections
+		return ErrorInvalidCandidates
+	} else if nil != err {
+		return internalError(err)
+	} else {
+		e.Candidates = stored
+		return nil
+	}
+}
+
+// withdraws candidates (by id) and appends new ones (before RON), also
+// after voting opened. cast ballots are rewritten: withdrawn candidates
+// are removed (ranks compacted) and new candidates share the lowest rank;
+// the ballot ids stay, but the hashes change. with revote the votes are
+// reset instead (not possible for secret ballots). voters are flagged to
+// review their ballot.
+func (etx *ElectionsTx) ChangeCandidates(e *Election, actor *User, withdraw []int64, add []Candidate, revote bool) error {
+	if etx.IsElectionClosed(e) {
+		return ErrorElectionClosed
+	} else if revote && e.Secret {
+		return ErrorRevoteSecret
+	}
+	withdrawn := make(map[int64]bool, len(withdraw))
+	for _, cid := range withdraw {
+		withdrawn[cid] = true
+	}
+	// mapping[old index] = new index, -1 if withdrawn
+	mapping := make([]int, len(e.Candidates))
+	var candidates []Candidate
+	var withdrawnNames []string
+	for i, c := range e.Candidates {
+		if c.Reserved {
+			if withdrawn[c.Id] {
+				return ErrorRonLocked
+			}
+			continue
+		} else if withdrawn[c.Id] {
+			mapping[i] = -1
+			withdrawnNames = append(withdrawnNames, c.Name)
+			delete(withdrawn, c.Id)
+		} else {
+			mapping[i] = len(candidates)
+			candidates = append(candidates, c)
+		}
+	}
+	if 0 != len(withdrawn) {
+		// ids of other elections
+		return ErrorInvalidCandidates
+	}
+	for _, c := range add {
+		c.Id = 0
+		c.Reserved = false
+		candidates = append(candidates, c)
+	}
+	if ron := e.Ron(); -1 != ron {
+		mapping[ron] = len(candidates)
+		candidates = append(candidates, e.Candidates[ron])
+	}
+	if err := checkCandidates(candidates, minimumCandidates(e)); nil != err {
+		return err
+	} else if 0 == len(withdrawnNames) && 0 == len(add) {
+		return nil
+	}
+
+	ballots, err := etx.st.Ballots(e.Eid, e.Secret)
+	if nil != err {
+		return internalError(err)
+	}
+	var hashes map[string]string // old ballot hash -> new hash
+	if revote {
+		if err := etx.st.ResetVotes(e.Eid); nil != err {
+			return internalError(err)
+		}
+	} else if 0 != len(ballots) {
+		// receipts carry the old hashes
+		hashes = make(map[string]string, len(ballots))
+		for _, b := range ballots {
+			if len(b.Ranking) != len(mapping) {
+				return internalError(fmt.Errorf("ChangeCandidates: inconsistent ranking lengths: %d != %d", len(mapping), len(b.Ranking)))
+			}
+			ranking := b.Ranking.Remap(mapping, len(candidates))
+			if err := etx.st.SetBallotRanking(e.Eid, e.Secret, b.Id, ranking); nil != err {
+				return internalError(err)
+			}
+			hashes[b.Hash] = types.BallotHash(b.Id, ranking)
+		}
+		if err := etx.st.FlagReview(e.Eid, e.Secret); nil != err {
+			return internalError(err)
+		}
+	}
+	if err := etx.setCandidates(e, candidates); nil != err {
+		return err
+	}
+	// count the rewritten ballots
+	if prefs, err := etx.countPairwisePreferences(e); nil != err {
+		return err
+	} else if err := etx.st.SetTally(e.Eid, prefs); nil != err {
+		return internalError(err)
+	}
+	if err := etx.audit(AuditCandidatesChanged, e, actor, map[string]interface{}{
+		"withdrawn":  withdrawnNames,
+		"added":      CandidateNames(add),
+		"revote":     revote,
+		"ballots":    len(ballots),
+		"hashes":     hashes,
+		"candidates": e.Candidates,
+	}); nil != err {
+		return err
+	}
+	etx.notifyAfterCommit(e, EventCandidatesChanged)
+	return nil
+}
+
+// old ballot hash -> new hash from all candidates-changed audit entries of
+// the election; a ballot adjusted several times needs several steps
+func (etx *ElectionsTx) AdjustedBallotHashes(e *Election) (map[string]string, error) {
+	entries, err := etx.AuditLog()
+	if nil != err {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	for _, a := range entries {
+		if e.Eid != a.Eid || AuditCandidatesChanged != a.Action {
+			continue
+		}
+		var data struct {
+			Hashes map[string]string
+		}
+		if err := json.Unmarshal([]byte(a.Data), &data); nil != err {
+			return nil, internalError(fmt.Errorf("AdjustedBallotHashes: audit entry %d: %w", a.Seq, err))
+		}
+		// later changes win: a ballot can get an earlier hash again
+		for old, h := range data.Hashes {
+			hashes[old] = h
+		}
+	}
+	return hashes, nil
+}
+
+// whether the candidates changed after user voted; "" if not (or if
+// the ballot was reviewed since)
+func (etx *ElectionsTx) BallotReview(user *User, e *Election) (BallotReview, error) {
+	if nil == user {
+		return "", nil
+	} else if flagged, err := etx.st.NeedsReview(e.Eid, user.Uid, e.Secret); nil != err {
+		return "", internalError(err)
+	} else if !flagged {
+		return "", nil
+	} else if voted, err := etx.hasVoted(user, e); nil != err {
+		return "", err
+	} else if voted {
+		return BallotAdjusted, nil
+	} else {
+		return BallotReset, nil
+	}
+}
+
+// adds or removes the RON candidate; only while the candidates can be
+// changed (see candidatesLocked)
+func (etx *ElectionsTx) SetElectionRon(e *Election, actor *User, enabled bool) error {
+	if enabled == (-1 != e.Ron()) {
+		return nil
+	} else if locked, err := etx.candidatesLocked(e); nil != err {
+		return err
+	} else if locked {
+		return ErrorRonLocked
+	}
+	candidates := e.Candidates
+	if enabled {
+		candidates = append(candidates[:len(candidates):len(candidates)], RonCandidate())
+	} else {
+		candidates = candidates[:e.Ron()]
+	}
+	if err := checkCandidates(candidates, minimumCandidates(e)); nil != err {
+		return err
+	} else if err := etx.setCandidates(e, candidates); nil != err {
+		return err
+	} else if err := etx.resetTally(e); nil != err {
+		return err
+	}
+	return etx.audit(AuditElectionRon, e, actor, map[string]interface{}{
+		"ron":        enabled,
+		"candidates": e.Candidates,
+	})
+}
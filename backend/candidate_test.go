@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/stbuehler/go-vote/types"
+	"testing"
+)
+
+// adjusted ballots get new hashes; the audit entry maps the receipt hashes
+// to them, and the change is announced after commit
+func TestChangeCandidatesHashes(t *testing.T) {
+	var events []ElectionEvent
+	edb := NewMemoryDatabase().WithNotifier(recordingNotifier{&events})
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer etx.Rollback()
+	voter := &User{Name: "voter", Email: sql.NullString{String: "voter@example.com", Valid: true}}
+	e := &Election{Name: "changed", Candidates: []Candidate{{Name: "A"}, {Name: "B"}, {Name: "C"}}, Open: true}
+	if err := etx.CreateUser(voter, nil); nil != err {
+		t.Fatal(err)
+	} else if err := etx.CreateElection(e, nil); nil != err {
+		t.Fatal(err)
+	} else if err := etx.AddElectionMember(e, voter, "", nil); nil != err {
+		t.Fatal(err)
+	}
+	receipt, err := etx.ElectionVote(e, voter, types.Ranking{2, 1, 0})
+	if nil != err {
+		t.Fatal(err)
+	} else if err := etx.ChangeCandidates(e, nil, []int64{e.Candidates[1].Id}, []Candidate{{Name: "D"}}, false); nil != err {
+		t.Fatal(err)
+	} else if 0 != len(events) {
+		t.Fatalf("notified before commit: %v", events)
+	}
+
+	ballots, err := etx.st.Ballots(e.Eid, e.Secret)
+	if nil != err {
+		t.Fatal(err)
+	} else if 1 != len(ballots) || receipt.Ballot != ballots[0].Id {
+		t.Fatalf("unexpected ballots %v", ballots)
+	} else if receipt.Hash == ballots[0].Hash {
+		t.Fatal("expected a new ballot hash")
+	}
+	entries, err := etx.AuditLog()
+	if nil != err {
+		t.Fatal(err)
+	}
+	last := entries[len(entries)-1]
+	var data struct {
+		Hashes map[string]string
+	}
+	if AuditCandidatesChanged != last.Action {
+		t.Fatalf("expected %s audit entry, got %s", AuditCandidatesChanged, last.Action)
+	} else if err := json.Unmarshal([]byte(last.Data), &data); nil != err {
+		t.Fatal(err)
+	} else if ballots[0].Hash != data.Hashes[receipt.Hash] {
+		t.Fatalf("receipt hash %s not mapped to %s: %v", receipt.Hash, ballots[0].Hash, data.Hashes)
+	}
+	// published with the ballots
+	if adjusted, err := etx.AdjustedBallotHashes(e); nil != err {
+		t.Fatal(err)
+	} else if ballots[0].Hash != adjusted[receipt.Hash] {
+		t.Fatalf("receipt hash %s not published as adjusted to %s: %v", receipt.Hash, ballots[0].Hash, adjusted)
+	}
+
+	if err := etx.Commit(); nil != err {
+		t.Fatal(err)
+	} else if 2 != len(events) || EventVoteCast != events[0] || EventCandidatesChanged != events[1] {
+		t.Fatalf("expected vote and candidates events, got %v", events)
+	}
+}
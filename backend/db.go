@@ -1,68 +1,65 @@
 package backend
 
 import (
-	"database/sql"
+	"time"
 )
 
 type ElectionsDb struct {
-	db *sql.DB
+	storage  Storage
+	now      func() time.Time
+	notifier Notifier
+	hub      *Hub
+	tickets  *ticketStore
+	features Features
+	// elections the scheduler couldn't end the nomination phase for
+	nominationFailures *scheduleFailures
+}
+
+func NewElectionsDb(storage Storage) ElectionsDb {
+	return ElectionsDb{
+		storage:  storage,
+		now:      time.Now,
+		notifier: LogNotifier{},
+		hub:      NewHub(),
+		tickets:  newTicketStore(),
+		features: DefaultFeatures(),
+
+		nominationFailures: newScheduleFailures(),
+	}
 }
 
 func (edb ElectionsDb) StartTransaction() (*ElectionsTx, error) {
-	if tx, err := edb.db.Begin(); nil != err {
+	if st, err := edb.storage.Begin(); nil != err {
 		return nil, err
 	} else {
-		return &ElectionsTx{tx: tx}, nil
+		return &ElectionsTx{st: st, now: edb.now, features: edb.features, notify: edb.Notify}, nil
 	}
 }
 
-func ConnectDatabase(db *sql.DB) (ElectionsDb, error) {
-	db.Exec(`PRAGMA foreign_keys = ON`)
-	if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS user (
-	uid INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL,
-	email TEXT UNIQUE,
-	token TEXT UNIQUE,
-	siteadmin BOOLEAN NOT NULL DEFAULT 0
-)
-`); nil != err {
-		return ElectionsDb{}, err
-	}
+func (edb ElectionsDb) Close() error {
+	return edb.storage.Close()
+}
 
-	if _, err := db.Exec(`
-CREATE UNIQUE INDEX IF NOT EXISTS user_unique_unregistered ON user (name) WHERE email IS NULL;
-`); nil != err {
-		return ElectionsDb{}, err
-	}
+// replace the clock used for schedules and timestamps
+func (edb ElectionsDb) WithClock(now func() time.Time) ElectionsDb {
+	edb.now = now
+	return edb
+}
 
-	if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS election (
-	eid INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT UNIQUE NOT NULL,
-	title TEXT NOT NULL DEFAULT '',
-	candidates TEXT NOT NULL,
-	closed BOOLEAN NOT NULL DEFAULT 0,
-	public BOOLEAN NOT NULL DEFAULT 0,
-	open BOOLEAN NOT NULL DEFAULT 0,
-	editopen BOOLEAN NOT NULL DEFAULT 0
-);
-`); nil != err {
-		return ElectionsDb{}, err
-	}
+func (edb ElectionsDb) WithNotifier(notifier Notifier) ElectionsDb {
+	edb.notifier = notifier
+	return edb
+}
 
-	if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS vote (
-	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
-	uid INTEGER NOT NULL REFERENCES user ON DELETE RESTRICT ON UPDATE CASCADE,
-	ranking TEXT NOT NULL,
-	UNIQUE (eid, uid)
-);
-`); nil != err {
-		return ElectionsDb{}, err
-	}
+// call after the transaction committed (changes in ElectionsTx methods
+// notify on their own)
+func (edb ElectionsDb) Notify(e *Election, event ElectionEvent) {
+	edb.notifier.NotifyElection(e, event)
+	edb.hub.NotifyElection(e, event)
+}
 
-	return ElectionsDb{
-		db: db,
-	}, nil
+// ends all event streams; call on shutdown, before waiting for running
+// requests
+func (edb ElectionsDb) CloseSubscriptions() {
+	edb.hub.Close()
 }
@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+/* errors returned by ElectionsTx are *Error values with a stable code;
+ * anything else is wrapped as internal error. the api maps codes to HTTP
+ * status codes.
+ */
+type Error struct {
+	Code    string
+	Message string
+	Field   string // request field causing the error (like "auth.name"), if any
+	Err     error  // cause of internal errors
+}
+
+func newError(code string, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func newFieldError(code string, field string, message string) *Error {
+	return &Error{Code: code, Message: message, Field: field}
+}
+
+func (e *Error) Error() string {
+	if nil != e.Err {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+const (
+	CodeInternal       = "internal"
+	CodeBusy           = "busy"
+	CodeInvalidRequest = "invalid_request"
+)
+
+func internalError(err error) error {
+	return &Error{Code: CodeInternal, Message: "Internal server error", Err: err}
+}
+
+func invalidRequest(err error) error {
+	e := &Error{Code: CodeInvalidRequest, Message: "Invalid request: " + err.Error()}
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		e.Field = strings.ToLower(typeErr.Field)
+	}
+	return e
+}
+
+func invalidField(field string, err error) error {
+	return &Error{Code: CodeInvalidRequest, Message: "Invalid request: " + err.Error(), Field: field}
+}
+
+// code of err; CodeInternal for errors not created by this package
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeInternal
+}
+
+const retryAttempts = 5
+
+// runs f (which should run a complete transaction) again while it fails
+// because the database is busy or a transaction conflicted
+func (edb ElectionsDb) Retry(f func() error) error {
+	delay := 10 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if nil == err || !edb.storage.Retryable(err) {
+			return err
+		} else if attempt == retryAttempts {
+			return &Error{Code: CodeBusy, Message: "Database is busy, try again later", Err: err}
+		}
+		logInfof("Retrying transaction: %v", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
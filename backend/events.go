@@ -0,0 +1,159 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/stbuehler/go-vote/types"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* GET /api/v1/elections/{name}/events streams the results as server-sent
+ * events: a "results" event (same data as GET .../results) when connected
+ * and after each change, or an "error" event (error envelope) while the
+ * results are not visible.
+ *
+ * GET /api/v1/elections/{name}/turnout/events does the same with "turnout"
+ * events (same data as GET .../turnout).
+ *
+ * EventSource can't set headers, so a "ticket" query parameter (see
+ * ticket.go) is accepted instead of the Authorization header.
+ *
+ * ballots of secret elections are only unlinked from the voters if nobody
+ * can watch the results change with each vote: their results are not
+ * visible (see CanSeeResults) and votes aren't announced before the
+ * election closed.
+ */
+
+const eventsHeartbeat = 30 * time.Second
+
+// the server's WriteTimeout would end the stream; each write gets its own
+const eventsWriteTimeout = 10 * time.Second
+
+// stream is "results" or "turnout"
+func eventsResource(path string) (election string, stream string, ok bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || "elections" != parts[0] || 0 == len(parts[1]) || "events" != parts[len(parts)-1] {
+		return "", "", false
+	} else if 3 == len(parts) {
+		return parts[1], "results", true
+	} else if 4 == len(parts) && "turnout" == parts[2] {
+		return parts[1], "turnout", true
+	}
+	return "", "", false
+}
+
+func (edb ElectionsDb) eventsAuth(req *http.Request) (auth, error) {
+	if a, err := parseAuthorization(req.Header.Get("Authorization")); nil != err {
+		return auth{}, err
+	} else if (auth{}) != a {
+		return a, nil
+	}
+	id := req.URL.Query().Get("ticket")
+	if 0 == len(id) {
+		return auth{}, nil
+	} else if a, ok := edb.tickets.find(id, edb.now()); !ok {
+		return auth{}, ErrorInvalidTicket
+	} else {
+		return a, nil
+	}
+}
+
+func (edb ElectionsDb) findEventsElection(name string, a auth) (*Election, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else {
+		return etx.FindElectionByName(name, user)
+	}
+}
+
+func (edb ElectionsDb) serveEvents(w http.ResponseWriter, req *http.Request, name string, stream string) {
+	load := edb.apiResults
+	if "turnout" == stream {
+		load = edb.apiTurnout
+	}
+
+	var a auth
+	var e *Election
+	err := func() (err error) {
+		if "GET" != req.Method {
+			w.Header().Set("Allow", "GET")
+			return ErrorMethodNotAllowed
+		} else if !accepts(req.Header.Get("Accept"), "text/event-stream") {
+			return ErrorNotAcceptable
+		} else if a, err = edb.eventsAuth(req); nil != err {
+			return err
+		}
+		return edb.Retry(func() (err error) {
+			e, err = edb.findEventsElection(name, a)
+			return
+		})
+	}()
+	if nil != err {
+		writeApiResponse(w, req, nil, err)
+		return
+	}
+
+	events, cancel := edb.hub.Subscribe(e.Eid)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(200)
+
+	rc := http.NewResponseController(w)
+	send := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); nil != err {
+			logDebugf("Request[%+q]: event stream closed: %v", req.URL.EscapedPath(), err)
+			return false
+		} else if err := rc.Flush(); nil != err {
+			logErrorf("Request[%+q]: event stream can't be flushed: %v", req.URL.EscapedPath(), err)
+			return false
+		}
+		return true
+	}
+	sendData := func() bool {
+		var result interface{}
+		if err := edb.Retry(func() (err error) {
+			result, err = load(name, a)
+			return
+		}); nil != err {
+			status, body := apiErrorResponse(err)
+			logApiError(req, status, err)
+			return send("event: error\ndata: %s\n\n", types.JsonMustEncode(body))
+		}
+		return send("event: %s\ndata: %s\n\n", stream, types.JsonMustEncode(result))
+	}
+
+	if !sendData() {
+		return
+	}
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			} else if EventVoteCast == event && "results" == stream && e.Secret {
+				continue
+			} else if !sendData() {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(": keep-alive\n\n") {
+				return
+			}
+		}
+	}
+}
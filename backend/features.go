@@ -0,0 +1,30 @@
+package backend
+
+var ErrorFeatureDisabled = newError("feature_disabled", "Feature is disabled on this server")
+
+// optional parts of the server; all enabled by default
+type Features struct {
+	// vote with single-use ballot codes
+	BallotCodes bool
+	// publish anonymised ballots of closed elections
+	PublishBallots bool
+	// audit log for site admins via the api
+	AuditApi bool
+}
+
+func DefaultFeatures() Features {
+	return Features{
+		BallotCodes:    true,
+		PublishBallots: true,
+		AuditApi:       true,
+	}
+}
+
+func (edb ElectionsDb) WithFeatures(features Features) ElectionsDb {
+	edb.features = features
+	return edb
+}
+
+func (edb ElectionsDb) Features() Features {
+	return edb.features
+}
@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"sync"
+)
+
+/* in-process publish/subscribe of election events (like for live result
+ * pages). subscribers only get a signal and reload what they need, so
+ * events are dropped while a subscriber is still busy with the previous
+ * one.
+ */
+type Hub struct {
+	mutex       sync.Mutex
+	subscribers map[int64]map[chan ElectionEvent]bool
+	closed      bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[int64]map[chan ElectionEvent]bool)}
+}
+
+func (h *Hub) NotifyElection(e *Election, event ElectionEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.subscribers[e.Eid] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// events is closed after cancel or when the hub is closed
+func (h *Hub) Subscribe(eid int64) (events <-chan ElectionEvent, cancel func()) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ch := make(chan ElectionEvent, 1)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if nil == h.subscribers[eid] {
+		h.subscribers[eid] = make(map[chan ElectionEvent]bool)
+	}
+	h.subscribers[eid][ch] = true
+	return ch, func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		if h.subscribers[eid][ch] {
+			delete(h.subscribers[eid], ch)
+			if 0 == len(h.subscribers[eid]) {
+				delete(h.subscribers, eid)
+			}
+			close(ch)
+		}
+	}
+}
+
+// ends all subscriptions (on shutdown)
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for eid, subscribers := range h.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+		delete(h.subscribers, eid)
+	}
+}
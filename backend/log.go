@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"fmt"
+	"log"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "error"}
+
+var logLevel = LogInfo
+
+func (level LogLevel) String() string {
+	return logLevelNames[level]
+}
+
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if name == levelName {
+			return LogLevel(level), nil
+		}
+	}
+	return LogInfo, fmt.Errorf("Unknown log level %+q", name)
+}
+
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+}
+
+// rankings of cast votes are only logged with debug level
+func logDebugf(format string, v ...interface{}) {
+	if logLevel <= LogDebug {
+		log.Printf(format, v...)
+	}
+}
+
+func logInfof(format string, v ...interface{}) {
+	if logLevel <= LogInfo {
+		log.Printf(format, v...)
+	}
+}
+
+func logErrorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrorSchemaOutdated = errors.New("Database schema is outdated, run migrate")
+var ErrorSchemaTooNew = errors.New("Database schema is newer than this version of go-vote")
+
+/* the schema of a database is described by an ordered list of migrations;
+ * the "schema_version" table records which migrations were applied.
+ *
+ * migrations are only ever appended: never modify a released migration,
+ * add a new one instead.
+ */
+type Migration struct {
+	Version     int
+	Description string
+	statements  []string
+}
+
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	applied BIGINT NOT NULL
+)`
+
+func (d *sqlDialect) latestVersion() int {
+	return len(d.migrations)
+}
+
+func (d *sqlDialect) schemaVersion(tx *sql.Tx) (int, error) {
+	var version sql.NullInt64
+	if err := tx.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); nil != err {
+		return 0, fmt.Errorf("reading schema version failed: %w", err)
+	} else if version.Valid {
+		return int(version.Int64), nil
+	}
+	// databases created before migrations existed
+	if nil != d.legacyVersion {
+		return d.legacyVersion(tx)
+	}
+	return 0, nil
+}
+
+// applies all pending migrations in a single transaction, which is rolled
+// back if dryRun is set. returns the pending migrations.
+func (d *sqlDialect) migrate(db *sql.DB, dryRun bool) ([]Migration, error) {
+	tx, err := db.Begin()
+	if nil != err {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(schemaVersionTable); nil != err {
+		return nil, fmt.Errorf("creating schema_version failed: %w", err)
+	}
+	version, err := d.schemaVersion(tx)
+	if nil != err {
+		return nil, err
+	} else if version > d.latestVersion() {
+		return nil, ErrorSchemaTooNew
+	}
+
+	pending := d.migrations[version:]
+	for _, m := range pending {
+		for _, stmt := range m.statements {
+			if _, err := tx.Exec(stmt); nil != err {
+				return nil, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+			}
+		}
+		if _, err := tx.Exec(d.rebind(`INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?)`), m.Version, m.Description, time.Now().Unix()); nil != err {
+			return nil, fmt.Errorf("recording migration %d failed: %w", m.Version, err)
+		}
+	}
+
+	if dryRun {
+		return pending, nil
+	}
+	return pending, tx.Commit()
+}
+
+func migrateDatabase(db *sql.DB, dialect *sqlDialect, dryRun bool) ([]Migration, error) {
+	for _, stmt := range dialect.setup {
+		if _, err := db.Exec(stmt); nil != err {
+			return nil, err
+		}
+	}
+	return dialect.migrate(db, dryRun)
+}
+
+// check the schema is up to date; new databases are created directly
+func (d *sqlDialect) checkSchema(db *sql.DB) error {
+	tx, err := db.Begin()
+	if nil != err {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(schemaVersionTable); nil != err {
+		return fmt.Errorf("creating schema_version failed: %w", err)
+	}
+	version, err := d.schemaVersion(tx)
+	if nil != err {
+		return err
+	} else if version > d.latestVersion() {
+		return ErrorSchemaTooNew
+	} else if 0 == version {
+		tx.Rollback()
+		_, err := d.migrate(db, false)
+		return err
+	} else if version < d.latestVersion() {
+		return ErrorSchemaOutdated
+	}
+	return tx.Commit()
+}
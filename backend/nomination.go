@@ -0,0 +1,325 @@
+package backend
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+/* nominations: while an election is in its nomination phase registered
+ * members propose candidates and second the nominations of others.
+ * election managers approve nominations (with enough seconds), which adds
+ * them to the candidates. voting starts when the nomination phase ends,
+ * and the candidate list is locked from then on.
+ */
+
+var ErrorElectionNominating = newError("election_nominating", "Voting starts after the nomination phase")
+var ErrorNominationsClosed = newError("nominations_closed", "Nominations are closed")
+var ErrorNominationsRegistered = newError("nominations_registered", "Only registered users can nominate candidates")
+var ErrorNominationNotFound = newError("nomination_not_found", "Nomination not found")
+var ErrorNominationDecided = newError("nomination_decided", "Nomination was already approved or rejected")
+var ErrorOwnNomination = newError("own_nomination", "Nominations can't be seconded by the nominator")
+var ErrorNotEnoughSeconds = newError("not_enough_seconds", "Nomination doesn't have enough seconds yet")
+var ErrorInvalidNomination = newFieldError("invalid_nomination", "name", "Nominations need a name")
+var ErrorInvalidNominationSeconds = newFieldError("invalid_nomination_seconds", "seconds", "Number of seconds can't be negative")
+var ErrorInvalidNominationStatus = newFieldError("invalid_nomination_status", "status", "Nominations can only be approved or rejected")
+var ErrorManagersOnly = newError("managers_only", "Only election managers can decide nominations")
+
+type NominationStatus string
+
+const (
+	NominationPending  NominationStatus = "pending"
+	NominationApproved NominationStatus = "approved"
+	NominationRejected NominationStatus = "rejected"
+)
+
+type Nomination struct {
+	Id          int64            `json:"id"`
+	Eid         int64            `json:"-"`
+	Uid         int64            `json:"-"`         // nominator
+	Nominator   string           `json:"nominator"` // name of the nominator
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"` // Markdown
+	Url         string           `json:"url,omitempty"`
+	Image       string           `json:"image,omitempty"` // URL
+	Status      NominationStatus `json:"status"`
+	Created     int64            `json:"created"` // unix timestamp
+	Seconds     int              `json:"seconds"`
+}
+
+// registered users who could vote in the election, during the nomination
+// phase
+func (etx *ElectionsTx) CanNominate(user *User, e *Election) error {
+	if err := etx.CanVote(user, e); nil != err && ErrorElectionNominating != err {
+		return err
+	} else if !e.Nominating {
+		return ErrorNominationsClosed
+	} else if !user.Email.Valid {
+		return ErrorNominationsRegistered
+	}
+	return nil
+}
+
+// nominations of the election, ordered by id
+func (etx *ElectionsTx) ElectionNominations(e *Election) ([]Nomination, error) {
+	if nominations, err := etx.st.Nominations(e.Eid); nil != err {
+		return nil, internalError(err)
+	} else {
+		return nominations, nil
+	}
+}
+
+func (etx *ElectionsTx) FindNomination(e *Election, nid int64) (*Nomination, error) {
+	if n, err := etx.st.NominationById(e.Eid, nid); errStorageNotFound == err {
+		return nil, ErrorNominationNotFound
+	} else if nil != err {
+		return nil, internalError(err)
+	} else {
+		return n, nil
+	}
+}
+
+// n needs Name, Description, Url and Image
+func (etx *ElectionsTx) Nominate(e *Election, user *User, n *Nomination) error {
+	if err := etx.CanNominate(user, e); nil != err {
+		return err
+	} else if 0 == len(strings.TrimSpace(n.Name)) {
+		return ErrorInvalidNomination
+	} else if !validCandidateUrl(n.Url) || !validCandidateUrl(n.Image) {
+		return ErrorInvalidCandidateUrl
+	}
+	n.Eid = e.Eid
+	n.Uid = user.Uid
+	n.Nominator = user.Name
+	n.Status = NominationPending
+	n.Created = etx.now().Unix()
+	n.Seconds = 0
+	if nid, err := etx.st.CreateNomination(n); nil != err {
+		return internalError(err)
+	} else {
+		n.Id = nid
+	}
+	return etx.audit(AuditNominated, e, user, n)
+}
+
+// seconding a nomination again has no effect
+func (etx *ElectionsTx) SecondNomination(e *Election, user *User, n *Nomination) error {
+	if err := etx.CanNominate(user, e); nil != err {
+		return err
+	} else if NominationPending != n.Status {
+		return ErrorNominationDecided
+	} else if n.Uid == user.Uid {
+		return ErrorOwnNomination
+	}
+	if err := etx.st.InsertSecond(n.Id, user.Uid); errStorageConflict == err {
+		return nil
+	} else if nil != err {
+		return internalError(err)
+	}
+	n.Seconds++
+	return etx.audit(AuditNominationSeconded, e, user, map[string]interface{}{"nomination": n.Id})
+}
+
+// approved nominations are added to the candidates; only during the
+// nomination phase
+func (etx *ElectionsTx) DecideNomination(e *Election, actor *User, n *Nomination, status NominationStatus) error {
+	if NominationApproved != status && NominationRejected != status {
+		return ErrorInvalidNominationStatus
+	} else if !e.Nominating {
+		return ErrorNominationsClosed
+	} else if NominationPending != n.Status {
+		return ErrorNominationDecided
+	}
+	action := AuditNominationRejected
+	if NominationApproved == status {
+		if n.Seconds < e.NominationSeconds {
+			return ErrorNotEnoughSeconds
+		}
+		candidates := keepReserved(e, append(e.Candidates[:len(e.Candidates):len(e.Candidates)], Candidate{
+			Name:        n.Name,
+			Description: n.Description,
+			Url:         n.Url,
+			Image:       n.Image,
+		}))
+		if err := checkCandidates(candidates, 0); nil != err {
+			return err
+		} else if err := etx.setCandidates(e, candidates); nil != err {
+			return err
+		} else if err := etx.resetTally(e); nil != err {
+			return err
+		}
+		action = AuditNominationApproved
+	}
+	n.Status = status
+	if err := etx.st.SetNominationStatus(n.Id, status); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(action, e, actor, map[string]interface{}{
+		"nomination": n.Id,
+		"candidates": e.Candidates,
+	})
+}
+
+// ending the nomination phase needs valid candidates; it can only be
+// started again before ballots were cast. pending nominations stay
+// pending.
+func (etx *ElectionsTx) SetElectionNominations(e *Election, actor *User, nominating bool, seconds int) error {
+	if seconds < 0 {
+		return ErrorInvalidNominationSeconds
+	}
+	if nominating && !e.Nominating {
+		if etx.IsElectionClosed(e) {
+			return ErrorElectionClosed
+		} else if ballots, err := etx.st.Ballots(e.Eid, e.Secret); nil != err {
+			return internalError(err)
+		} else if 0 != len(ballots) {
+			return ErrorCandidatesLocked
+		}
+	} else if !nominating {
+		if err := checkCandidates(e.Candidates, 2); nil != err {
+			return err
+		}
+	}
+	if !nominating && e.Nominating {
+		// announce the opening (again)
+		e.Started = false
+	}
+	e.Nominating = nominating
+	e.NominationSeconds = seconds
+	if err := etx.st.UpdateElection(e); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditElectionNominations, e, actor, map[string]interface{}{
+		"nominating": nominating,
+		"seconds":    seconds,
+	})
+}
+
+type nominationReq struct {
+	Name        string
+	Description string
+	Url         string
+	Image       string
+}
+
+type nominationDecisionReq struct {
+	Status NominationStatus
+}
+
+// handlers for nominations (path: after "nominations/")
+func (edb ElectionsDb) apiV1Nominations(election string, path []string) (map[string]apiV1Handler, error) {
+	if 0 == len(path) {
+		return map[string]apiV1Handler{
+			"GET": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiNominations(election, a)
+			},
+			"POST": func(a auth, jsonBody []byte) (interface{}, error) {
+				var body nominationReq
+				if err := json.Unmarshal(jsonBody, &body); nil != err {
+					return nil, invalidRequest(err)
+				}
+				return edb.apiNominate(election, a, body)
+			},
+		}, nil
+	} else if len(path) > 2 {
+		return nil, ErrorNotFound
+	}
+	nid, err := strconv.ParseInt(path[0], 10, 64)
+	if nil != err {
+		return nil, ErrorNotFound
+	}
+	if 1 == len(path) {
+		return map[string]apiV1Handler{
+			"GET": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiNomination(election, a, nid, nil)
+			},
+			"PUT": func(a auth, jsonBody []byte) (interface{}, error) {
+				var body nominationDecisionReq
+				if err := json.Unmarshal(jsonBody, &body); nil != err {
+					return nil, invalidRequest(err)
+				}
+				return edb.apiNomination(election, a, nid, func(etx *ElectionsTx, user *User, e *Election, n *Nomination) error {
+					if !etx.IsElectionManager(user, e) {
+						return ErrorManagersOnly
+					}
+					return etx.DecideNomination(e, user, n, body.Status)
+				})
+			},
+		}, nil
+	} else if "second" == path[1] {
+		return map[string]apiV1Handler{
+			"PUT": func(a auth, jsonBody []byte) (interface{}, error) {
+				return edb.apiNomination(election, a, nid, func(etx *ElectionsTx, user *User, e *Election, n *Nomination) error {
+					return etx.SecondNomination(e, user, n)
+				})
+			},
+		}, nil
+	}
+	return nil, ErrorNotFound
+}
+
+func (edb ElectionsDb) apiNominations(election string, a auth) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.FindElectionByName(election, user); nil != err {
+		return nil, err
+	} else {
+		return etx.ElectionNominations(e)
+	}
+}
+
+func (edb ElectionsDb) apiNominate(election string, a auth, body nominationReq) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	n := &Nomination{
+		Name:        strings.TrimSpace(body.Name),
+		Description: strings.TrimSpace(body.Description),
+		Url:         strings.TrimSpace(body.Url),
+		Image:       strings.TrimSpace(body.Image),
+	}
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.FindElectionByName(election, user); nil != err {
+		return nil, err
+	} else if err := etx.Nominate(e, user, n); nil != err {
+		return nil, err
+	} else if err := etx.Commit(); nil != err {
+		return nil, internalError(err)
+	}
+	return n, nil
+}
+
+// runs update (if not nil) and commits
+func (edb ElectionsDb) apiNomination(election string, a auth, nid int64, update func(etx *ElectionsTx, user *User, e *Election, n *Nomination) error) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.FindElectionByName(election, user); nil != err {
+		return nil, err
+	} else if n, err := etx.FindNomination(e, nid); nil != err {
+		return nil, err
+	} else if nil == update {
+		return n, nil
+	} else if err := update(etx, user, e, n); nil != err {
+		return nil, err
+	} else if err := etx.Commit(); nil != err {
+		return nil, internalError(err)
+	} else {
+		return n, nil
+	}
+}
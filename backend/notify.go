@@ -0,0 +1,25 @@
+package backend
+
+type ElectionEvent string
+
+const (
+	EventElectionStarted   ElectionEvent = "started"
+	EventElectionClosed    ElectionEvent = "closed"
+	EventVoteCast          ElectionEvent = "vote"
+	EventCandidatesChanged ElectionEvent = "candidates" // withdrawn or added
+)
+
+// notifications are sent after the transaction triggering them committed
+type Notifier interface {
+	NotifyElection(e *Election, event ElectionEvent)
+}
+
+type LogNotifier struct{}
+
+func (LogNotifier) NotifyElection(e *Election, event ElectionEvent) {
+	if EventVoteCast == event {
+		logDebugf("Election %+q: %s", e.Name, event)
+	} else {
+		logInfof("Election %+q: %s", e.Name, event)
+	}
+}
@@ -0,0 +1,599 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// keep in sync with the handlers in api.go, api_v1.go and the client package;
+// TestOpenAPI verifies the handlers against it
+const openApiSpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "go-vote",
+    "description": "Elections with ranked ballots, evaluated with the Schulze method.",
+    "version": "1"
+  },
+  "paths": {
+    "/api/v1/elections/{name}": {
+      "get": {
+        "operationId": "election",
+        "summary": "Election details",
+        "parameters": [{ "$ref": "#/components/parameters/name" }],
+        "security": [{}, { "token": [] }, { "ballotCode": [] }],
+        "responses": {
+          "200": {
+            "description": "Election",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Election" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/ballot": {
+      "put": {
+        "operationId": "vote",
+        "summary": "Cast or change a ballot",
+        "parameters": [{ "$ref": "#/components/parameters/name" }],
+        "security": [{}, { "token": [] }, { "ballotCode": [] }],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/VoteRequest" } } }
+        },
+        "responses": {
+          "200": {
+            "description": "Ballot was cast",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Receipt" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/results": {
+      "get": {
+        "operationId": "results",
+        "summary": "Results of an election (frozen when the election closed)",
+        "parameters": [{ "$ref": "#/components/parameters/name" }],
+        "security": [{}, { "token": [] }, { "ballotCode": [] }],
+        "responses": {
+          "200": {
+            "description": "Pairwise preferences; strongest paths if there is no Condorcet winner",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Results" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/ballots": {
+      "get": {
+        "operationId": "ballots",
+        "summary": "Anonymised ballots of a closed election",
+        "parameters": [{ "$ref": "#/components/parameters/name" }],
+        "security": [{}, { "token": [] }, { "ballotCode": [] }],
+        "responses": {
+          "200": {
+            "description": "Published ballots",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Ballots" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/events": {
+      "get": {
+        "operationId": "resultEvents",
+        "summary": "Results as server-sent events",
+        "description": "A \"results\" event (data: Results) when connected and after each change; \"error\" events (data: Error) while the results are not visible. Votes in secret elections are not announced before the election closed. EventSource can't set headers: pass a ticket instead.",
+        "parameters": [
+          { "$ref": "#/components/parameters/name" },
+          { "$ref": "#/components/parameters/ticket" }
+        ],
+        "security": [{}, { "token": [] }, { "ballotCode": [] }],
+        "responses": {
+          "200": {
+            "description": "Event stream",
+            "content": { "text/event-stream": { "schema": { "type": "string" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/turnout": {
+      "get": {
+        "operationId": "turnout",
+        "summary": "Turnout by member group and over time (election managers only)",
+        "parameters": [{ "$ref": "#/components/parameters/name" }],
+        "security": [{ "token": [] }],
+        "responses": {
+          "200": {
+            "description": "Turnout; never includes results",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Turnout" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/turnout/events": {
+      "get": {
+        "operationId": "turnoutEvents",
+        "summary": "Turnout as server-sent events",
+        "description": "A \"turnout\" event (data: Turnout) when connected and after each vote; \"error\" events (data: Error) while the turnout is not visible. EventSource can't set headers: pass a ticket instead.",
+        "parameters": [
+          { "$ref": "#/components/parameters/name" },
+          { "$ref": "#/components/parameters/ticket" }
+        ],
+        "security": [{ "token": [] }],
+        "responses": {
+          "200": {
+            "description": "Event stream",
+            "content": { "text/event-stream": { "schema": { "type": "string" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/nominations": {
+      "get": {
+        "operationId": "nominations",
+        "summary": "Nominations of an election",
+        "parameters": [{ "$ref": "#/components/parameters/name" }],
+        "security": [{}, { "token": [] }, { "ballotCode": [] }],
+        "responses": {
+          "200": {
+            "description": "Nominations, oldest first",
+            "content": {
+              "application/json": {
+                "schema": { "type": "array", "nullable": true, "items": { "$ref": "#/components/schemas/Nomination" } }
+              }
+            }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "post": {
+        "operationId": "nominate",
+        "summary": "Nominate a candidate (registered members during the nomination phase)",
+        "parameters": [{ "$ref": "#/components/parameters/name" }],
+        "security": [{ "token": [] }],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/NominationRequest" } } }
+        },
+        "responses": {
+          "200": {
+            "description": "Nomination was added",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Nomination" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/nominations/{id}": {
+      "get": {
+        "operationId": "nomination",
+        "summary": "Nomination",
+        "parameters": [{ "$ref": "#/components/parameters/name" }, { "$ref": "#/components/parameters/nomination" }],
+        "security": [{}, { "token": [] }, { "ballotCode": [] }],
+        "responses": {
+          "200": {
+            "description": "Nomination",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Nomination" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "put": {
+        "operationId": "decideNomination",
+        "summary": "Approve or reject a nomination (election managers during the nomination phase)",
+        "description": "Approved nominations are added to the candidates; they need the number of seconds the election requires.",
+        "parameters": [{ "$ref": "#/components/parameters/name" }, { "$ref": "#/components/parameters/nomination" }],
+        "security": [{ "token": [] }],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/NominationDecision" } } }
+        },
+        "responses": {
+          "200": {
+            "description": "Decided nomination",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Nomination" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/elections/{name}/nominations/{id}/second": {
+      "put": {
+        "operationId": "secondNomination",
+        "summary": "Second a nomination of someone else (registered members during the nomination phase)",
+        "description": "No request body; seconding a nomination again has no effect.",
+        "parameters": [{ "$ref": "#/components/parameters/name" }, { "$ref": "#/components/parameters/nomination" }],
+        "security": [{ "token": [] }],
+        "responses": {
+          "200": {
+            "description": "Nomination",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Nomination" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/audit": {
+      "get": {
+        "operationId": "audit",
+        "summary": "Hash-chained audit log (site admins only)",
+        "security": [{ "token": [] }],
+        "responses": {
+          "200": {
+            "description": "All audit log entries",
+            "content": {
+              "application/json": {
+                "schema": { "type": "array", "nullable": true, "items": { "$ref": "#/components/schemas/AuditEntry" } }
+              }
+            }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/api/v1/tickets": {
+      "post": {
+        "operationId": "ticket",
+        "summary": "Short-lived ticket to authenticate event streams",
+        "security": [{ "token": [] }, { "ballotCode": [] }],
+        "responses": {
+          "200": {
+            "description": "Ticket for the \"ticket\" query parameter of the event streams",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Ticket" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/vote": {
+      "post": {
+        "operationId": "legacyVote",
+        "deprecated": true,
+        "summary": "Cast or change a ballot",
+        "parameters": [{ "$ref": "#/components/parameters/election" }],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/VoteRequest" } } }
+        },
+        "responses": {
+          "200": {
+            "description": "Ballot was cast",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Receipt" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/result": {
+      "post": {
+        "operationId": "legacyResults",
+        "deprecated": true,
+        "summary": "Results of an election (frozen when the election closed)",
+        "parameters": [{ "$ref": "#/components/parameters/election" }],
+        "requestBody": { "$ref": "#/components/requestBodies/Auth" },
+        "responses": {
+          "200": {
+            "description": "Pairwise preferences; strongest paths if there is no Condorcet winner",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Results" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/ballots": {
+      "post": {
+        "operationId": "legacyBallots",
+        "deprecated": true,
+        "summary": "Anonymised ballots of a closed election",
+        "parameters": [{ "$ref": "#/components/parameters/election" }],
+        "requestBody": { "$ref": "#/components/requestBodies/Auth" },
+        "responses": {
+          "200": {
+            "description": "Published ballots",
+            "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Ballots" } } }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/audit": {
+      "post": {
+        "operationId": "legacyAudit",
+        "deprecated": true,
+        "summary": "Hash-chained audit log (site admins only)",
+        "requestBody": { "$ref": "#/components/requestBodies/Auth" },
+        "responses": {
+          "200": {
+            "description": "All audit log entries",
+            "content": {
+              "application/json": {
+                "schema": { "type": "array", "nullable": true, "items": { "$ref": "#/components/schemas/AuditEntry" } }
+              }
+            }
+          },
+          "default": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "token": { "type": "http", "scheme": "bearer", "description": "Token of a registered user" },
+      "ballotCode": { "type": "http", "scheme": "ballot-code", "description": "Ballot code" }
+    },
+    "parameters": {
+      "name": {
+        "name": "name",
+        "in": "path",
+        "required": true,
+        "schema": { "type": "string" }
+      },
+      "nomination": {
+        "name": "id",
+        "in": "path",
+        "required": true,
+        "schema": { "type": "integer" }
+      },
+      "election": {
+        "name": "election",
+        "in": "query",
+        "required": true,
+        "schema": { "type": "string" }
+      },
+      "ticket": {
+        "name": "ticket",
+        "in": "query",
+        "description": "Ticket from POST /api/v1/tickets instead of the Authorization header",
+        "schema": { "type": "string" }
+      }
+    },
+    "requestBodies": {
+      "Auth": {
+        "required": true,
+        "content": { "application/json": { "schema": { "$ref": "#/components/schemas/AuthRequest" } } }
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Error" } } }
+      }
+    },
+    "schemas": {
+      "Auth": {
+        "type": "object",
+        "description": "Registered users use their token; others a ballot code or (if the election is open) a name",
+        "properties": {
+          "token": { "type": "string" },
+          "name": { "type": "string" },
+          "code": { "type": "string", "description": "Ballot code" }
+        }
+      },
+      "AuthRequest": {
+        "type": "object",
+        "properties": { "auth": { "$ref": "#/components/schemas/Auth" } }
+      },
+      "Candidate": {
+        "type": "object",
+        "required": ["id", "name"],
+        "properties": {
+          "id": { "type": "integer" },
+          "name": { "type": "string" },
+          "description": { "type": "string", "description": "Markdown" },
+          "url": { "type": "string" },
+          "image": { "type": "string", "description": "Image URL" },
+          "reserved": { "type": "boolean", "description": "The reopen nominations (RON) pseudo-candidate; always the last candidate" }
+        }
+      },
+      "Election": {
+        "type": "object",
+        "required": ["name", "title", "candidates", "candidate_details", "closed", "nominating", "unranked"],
+        "properties": {
+          "name": { "type": "string" },
+          "title": { "type": "string" },
+          "candidates": { "type": "array", "description": "Candidate names; indices are used in rankings and results", "items": { "type": "string" } },
+          "candidate_details": { "type": "array", "description": "Same order as candidates", "items": { "$ref": "#/components/schemas/Candidate" } },
+          "closed": { "type": "boolean" },
+          "opens_at": { "type": "integer", "description": "Unix timestamp" },
+          "closes_at": { "type": "integer", "description": "Unix timestamp" },
+          "nominating": { "type": "boolean", "description": "Nomination phase: candidates are nominated, voting starts afterwards" },
+          "unranked": { "$ref": "#/components/schemas/UnrankedPolicy" },
+          "nomination_seconds": { "type": "integer", "description": "Seconds a nomination needs before it can be approved (only during the nomination phase)" },
+          "ballot_review": { "type": "string", "enum": ["adjusted", "revote"], "description": "Only if the candidates changed after the authenticated user voted: their ballot was adjusted (withdrawn candidates removed, new ones ranked last), or reset and they need to vote again" }
+        }
+      },
+      "UnrankedPolicy": {
+        "type": "string",
+        "enum": ["last", "abstain"],
+        "description": "How partial ballots count: candidates they don't rank are tied last, or the ballots abstain from the contests with these candidates"
+      },
+      "Nomination": {
+        "type": "object",
+        "required": ["id", "nominator", "name", "status", "created", "seconds"],
+        "properties": {
+          "id": { "type": "integer" },
+          "nominator": { "type": "string" },
+          "name": { "type": "string" },
+          "description": { "type": "string", "description": "Markdown" },
+          "url": { "type": "string" },
+          "image": { "type": "string", "description": "Image URL" },
+          "status": { "type": "string", "enum": ["pending", "approved", "rejected"] },
+          "created": { "type": "integer", "description": "Unix timestamp" },
+          "seconds": { "type": "integer" }
+        }
+      },
+      "NominationRequest": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": { "type": "string" },
+          "description": { "type": "string", "description": "Markdown" },
+          "url": { "type": "string" },
+          "image": { "type": "string", "description": "Image URL" }
+        }
+      },
+      "NominationDecision": {
+        "type": "object",
+        "required": ["status"],
+        "properties": {
+          "status": { "type": "string", "enum": ["approved", "rejected"] }
+        }
+      },
+      "VoteRequest": {
+        "type": "object",
+        "required": ["rankgroups"],
+        "properties": {
+          "auth": { "$ref": "#/components/schemas/Auth", "description": "Ignored if the Authorization header is set" },
+          "rankgroups": {
+            "type": "array",
+            "description": "Candidate indices grouped by rank, most preferred first; candidates missing from all groups are unranked (partial ballot)",
+            "items": { "type": "array", "items": { "type": "integer" } }
+          }
+        }
+      },
+      "Receipt": {
+        "type": "object",
+        "required": ["Ballot", "Hash"],
+        "properties": {
+          "Ballot": { "type": "string" },
+          "Hash": { "type": "string" }
+        }
+      },
+      "Matrix": {
+        "type": "array",
+        "items": { "type": "array", "items": { "type": "integer" } }
+      },
+      "Results": {
+        "type": "object",
+        "required": ["preferences"],
+        "properties": {
+          "preferences": { "$ref": "#/components/schemas/Matrix" },
+          "paths": { "$ref": "#/components/schemas/Matrix" },
+          "winner": { "type": "integer" },
+          "ron": { "type": "integer", "description": "Only with a reopen nominations (RON) candidate: its index" },
+          "failed": { "type": "boolean", "description": "Only with RON: whether RON won, i.e. the election failed" },
+          "ineligible": { "type": "array", "items": { "type": "integer" }, "description": "Only with RON: candidates ranked below RON" }
+        }
+      },
+      "Ballot": {
+        "type": "object",
+        "required": ["Id", "Ranking", "Hash"],
+        "properties": {
+          "Id": { "type": "string" },
+          "Ranking": { "type": "array", "items": { "type": "integer", "minimum": -1 }, "description": "Rank of each candidate; -1: not ranked (partial ballot)" },
+          "Hash": { "type": "string" }
+        }
+      },
+      "Ballots": {
+        "type": "object",
+        "required": ["candidates", "unranked", "ballots", "adjusted"],
+        "properties": {
+          "candidates": { "type": "array", "items": { "type": "string" } },
+          "unranked": { "$ref": "#/components/schemas/UnrankedPolicy" },
+          "ballots": { "type": "array", "nullable": true, "items": { "$ref": "#/components/schemas/Ballot" } },
+          "adjusted": {
+            "type": "object",
+            "additionalProperties": { "type": "string" },
+            "description": "Old ballot hash to new hash of ballots adjusted after the candidates changed; a receipt hash might need several steps"
+          }
+        }
+      },
+      "Turnout": {
+        "type": "object",
+        "required": ["open", "eligible", "voted", "groups", "interval", "timeline"],
+        "properties": {
+          "open": { "type": "boolean", "description": "Anybody can vote; eligible only counts members and ballot codes" },
+          "eligible": { "type": "integer", "description": "Listed members and issued ballot codes" },
+          "voted": { "type": "integer" },
+          "groups": {
+            "type": "array",
+            "nullable": true,
+            "items": {
+              "type": "object",
+              "required": ["group", "eligible", "voted"],
+              "properties": {
+                "group": { "type": "string", "description": "Empty for members without group, ballot codes and unlisted voters" },
+                "eligible": { "type": "integer" },
+                "voted": { "type": "integer" }
+              }
+            }
+          },
+          "interval": { "type": "integer", "description": "Seconds between timeline points" },
+          "timeline": {
+            "type": "array",
+            "nullable": true,
+            "description": "Ballots cast until the end of each interval; votes without timestamp are included from the first point on",
+            "items": {
+              "type": "object",
+              "required": ["time", "voted"],
+              "properties": {
+                "time": { "type": "integer", "description": "Unix timestamp of the interval start" },
+                "voted": { "type": "integer" }
+              }
+            }
+          }
+        }
+      },
+      "Ticket": {
+        "type": "object",
+        "required": ["ticket", "expires"],
+        "properties": {
+          "ticket": { "type": "string" },
+          "expires": { "type": "integer", "description": "Unix timestamp; connections must be opened before" }
+        }
+      },
+      "AuditEntry": {
+        "type": "object",
+        "required": ["Seq", "Time", "Action", "Eid", "Uid", "Data", "Hash"],
+        "properties": {
+          "Seq": { "type": "integer" },
+          "Time": { "type": "integer" },
+          "Action": { "type": "string" },
+          "Eid": { "type": "integer" },
+          "Uid": { "type": "integer" },
+          "Data": { "type": "string" },
+          "Hash": { "type": "string" }
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["code", "message"],
+        "properties": {
+          "code": { "type": "string" },
+          "message": { "type": "string" },
+          "field": { "type": "string" }
+        }
+      }
+    }
+  }
+}`
+
+// spec with the server url for prefix
+func OpenApiSpec(prefix string) []byte {
+	var spec map[string]interface{}
+	if err := json.Unmarshal([]byte(openApiSpec), &spec); nil != err {
+		panic(err)
+	}
+	url := prefix
+	if 0 == len(url) {
+		url = "/"
+	}
+	spec["servers"] = []map[string]string{{"url": url}}
+	if data, err := json.MarshalIndent(spec, "", "  "); nil != err {
+		panic(err)
+	} else {
+		return data
+	}
+}
+
+func OpenApiHandler(prefix string) http.HandlerFunc {
+	spec := OpenApiSpec(prefix)
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
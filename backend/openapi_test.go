@@ -0,0 +1,756 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/stbuehler/go-vote/client"
+	"github.com/stbuehler/go-vote/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* runs the API handlers on an in-memory database and validates all
+ * responses against the OpenAPI document served by the handlers.
+ */
+
+const checkAdminToken = "admin-token"
+const checkMemberToken = "member-token"
+
+type apiChecker struct {
+	t       *testing.T
+	edb     ElectionsDb
+	baseUrl string
+	spec    map[string]interface{}
+	covered map[string]bool // "path status"
+}
+
+// JSON pointer ("#/components/schemas/Ballot") into the spec
+func (c *apiChecker) resolve(ref string) (map[string]interface{}, error) {
+	var node interface{} = c.spec
+	for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		if obj, ok := node.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("invalid reference %s", ref)
+		} else if node, ok = obj[name]; !ok {
+			return nil, fmt.Errorf("invalid reference %s", ref)
+		}
+	}
+	if obj, ok := node.(map[string]interface{}); ok {
+		return obj, nil
+	}
+	return nil, fmt.Errorf("invalid reference %s", ref)
+}
+
+func (c *apiChecker) deref(obj map[string]interface{}) (map[string]interface{}, error) {
+	for {
+		ref, ok := obj["$ref"].(string)
+		if !ok {
+			return obj, nil
+		}
+		var err error
+		if obj, err = c.resolve(ref); nil != err {
+			return nil, err
+		}
+	}
+}
+
+// supports the subset of JSON schema used by the spec
+func (c *apiChecker) validate(schema map[string]interface{}, value interface{}, path string) error {
+	schema, err := c.deref(schema)
+	if nil != err {
+		return err
+	}
+	if nil == value {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", path)
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object", path)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing property %s", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		// maps: additionalProperties is the schema of the values
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, v := range obj {
+			propSchema, ok := properties[name].(map[string]interface{})
+			if !ok && nil != additional {
+				propSchema, ok = additional, true
+			}
+			if !ok {
+				return fmt.Errorf("%s: unexpected property %s", path, name)
+			} else if err := c.validate(propSchema, v, path+"."+name); nil != err {
+				return err
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array", path)
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, v := range list {
+			if err := c.validate(items, v, path+"["+strconv.Itoa(i)+"]"); nil != err {
+				return err
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean", path)
+		}
+	case "string":
+		if s, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected string", path)
+		} else if enum, ok := schema["enum"].([]interface{}); ok {
+			for _, allowed := range enum {
+				if s == allowed {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: unexpected value %q", path, s)
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: expected integer", path)
+		}
+	}
+	return nil
+}
+
+// schema of the response with status for the operation; errors for
+// undocumented operations (like 405 responses) use the generic response
+func (c *apiChecker) responseSchema(method, path string, status int) (map[string]interface{}, error) {
+	paths, _ := c.spec["paths"].(map[string]interface{})
+	item, _ := paths[path].(map[string]interface{})
+	var response map[string]interface{}
+	if op, ok := item[strings.ToLower(method)].(map[string]interface{}); ok {
+		responses, _ := op["responses"].(map[string]interface{})
+		if response, ok = responses[strconv.Itoa(status)].(map[string]interface{}); !ok {
+			if response, ok = responses["default"].(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%s %s: status %d not documented", method, path, status)
+			}
+		}
+	} else if status >= 400 {
+		response = map[string]interface{}{"$ref": "#/components/responses/Error"}
+	} else {
+		return nil, fmt.Errorf("%s %s not documented", method, path)
+	}
+	response, err := c.deref(response)
+	if nil != err {
+		return nil, err
+	}
+	content, _ := response["content"].(map[string]interface{})
+	media, ok := content["application/json"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s: no JSON content for status %d", method, path, status)
+	}
+	schema, _ := media["schema"].(map[string]interface{})
+	return schema, nil
+}
+
+type apiCall struct {
+	method      string
+	path        string // as in the spec, like "/api/v1/elections/{name}"
+	target      string // url relative to the server
+	auth        string // Authorization header
+	accept      string
+	contentType string
+	body        string
+	status      int // expected status
+}
+
+// runs the request and checks the response against status and the spec;
+// returns the decoded response
+func (c *apiChecker) call(name string, call apiCall) (value interface{}) {
+	err := func() error {
+		req, err := http.NewRequest(call.method, c.baseUrl+call.target, strings.NewReader(call.body))
+		if nil != err {
+			return err
+		}
+		for header, value := range map[string]string{
+			"Authorization": call.auth,
+			"Accept":        call.accept,
+			"Content-Type":  call.contentType,
+		} {
+			if 0 != len(value) {
+				req.Header.Set(header, value)
+			}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			return err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		if nil != err {
+			return err
+		} else if call.status != resp.StatusCode {
+			return fmt.Errorf("expected status %d, got %s: %s", call.status, resp.Status, bytes.TrimSpace(data))
+		} else if 405 == resp.StatusCode && 0 == len(resp.Header.Get("Allow")) {
+			return fmt.Errorf("missing Allow header")
+		}
+		if schema, err := c.responseSchema(call.method, call.path, call.status); nil != err {
+			return err
+		} else if err := json.Unmarshal(data, &value); nil != err {
+			return err
+		} else if err := c.validate(schema, value, "response"); nil != err {
+			return err
+		}
+		c.covered[call.method+" "+call.path+" "+strconv.Itoa(call.status)] = true
+		return nil
+	}()
+	c.report(name, err)
+	return value
+}
+
+// POST to the original API
+func (c *apiChecker) check(name, path, election, body string, status int) {
+	target := path
+	if 0 != len(election) {
+		target += "?election=" + election
+	}
+	c.call(name, apiCall{method: "POST", path: path, target: target, contentType: "application/json", body: body, status: status})
+}
+
+func (c *apiChecker) report(name string, err error) {
+	if nil != err {
+		c.t.Errorf("%s: %v", name, err)
+	}
+}
+
+// every documented path needs a checked successful response
+func (c *apiChecker) checkCoverage() {
+	paths, _ := c.spec["paths"].(map[string]interface{})
+	for path, item := range paths {
+		for method := range item.(map[string]interface{}) {
+			if !c.covered[strings.ToUpper(method)+" "+path+" 200"] {
+				c.report("coverage "+method+" "+path, fmt.Errorf("no successful request checked"))
+			}
+		}
+	}
+}
+
+// next server-sent event; comments (keep-alive) are skipped
+func readEvent(r *bufio.Reader) (event string, data string, err error) {
+	for {
+		line, err := r.ReadString('\n')
+		if nil != err {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if 0 == len(line) && 0 != len(event) {
+			return event, data, nil
+		} else if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		} else if strings.HasPrefix(line, "data: ") {
+			data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// reads the initial event, votes and waits for the updated event; count
+// must grow with each vote
+func (c *apiChecker) checkEvents(name, path, target, event, schemaName string, count func(value map[string]interface{}) int) {
+	err := func() error {
+		req, err := http.NewRequest("GET", c.baseUrl+target, nil)
+		if nil != err {
+			return err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+		if nil != err {
+			return err
+		}
+		defer resp.Body.Close()
+		if 200 != resp.StatusCode {
+			return fmt.Errorf("expected status 200, got %s", resp.Status)
+		} else if contentType := resp.Header.Get("Content-Type"); "text/event-stream" != contentType {
+			return fmt.Errorf("unexpected content type %s", contentType)
+		}
+
+		paths, _ := c.spec["paths"].(map[string]interface{})
+		op, _ := paths[path].(map[string]interface{})["get"].(map[string]interface{})
+		ok, _ := op["responses"].(map[string]interface{})["200"].(map[string]interface{})
+		content, _ := ok["content"].(map[string]interface{})
+		if _, documented := content["text/event-stream"]; !documented {
+			return fmt.Errorf("GET %s: event stream not documented", path)
+		}
+		schema := map[string]interface{}{"$ref": "#/components/schemas/" + schemaName}
+
+		reader := bufio.NewReader(resp.Body)
+		var voters int
+		for i := 0; i < 2; i++ {
+			var value map[string]interface{}
+			if received, data, err := readEvent(reader); nil != err {
+				return err
+			} else if event != received {
+				return fmt.Errorf("expected %s event, got %s: %s", event, received, data)
+			} else if err := json.Unmarshal([]byte(data), &value); nil != err {
+				return err
+			} else if err := c.validate(schema, value, "event"); nil != err {
+				return err
+			}
+			if 1 == i && count(value) <= voters {
+				return fmt.Errorf("%s didn't change after vote", event)
+			}
+			voters = count(value)
+			if 0 == i {
+				if _, err := client.New(c.baseUrl, client.Auth{Name: "frank (" + event + ")"}).Vote("check", types.RankGroups{{0}, {1}, {2}}); nil != err {
+					return err
+				}
+			}
+		}
+		c.covered["GET "+path+" 200"] = true
+		return nil
+	}()
+	c.report(name, err)
+}
+
+// wins of A over B and B over A: grows with each ballot ranking them differently
+func countResultVoters(value map[string]interface{}) int {
+	prefs := value["preferences"].([]interface{})
+	return int(prefs[0].([]interface{})[1].(float64) + prefs[1].([]interface{})[0].(float64))
+}
+
+func countTurnoutVoters(value map[string]interface{}) int {
+	return int(value["voted"].(float64))
+}
+
+// election "check" (open for unregistered users), election "nominations"
+// (nomination phase, one listed member), election "ron" (with the reopen
+// nominations candidate), election "secret" (secret ballots) and a site
+// admin
+func seedCheckDatabase(edb ElectionsDb) (codes []string, err error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, err
+	}
+	defer etx.Rollback()
+
+	admin := &User{
+		Name:      "admin",
+		Email:     sql.NullString{String: "admin@example.com", Valid: true},
+		Token:     sql.NullString{String: checkAdminToken, Valid: true},
+		SiteAdmin: true,
+	}
+	e := &Election{
+		Name:  "check",
+		Title: "API check",
+		Candidates: []Candidate{
+			{Name: "A", Description: "The *first* candidate", Url: "https://example.com/a"},
+			{Name: "B"},
+			{Name: "C", Image: "https://example.com/c.png"},
+		},
+		Public:   true,
+		Open:     true,
+		EditOpen: true,
+		Results:  ResultsAlways,
+	}
+	member := &User{
+		Name:  "member",
+		Email: sql.NullString{String: "member@example.com", Valid: true},
+		Token: sql.NullString{String: checkMemberToken, Valid: true},
+	}
+	nominations := &Election{
+		Name:              "nominations",
+		Title:             "Nominations check",
+		Public:            true,
+		Results:           ResultsAlways,
+		Nominating:        true,
+		NominationSeconds: 1,
+	}
+	ron := &Election{
+		Name:       "ron",
+		Title:      "RON check",
+		Candidates: []Candidate{{Name: "A"}, {Name: "B"}, RonCandidate()},
+		Public:     true,
+		Open:       true,
+		Results:    ResultsAlways,
+	}
+	secret := &Election{
+		Name:       "secret",
+		Title:      "Secret ballot check",
+		Candidates: []Candidate{{Name: "A"}, {Name: "B"}},
+		Public:     true,
+		Open:       true,
+		Secret:     true,
+		Results:    ResultsAlways,
+	}
+	if err := etx.CreateUser(admin, nil); nil != err {
+		return nil, err
+	} else if err := etx.CreateUser(member, admin); nil != err {
+		return nil, err
+	} else if err := etx.CreateElection(e, admin); nil != err {
+		return nil, err
+	} else if err := etx.AddElectionMember(e, admin, "board", admin); nil != err {
+		return nil, err
+	} else if codes, err = etx.GenerateBallotCodes(e, admin, 3); nil != err {
+		return nil, err
+	} else if err := etx.CreateElection(nominations, admin); nil != err {
+		return nil, err
+	} else if err := etx.AddElectionMember(nominations, member, "", admin); nil != err {
+		return nil, err
+	} else if err := etx.CreateElection(ron, admin); nil != err {
+		return nil, err
+	} else if err := etx.CreateElection(secret, admin); nil != err {
+		return nil, err
+	}
+	return codes, etx.Commit()
+}
+
+func closeCheckElection(edb ElectionsDb) error {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return err
+	}
+	defer etx.Rollback()
+
+	for _, name := range []string{"check", "secret"} {
+		if e, err := etx.ElectionByName(name); nil != err {
+			return err
+		} else if err := etx.SetElectionClosed(e, nil, true); nil != err {
+			return err
+		}
+	}
+	return etx.Commit()
+}
+
+// the policy for unranked candidates is locked once ballots were cast
+func checkUnrankedPolicy(edb ElectionsDb) error {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return err
+	}
+	defer etx.Rollback()
+
+	if e, err := etx.ElectionByName("check"); nil != err {
+		return err
+	} else if err := etx.SetElectionUnrankedPolicy(e, nil, UnrankedAbstain); ErrorUnrankedPolicyLocked != err {
+		return fmt.Errorf("expected unranked_policy_locked error, got %v", err)
+	} else if e, err := etx.ElectionByName("nominations"); nil != err {
+		return err
+	} else if err := etx.SetElectionUnrankedPolicy(e, nil, "first"); ErrorInvalidUnrankedPolicy != err {
+		return fmt.Errorf("expected invalid_unranked_policy error, got %v", err)
+	} else if err := etx.SetElectionUnrankedPolicy(e, nil, UnrankedAbstain); nil != err {
+		return err
+	}
+	return etx.Commit()
+}
+
+// RON can't be added or withdrawn after voting opened
+func checkRonLocked(edb ElectionsDb) error {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return err
+	}
+	defer etx.Rollback()
+
+	if e, err := etx.ElectionByName("check"); nil != err {
+		return err
+	} else if err := etx.SetElectionRon(e, nil, true); ErrorRonLocked != err {
+		return fmt.Errorf("expected ron_locked error, got %v", err)
+	} else if e, err := etx.ElectionByName("ron"); nil != err {
+		return err
+	} else if err := etx.ChangeCandidates(e, nil, []int64{e.Candidates[e.Ron()].Id}, nil, false); ErrorRonLocked != err {
+		return fmt.Errorf("expected ron_locked error, got %v", err)
+	} else if err := etx.ChangeCandidates(e, nil, nil, []Candidate{{Name: "C"}}, false); nil != err {
+		return err
+	} else if 3 != e.Ron() || "C" != e.Candidates[2].Name {
+		return fmt.Errorf("RON must stay the last candidate, got %v", CandidateNames(e.Candidates))
+	}
+	// rolled back: keep the candidates for the other checks
+	return nil
+}
+
+// withdraws candidate "B" and adds "D" in election "check"; the tally
+// must match the rewritten ballots
+func changeCheckCandidates(edb ElectionsDb) error {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return err
+	}
+	defer etx.Rollback()
+
+	if e, err := etx.ElectionByName("check"); nil != err {
+		return err
+	} else if err := etx.ChangeCandidates(e, nil, []int64{e.Candidates[1].Id}, []Candidate{{Name: "D"}}, false); nil != err {
+		return err
+	} else if stored, counted, err := etx.CheckElectionTally(e, false); nil != err {
+		return err
+	} else if !stored.Equal(counted) {
+		return fmt.Errorf("stored tally differs from the rewritten ballots")
+	}
+	return etx.Commit()
+}
+
+// the typed client must understand the same responses
+func (c *apiChecker) checkClient(code string) {
+	anonymous := client.New(c.baseUrl, client.Auth{Name: "dave"})
+	voter := client.New(c.baseUrl, client.Auth{Code: code})
+	admin := client.New(c.baseUrl, client.Auth{Token: checkAdminToken})
+
+	election, err := anonymous.Election("check")
+	if nil == err && (3 != len(election.Candidates) || 3 != len(election.CandidateDetails)) {
+		err = fmt.Errorf("expected 3 candidates")
+	} else if nil == err && ("A" != election.CandidateDetails[0].Name || "https://example.com/a" != election.CandidateDetails[0].Url) {
+		err = fmt.Errorf("unexpected candidate details %+v", election.CandidateDetails[0])
+	}
+	c.report("client Election", err)
+	receipt, err := voter.Vote("check", types.RankGroups{{2}, {0, 1}})
+	c.report("client Vote", err)
+	_, err = anonymous.Vote("check", types.RankGroups{{1}, {0, 2}})
+	c.report("client Vote by name", err)
+	_, err = client.New(c.baseUrl, client.Auth{Name: "grace"}).Vote("check", types.RankGroups{{0}})
+	if nil == err {
+		if election, err = anonymous.Election("check"); nil == err && "last" != election.Unranked {
+			err = fmt.Errorf("expected unranked policy last, got %q", election.Unranked)
+		}
+	}
+	c.report("client Vote with partial ballot", err)
+	c.report("unranked policy", checkUnrankedPolicy(c.edb))
+	results, err := anonymous.Results("check")
+	if nil == err && 3 != len(results.Preferences) {
+		err = fmt.Errorf("expected 3x3 preferences")
+	}
+	c.report("client Results", err)
+	_, err = anonymous.Vote("missing", types.RankGroups{{0}, {1}, {2}})
+	if apiErr, ok := err.(*client.Error); !ok || 404 != apiErr.StatusCode || "election_not_found" != apiErr.Code {
+		err = fmt.Errorf("expected election_not_found error, got %v", err)
+	} else {
+		err = nil
+	}
+	c.report("client error", err)
+	turnout, err := admin.Turnout("check")
+	if nil == err && (turnout.Voted < 2 || 0 == len(turnout.Groups)) {
+		err = fmt.Errorf("expected votes and groups in turnout")
+	}
+	c.report("client Turnout", err)
+	entries, err := admin.AuditLog()
+	if nil == err && 0 == len(entries) {
+		err = fmt.Errorf("expected audit entries")
+	}
+	c.report("client AuditLog", err)
+	c.checkClientNominations()
+	c.checkClientRon()
+
+	if err := changeCheckCandidates(c.edb); nil != err {
+		c.report("change candidates", err)
+		return
+	}
+	c.call("v1 election with ballot review", apiCall{method: "GET", path: "/api/v1/elections/{name}", target: "/api/v1/elections/check",
+		auth: "Ballot-Code " + code, status: 200})
+	election, err = voter.Election("check")
+	if nil == err && "adjusted" != election.BallotReview {
+		err = fmt.Errorf("expected adjusted ballot, got %q", election.BallotReview)
+	} else if nil == err && "A,C,D" != strings.Join(election.Candidates, ",") {
+		err = fmt.Errorf("unexpected candidates %v", election.Candidates)
+	}
+	c.report("client Election after candidate change", err)
+	// the receipt hash changed with the ranking
+	receipt, err = voter.Vote("check", types.RankGroups{{2}, {0, 1}})
+	if nil == err {
+		if election, err = voter.Election("check"); nil == err && "" != election.BallotReview {
+			err = fmt.Errorf("ballot review not cleared by voting")
+		}
+	}
+	c.report("client Vote after candidate change", err)
+
+	if err := closeCheckElection(c.edb); nil != err {
+		c.report("close election", err)
+		return
+	}
+	ballots, err := anonymous.Ballots("check")
+	if nil == err && nil != receipt {
+		err = fmt.Errorf("ballot %s not published", receipt.Ballot)
+		for _, b := range ballots.Ballots {
+			if b.Id == receipt.Ballot && b.Hash == receipt.Hash {
+				err = nil
+			}
+		}
+	}
+	c.report("client Ballots", err)
+}
+
+// nominations of the "nominations" election; the API checks already
+// approved nomination 1
+func (c *apiChecker) checkClientNominations() {
+	admin := client.New(c.baseUrl, client.Auth{Token: checkAdminToken})
+	member := client.New(c.baseUrl, client.Auth{Token: checkMemberToken})
+
+	nomination, err := admin.Nominate("nominations", client.Candidate{Name: "Client", Url: "https://example.com/client"})
+	c.report("client Nominate", err)
+	if nil == err {
+		_, err = admin.DecideNomination("nominations", nomination.Id, "approved")
+		if apiErr, ok := err.(*client.Error); !ok || "not_enough_seconds" != apiErr.Code {
+			err = fmt.Errorf("expected not_enough_seconds error, got %v", err)
+		} else if nomination, err = member.Second("nominations", nomination.Id); nil == err && 1 != nomination.Seconds {
+			err = fmt.Errorf("expected 1 second, got %d", nomination.Seconds)
+		}
+		c.report("client Second", err)
+		if nil == err {
+			_, err = admin.DecideNomination("nominations", nomination.Id, "approved")
+		}
+		c.report("client DecideNomination", err)
+	}
+	nominations, err := member.Nominations("nominations")
+	if nil == err && 2 != len(nominations) {
+		err = fmt.Errorf("expected 2 nominations, got %d", len(nominations))
+	}
+	c.report("client Nominations", err)
+	election, err := member.Election("nominations")
+	if nil == err && (!election.Nominating || 2 != len(election.Candidates)) {
+		err = fmt.Errorf("expected nomination phase with 2 candidates, got %+v", election)
+	}
+	c.report("client Election in nomination phase", err)
+	if nil == err && "abstain" != election.Unranked {
+		c.report("client Election unranked policy", fmt.Errorf("expected unranked policy abstain, got %q", election.Unranked))
+	}
+}
+
+// in election "ron" RON won the votes of the API checks
+func (c *apiChecker) checkClientRon() {
+	anonymous := client.New(c.baseUrl, client.Auth{Name: "heidi"})
+
+	election, err := anonymous.Election("ron")
+	if nil == err && (3 != len(election.CandidateDetails) || !election.CandidateDetails[2].Reserved) {
+		err = fmt.Errorf("expected RON as last candidate, got %+v", election.CandidateDetails)
+	}
+	c.report("client Election with RON", err)
+	results, err := anonymous.Results("ron")
+	if nil == err && (nil == results.Ron || 2 != *results.Ron || !results.Failed) {
+		err = fmt.Errorf("expected failed election, got %+v", results)
+	} else if nil == err && 2 != len(results.Ineligible) {
+		err = fmt.Errorf("expected both candidates ineligible, got %v", results.Ineligible)
+	}
+	c.report("client Results with RON", err)
+	c.report("RON locked", checkRonLocked(c.edb))
+}
+
+func TestOpenAPI(t *testing.T) {
+	edb := NewMemoryDatabase().WithFeatures(DefaultFeatures())
+	codes, err := seedCheckDatabase(edb)
+	if nil != err {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	edb.BindServeMux(mux, "")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := &apiChecker{t: t, edb: edb, baseUrl: server.URL, covered: make(map[string]bool)}
+	resp, err := http.Get(server.URL + "/openapi.json")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&c.spec); nil != err {
+		t.Fatalf("openapi.json: %v", err)
+	}
+
+	admin := `{"auth":{"token":"` + checkAdminToken + `"}}`
+	c.check("vote by name", "/vote", "check", `{"auth":{"name":"alice"},"rankgroups":[[0],[1,2]]}`, 200)
+	c.check("vote by ballot code", "/vote", "check", `{"auth":{"code":"`+codes[0]+`"},"rankgroups":[[1],[0],[2]]}`, 200)
+	c.check("vote with invalid code", "/vote", "check", `{"auth":{"code":"invalid"},"rankgroups":[[0],[1],[2]]}`, 401)
+	c.check("vote with partial ballot", "/vote", "check", `{"auth":{"name":"bob"},"rankgroups":[[2]]}`, 200)
+	c.check("vote with empty ballot", "/vote", "check", `{"auth":{"name":"bob"},"rankgroups":[]}`, 400)
+	c.check("vote with invalid ranking", "/vote", "check", `{"auth":{"name":"bob"},"rankgroups":[[7]]}`, 400)
+	c.check("vote with malformed body", "/vote", "check", `{"rankgroups":`, 400)
+	c.check("vote in unknown election", "/vote", "missing", `{"auth":{"name":"bob"},"rankgroups":[[0],[1],[2]]}`, 404)
+	c.check("result", "/result", "check", `{}`, 200)
+	c.check("result of unknown election", "/result", "missing", `{}`, 404)
+	c.check("vote with RON", "/vote", "ron", `{"auth":{"name":"alice"},"rankgroups":[[2],[0],[1]]}`, 200)
+	c.check("vote with RON second", "/vote", "ron", `{"auth":{"name":"bob"},"rankgroups":[[0],[2],[1]]}`, 200)
+	c.check("vote with RON first", "/vote", "ron", `{"auth":{"name":"carol"},"rankgroups":[[2],[1],[0]]}`, 200)
+	c.check("result with RON", "/result", "ron", `{}`, 200)
+	c.check("ballots of open election", "/ballots", "check", `{}`, 404)
+	c.check("audit without admin", "/audit", "", `{}`, 403)
+	c.check("audit", "/audit", "", admin, 200)
+
+	election := "/api/v1/elections/{name}"
+	jsonType := "application/json"
+	bearer := "Bearer " + checkAdminToken
+	c.call("v1 election", apiCall{method: "GET", path: election, target: "/api/v1/elections/check", status: 200})
+	c.call("v1 unknown election", apiCall{method: "GET", path: election, target: "/api/v1/elections/missing", status: 404})
+	c.call("v1 unknown resource", apiCall{method: "GET", path: election, target: "/api/v1/elections/check/missing", status: 404})
+	c.call("v1 vote by name", apiCall{method: "PUT", path: election + "/ballot", target: "/api/v1/elections/check/ballot",
+		contentType: jsonType, body: `{"auth":{"name":"erin"},"rankgroups":[[0],[1],[2]]}`, status: 200})
+	c.call("v1 vote by ballot code", apiCall{method: "PUT", path: election + "/ballot", target: "/api/v1/elections/check/ballot",
+		auth: "Ballot-Code " + codes[2], contentType: jsonType, body: `{"rankgroups":[[2],[1],[0]]}`, status: 200})
+	c.call("v1 vote with partial ballot", apiCall{method: "PUT", path: election + "/ballot", target: "/api/v1/elections/check/ballot",
+		contentType: jsonType, body: `{"auth":{"name":"frank"},"rankgroups":[[1,2]]}`, status: 200})
+	c.call("v1 vote without content type", apiCall{method: "PUT", path: election + "/ballot", target: "/api/v1/elections/check/ballot",
+		body: `{"auth":{"name":"erin"},"rankgroups":[[0],[1],[2]]}`, status: 415})
+	c.call("v1 vote with wrong method", apiCall{method: "GET", path: election + "/ballot", target: "/api/v1/elections/check/ballot", status: 405})
+	c.call("v1 results", apiCall{method: "GET", path: election + "/results", target: "/api/v1/elections/check/results",
+		accept: "text/html, application/json;q=0.5", status: 200})
+	c.call("v1 results as HTML", apiCall{method: "GET", path: election + "/results", target: "/api/v1/elections/check/results",
+		accept: "text/html", status: 406})
+	c.call("v1 results with invalid authorization", apiCall{method: "GET", path: election + "/results", target: "/api/v1/elections/check/results",
+		auth: "Basic YWRtaW4=", status: 401})
+	c.call("v1 ballots of open election", apiCall{method: "GET", path: election + "/ballots", target: "/api/v1/elections/check/ballots", status: 404})
+	c.checkEvents("v1 result events", election+"/events", "/api/v1/elections/check/events", "results", "Results", countResultVoters)
+	c.call("v1 result events as JSON", apiCall{method: "GET", path: election + "/events", target: "/api/v1/elections/check/events",
+		accept: jsonType, status: 406})
+	c.call("v1 turnout without manager", apiCall{method: "GET", path: election + "/turnout", target: "/api/v1/elections/check/turnout", status: 403})
+	c.call("v1 turnout", apiCall{method: "GET", path: election + "/turnout", target: "/api/v1/elections/check/turnout", auth: bearer, status: 200})
+	c.call("v1 ticket without authorization", apiCall{method: "POST", path: "/api/v1/tickets", target: "/api/v1/tickets", status: 401})
+	ticket, _ := c.call("v1 ticket", apiCall{method: "POST", path: "/api/v1/tickets", target: "/api/v1/tickets", auth: bearer, status: 200}).(map[string]interface{})
+	c.call("v1 turnout events with invalid ticket", apiCall{method: "GET", path: election + "/turnout/events", target: "/api/v1/elections/check/turnout/events?ticket=invalid",
+		accept: "text/event-stream", status: 401})
+	c.checkEvents("v1 turnout events", election+"/turnout/events", "/api/v1/elections/check/turnout/events?ticket="+fmt.Sprint(ticket["ticket"]), "turnout", "Turnout", countTurnoutVoters)
+	nominations := election + "/nominations"
+	memberBearer := "Bearer " + checkMemberToken
+	c.call("v1 nominations", apiCall{method: "GET", path: nominations, target: "/api/v1/elections/nominations/nominations", status: 200})
+	c.call("v1 nominate", apiCall{method: "POST", path: nominations, target: "/api/v1/elections/nominations/nominations",
+		auth: memberBearer, contentType: jsonType, body: `{"name":"D","description":"Nominated *candidate*"}`, status: 200})
+	c.call("v1 nominate without name", apiCall{method: "POST", path: nominations, target: "/api/v1/elections/nominations/nominations",
+		auth: memberBearer, contentType: jsonType, body: `{"name":" "}`, status: 400})
+	c.call("v1 nominate after nomination phase", apiCall{method: "POST", path: nominations, target: "/api/v1/elections/check/nominations",
+		auth: bearer, contentType: jsonType, body: `{"name":"D"}`, status: 403})
+	c.call("v1 vote in nomination phase", apiCall{method: "PUT", path: election + "/ballot", target: "/api/v1/elections/nominations/ballot",
+		auth: memberBearer, contentType: jsonType, body: `{"rankgroups":[]}`, status: 403})
+	c.call("v1 nomination", apiCall{method: "GET", path: nominations + "/{id}", target: "/api/v1/elections/nominations/nominations/1", status: 200})
+	c.call("v1 unknown nomination", apiCall{method: "GET", path: nominations + "/{id}", target: "/api/v1/elections/nominations/nominations/99", status: 404})
+	c.call("v1 second own nomination", apiCall{method: "PUT", path: nominations + "/{id}/second", target: "/api/v1/elections/nominations/nominations/1/second",
+		auth: memberBearer, status: 403})
+	c.call("v1 second nomination", apiCall{method: "PUT", path: nominations + "/{id}/second", target: "/api/v1/elections/nominations/nominations/1/second",
+		auth: bearer, status: 200})
+	c.call("v1 approve nomination without manager", apiCall{method: "PUT", path: nominations + "/{id}", target: "/api/v1/elections/nominations/nominations/1",
+		auth: memberBearer, contentType: jsonType, body: `{"status":"approved"}`, status: 403})
+	c.call("v1 approve nomination", apiCall{method: "PUT", path: nominations + "/{id}", target: "/api/v1/elections/nominations/nominations/1",
+		auth: bearer, contentType: jsonType, body: `{"status":"approved"}`, status: 200})
+	c.call("v1 reject approved nomination", apiCall{method: "PUT", path: nominations + "/{id}", target: "/api/v1/elections/nominations/nominations/1",
+		auth: bearer, contentType: jsonType, body: `{"status":"rejected"}`, status: 409})
+	c.call("v1 vote with secret ballot", apiCall{method: "PUT", path: election + "/ballot", target: "/api/v1/elections/secret/ballot",
+		contentType: jsonType, body: `{"auth":{"name":"erin"},"rankgroups":[[0],[1]]}`, status: 200})
+	c.call("v1 live results of secret election", apiCall{method: "GET", path: election + "/results", target: "/api/v1/elections/secret/results",
+		auth: bearer, status: 403})
+	c.call("v1 audit without admin", apiCall{method: "GET", path: "/api/v1/audit", target: "/api/v1/audit", status: 403})
+	c.call("v1 audit", apiCall{method: "GET", path: "/api/v1/audit", target: "/api/v1/audit", auth: bearer, status: 200})
+
+	c.checkClient(codes[1])
+
+	c.check("vote in closed election", "/vote", "check", `{"auth":{"name":"carol"},"rankgroups":[[0],[1],[2]]}`, 403)
+	c.check("ballots", "/ballots", "check", `{}`, 200)
+	c.call("v1 vote in closed election", apiCall{method: "PUT", path: election + "/ballot", target: "/api/v1/elections/check/ballot",
+		contentType: jsonType, body: `{"auth":{"name":"erin"},"rankgroups":[[0],[1],[2]]}`, status: 403})
+	c.call("v1 ballots", apiCall{method: "GET", path: election + "/ballots", target: "/api/v1/elections/check/ballots", status: 200})
+	c.call("v1 results of closed secret election", apiCall{method: "GET", path: election + "/results", target: "/api/v1/elections/secret/results", status: 200})
+	c.checkCoverage()
+}
@@ -0,0 +1,82 @@
+package backend
+
+/* election lists for the landing page and the dashboard of logged-in
+ * users
+ */
+
+type ElectionState string
+
+const (
+	ElectionNominating ElectionState = "nominating" // nomination phase
+	ElectionUpcoming   ElectionState = "upcoming"   // scheduled, not open yet
+	ElectionOpen       ElectionState = "open"
+	ElectionClosed     ElectionState = "closed"
+)
+
+type ElectionOverview struct {
+	Election       *Election
+	State          ElectionState
+	CanVote        bool // user can vote in the election (or could while it was open)
+	Voted          bool
+	ResultsVisible bool
+}
+
+// closed manually or by the schedule (the scheduler sets Closed and
+// freezes the results a little later)
+func (etx *ElectionsTx) IsElectionClosed(e *Election) bool {
+	return e.Closed || (!e.ClosesAt.IsZero() && !etx.now().Before(e.ClosesAt))
+}
+
+func (etx *ElectionsTx) ElectionState(e *Election) ElectionState {
+	now := etx.now()
+	if etx.IsElectionClosed(e) {
+		return ElectionClosed
+	} else if e.Nominating {
+		return ElectionNominating
+	} else if !e.OpensAt.IsZero() && now.Before(e.OpensAt) {
+		return ElectionUpcoming
+	}
+	return ElectionOpen
+}
+
+// CanVote errors which don't depend on the election state
+func isVoterError(err error) bool {
+	switch err {
+	case nil, ErrorElectionClosed, ErrorElectionNotOpenYet, ErrorElectionNominating, ErrorAlreadyVoted, ErrorBallotCodeUsed, ErrorElectionMembersOnlyEdit:
+		return true
+	}
+	return false
+}
+
+// elections user (nil: anonymous) can see, ordered by creation; voting
+// status only for registered users
+func (etx *ElectionsTx) ElectionOverviews(user *User) ([]ElectionOverview, error) {
+	elections, err := etx.Elections()
+	if nil != err {
+		return nil, err
+	}
+	var overviews []ElectionOverview
+	for _, e := range elections {
+		if visible, err := etx.CanSeeElection(user, e); nil != err {
+			return nil, err
+		} else if !visible {
+			continue
+		}
+		o := ElectionOverview{Election: e, State: etx.ElectionState(e)}
+		if o.ResultsVisible, err = etx.CanSeeResults(user, e); nil != err {
+			return nil, err
+		}
+		if nil != user && user.Email.Valid {
+			if err := etx.CanVote(user, e); isVoterError(err) {
+				o.CanVote = true
+			} else if CodeInternal == ErrorCode(err) {
+				return nil, err
+			}
+			if o.Voted, err = etx.hasVoted(user, e); nil != err {
+				return nil, err
+			}
+		}
+		overviews = append(overviews, o)
+	}
+	return overviews, nil
+}
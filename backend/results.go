@@ -0,0 +1,85 @@
+package backend
+
+var ErrorResultsNotVisible = newError("results_not_visible", "Results are not available")
+var ErrorInvalidResultsPolicy = newError("invalid_results_policy", "Invalid results policy")
+
+// who can see (live) results of an election; results of secret elections
+// are only visible after they closed, whatever the policy
+type ResultsPolicy string
+
+const (
+	ResultsAlways           ResultsPolicy = "always"
+	ResultsAfterClose       ResultsPolicy = "after-close"
+	ResultsAfterCloseVoters ResultsPolicy = "after-close-voters"
+	ResultsManagers         ResultsPolicy = "managers"
+)
+
+var ResultsPolicies = []ResultsPolicy{
+	ResultsAlways,
+	ResultsAfterClose,
+	ResultsAfterCloseVoters,
+	ResultsManagers,
+}
+
+func (p ResultsPolicy) Valid() bool {
+	for _, known := range ResultsPolicies {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (p ResultsPolicy) Description() string {
+	switch p {
+	case ResultsAlways:
+		return "Results are always visible."
+	case ResultsAfterClose:
+		return "Results are visible after the election closed."
+	case ResultsAfterCloseVoters:
+		return "Results are visible to voters after the election closed."
+	case ResultsManagers:
+		return "Results are only visible to election managers."
+	default:
+		return ""
+	}
+}
+
+// managers can always see ballots and results (except live results of
+// secret elections)
+func (etx *ElectionsTx) IsElectionManager(user *User, e *Election) bool {
+	return nil != user && user.SiteAdmin
+}
+
+func (etx *ElectionsTx) CanSeeResults(user *User, e *Election) (bool, error) {
+	if e.Secret && !etx.IsElectionClosed(e) {
+		// results changing with each vote would link ballots to voters
+		return false, nil
+	} else if etx.IsElectionManager(user, e) {
+		return true, nil
+	}
+	switch e.Results {
+	case ResultsAlways:
+		return true, nil
+	case ResultsAfterClose:
+		return etx.IsElectionClosed(e), nil
+	case ResultsAfterCloseVoters:
+		if !etx.IsElectionClosed(e) || nil == user {
+			return false, nil
+		}
+		return etx.hasVoted(user, e)
+	default:
+		return false, nil
+	}
+}
+
+func (etx *ElectionsTx) SetElectionResultsPolicy(e *Election, actor *User, policy ResultsPolicy) error {
+	if !policy.Valid() {
+		return ErrorInvalidResultsPolicy
+	}
+	e.Results = policy
+	if err := etx.st.UpdateElection(e); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditResultsPolicy, e, actor, policy)
+}
@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// elections (as they were) a scheduled change failed for; they are skipped
+// (and the failure is only logged once) until the election changes, e.g.
+// after an admin approved nominations or moved the schedule. only known to
+// this process (like the event hub)
+type scheduleFailures struct {
+	mutex     sync.Mutex
+	elections map[int64]Election
+}
+
+func newScheduleFailures() *scheduleFailures {
+	return &scheduleFailures{elections: make(map[int64]Election)}
+}
+
+// whether the change failed for the unchanged election before
+func (s *scheduleFailures) failed(e *Election) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failed, ok := s.elections[e.Eid]
+	return ok && reflect.DeepEqual(failed, *e)
+}
+
+func (s *scheduleFailures) record(e *Election) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.elections[e.Eid] = *e
+}
+
+func (s *scheduleFailures) forget(e *Election) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.elections, e.Eid)
+}
+
+// start and close elections according to their schedule
+func (edb ElectionsDb) RunSchedule() error {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return err
+	}
+	defer etx.Rollback()
+
+	now := etx.now().Unix()
+
+	elections, err := etx.st.Elections()
+	if nil != err {
+		return err
+	}
+	for _, e := range elections {
+		if e.Closed {
+			continue
+		}
+		if e.Nominating && !e.OpensAt.IsZero() && e.OpensAt.Unix() <= now && !edb.nominationFailures.failed(e) {
+			// the nomination phase ends when voting opens
+			if err := etx.SetElectionNominations(e, nil, false, e.NominationSeconds); nil == err {
+				edb.nominationFailures.forget(e)
+			} else if CodeInternal == ErrorCode(err) {
+				return err
+			} else {
+				logErrorf("Can't end nomination phase of election %d (retried after it changed): %v", e.Eid, err)
+				edb.nominationFailures.record(e)
+			}
+		}
+		if !e.Nominating && !e.Started && !e.OpensAt.IsZero() && e.OpensAt.Unix() <= now {
+			e.Started = true
+			if err := etx.st.UpdateElection(e); nil != err {
+				return err
+			} else if err := etx.audit(AuditElectionStarted, e, nil, nil); nil != err {
+				return err
+			}
+			etx.notifyAfterCommit(e, EventElectionStarted)
+		}
+		if !e.ClosesAt.IsZero() && e.ClosesAt.Unix() <= now {
+			if err := etx.SetElectionClosed(e, nil, true); nil != err {
+				return err
+			}
+			etx.notifyAfterCommit(e, EventElectionClosed)
+		}
+	}
+
+	return etx.Commit()
+}
+
+// runs the schedule in the background every interval until stop is called;
+// stop waits for a running schedule check to finish
+func (edb ElectionsDb) StartScheduler(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(finished)
+		defer ticker.Stop()
+		for {
+			if err := edb.Retry(edb.RunSchedule); nil != err {
+				logErrorf("Scheduler failed: %v", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,218 @@
+package backend
+
+import (
+	"database/sql"
+	"github.com/stbuehler/go-vote/types"
+	"testing"
+	"time"
+)
+
+// records the events of the scheduler
+type recordingNotifier struct {
+	events *[]ElectionEvent
+}
+
+func (n recordingNotifier) NotifyElection(e *Election, event ElectionEvent) {
+	*n.events = append(*n.events, event)
+}
+
+type scheduleTest struct {
+	t      *testing.T
+	now    time.Time
+	events []ElectionEvent
+	edb    ElectionsDb
+}
+
+func newScheduleTest(t *testing.T) *scheduleTest {
+	s := &scheduleTest{t: t, now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s.edb = NewMemoryDatabase().WithClock(func() time.Time {
+		return s.now
+	}).WithNotifier(recordingNotifier{&s.events})
+	return s
+}
+
+// runs f in a committed transaction
+func (s *scheduleTest) update(f func(etx *ElectionsTx) error) {
+	s.t.Helper()
+	etx, err := s.edb.StartTransaction()
+	if nil != err {
+		s.t.Fatal(err)
+	}
+	defer etx.Rollback()
+	if err := f(etx); nil != err {
+		s.t.Fatal(err)
+	} else if err := etx.Commit(); nil != err {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *scheduleTest) election() (*ElectionsTx, *Election) {
+	s.t.Helper()
+	etx, err := s.edb.StartTransaction()
+	if nil != err {
+		s.t.Fatal(err)
+	}
+	e, err := etx.ElectionByName("scheduled")
+	if nil != err {
+		etx.Rollback()
+		s.t.Fatal(err)
+	}
+	return etx, e
+}
+
+func (s *scheduleTest) runSchedule() {
+	s.t.Helper()
+	if err := s.edb.RunSchedule(); nil != err {
+		s.t.Fatal(err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := newScheduleTest(t)
+	opensAt := s.now.Add(time.Hour)
+	closesAt := s.now.Add(2 * time.Hour)
+	voter := &User{Name: "voter", Email: sql.NullString{String: "voter@example.com", Valid: true}}
+	s.update(func(etx *ElectionsTx) error {
+		e := &Election{
+			Name:       "scheduled",
+			Candidates: []Candidate{{Name: "A"}, {Name: "B"}},
+			OpensAt:    opensAt,
+			ClosesAt:   closesAt,
+		}
+		if err := etx.CreateUser(voter, nil); nil != err {
+			return err
+		} else if err := etx.CreateElection(e, nil); nil != err {
+			return err
+		}
+		return etx.AddElectionMember(e, voter, "", nil)
+	})
+
+	// before OpensAt nothing happens
+	s.runSchedule()
+	etx, e := s.election()
+	if ElectionUpcoming != etx.ElectionState(e) || e.Started {
+		t.Fatalf("expected upcoming election, got %s (started: %v)", etx.ElectionState(e), e.Started)
+	} else if err := etx.CanVote(voter, e); ErrorElectionNotOpenYet != err {
+		t.Fatalf("expected %v, got %v", ErrorElectionNotOpenYet, err)
+	}
+	etx.Rollback()
+	if 0 != len(s.events) {
+		t.Fatalf("unexpected events %v", s.events)
+	}
+
+	// opens at OpensAt
+	s.now = opensAt
+	s.runSchedule()
+	etx, e = s.election()
+	if ElectionOpen != etx.ElectionState(e) || !e.Started {
+		t.Fatalf("expected started election, got %s (started: %v)", etx.ElectionState(e), e.Started)
+	}
+	etx.Rollback()
+	if 1 != len(s.events) || EventElectionStarted != s.events[0] {
+		t.Fatalf("expected start event, got %v", s.events)
+	}
+	// the opening is announced only once
+	s.runSchedule()
+	if 1 != len(s.events) {
+		t.Fatalf("unexpected events %v", s.events)
+	}
+
+	s.now = opensAt.Add(time.Minute)
+	s.update(func(etx *ElectionsTx) error {
+		if e, err := etx.ElectionByName("scheduled"); nil != err {
+			return err
+		} else {
+			_, err = etx.ElectionVote(e, voter, types.Ranking{1, 0})
+			return err
+		}
+	})
+	if 2 != len(s.events) || EventVoteCast != s.events[1] {
+		t.Fatalf("expected vote event, got %v", s.events)
+	}
+	etx, e = s.election()
+	if _, err := etx.st.FrozenResults(e.Eid); errStorageNotFound != err {
+		t.Fatalf("results frozen before close: %v", err)
+	}
+	etx.Rollback()
+
+	// past ClosesAt the election counts as closed even before the scheduler ran
+	s.now = closesAt
+	etx, e = s.election()
+	if e.Closed || ElectionClosed != etx.ElectionState(e) {
+		t.Fatalf("expected closed election, got %s (closed: %v)", etx.ElectionState(e), e.Closed)
+	} else if err := etx.CanVote(voter, e); ErrorElectionClosed != err {
+		t.Fatalf("expected %v, got %v", ErrorElectionClosed, err)
+	}
+	e.Results = ResultsAfterClose
+	if visible, err := etx.CanSeeResults(nil, e); nil != err || !visible {
+		t.Fatalf("expected visible results, got %v (%v)", visible, err)
+	}
+	etx.Rollback()
+
+	// closes at ClosesAt, with frozen results
+	s.runSchedule()
+	etx, e = s.election()
+	defer etx.Rollback()
+	if !e.Closed {
+		t.Fatal("election not closed")
+	} else if 3 != len(s.events) || EventElectionClosed != s.events[2] {
+		t.Fatalf("expected close event, got %v", s.events)
+	}
+	frozen, err := etx.st.FrozenResults(e.Eid)
+	if nil != err {
+		t.Fatalf("results not frozen: %v", err)
+	}
+	expected := types.PairwisePreferences{{0, 0}, {1, 0}}
+	if !frozen.Equal(expected) {
+		t.Fatalf("expected frozen results %v, got %v", expected, frozen)
+	}
+	if err := etx.CanVote(voter, e); ErrorElectionClosed != err {
+		t.Fatalf("expected %v, got %v", ErrorElectionClosed, err)
+	}
+}
+
+// without enough candidates the nomination phase can't end; the election is
+// skipped until it changes
+func TestSchedulerNominationFailure(t *testing.T) {
+	s := newScheduleTest(t)
+	s.update(func(etx *ElectionsTx) error {
+		return etx.CreateElection(&Election{
+			Name:       "scheduled",
+			Candidates: []Candidate{{Name: "A"}},
+			OpensAt:    s.now,
+			Nominating: true,
+		}, nil)
+	})
+
+	s.runSchedule()
+	etx, e := s.election()
+	if !e.Nominating {
+		t.Fatal("nomination phase ended with a single candidate")
+	} else if !s.edb.nominationFailures.failed(e) {
+		t.Fatal("failure not recorded")
+	}
+	etx.Rollback()
+	s.runSchedule()
+	if 0 != len(s.events) {
+		t.Fatalf("unexpected events %v", s.events)
+	}
+
+	s.update(func(etx *ElectionsTx) error {
+		if e, err := etx.ElectionByName("scheduled"); nil != err {
+			return err
+		} else {
+			return etx.ChangeCandidates(e, nil, nil, []Candidate{{Name: "B"}}, false)
+		}
+	})
+	s.events = nil
+	s.runSchedule()
+	etx, e = s.election()
+	defer etx.Rollback()
+	if e.Nominating || !e.Started {
+		t.Fatalf("expected started election (nominating: %v, started: %v)", e.Nominating, e.Started)
+	} else if s.edb.nominationFailures.failed(e) {
+		t.Fatal("failure still recorded")
+	} else if 1 != len(s.events) || EventElectionStarted != s.events[0] {
+		t.Fatalf("expected start event, got %v", s.events)
+	}
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"errors"
+	"github.com/stbuehler/go-vote/types"
+)
+
+/* the storage only stores and loads data; all checks (who can vote, see
+ * results, ...) are done in ElectionsTx.
+ *
+ * errors not listed in the method descriptions are internal errors.
+ */
+
+var errStorageNotFound = errors.New("not found")
+var errStorageConflict = errors.New("unique constraint violated")
+
+type Storage interface {
+	Begin() (StorageTx, error)
+	Close() error
+	// whether a transaction failed because the database was busy or
+	// because of conflicting transactions
+	Retryable(err error) bool
+}
+
+type StorageTx interface {
+	Commit() error
+	Rollback() error
+
+	// errStorageNotFound
+	UserByUid(uid int64) (*User, error)
+	// errStorageNotFound
+	UserByToken(token string) (*User, error)
+	// errStorageNotFound
+	UserByEmail(email string) (*User, error)
+	// unregistered user not belonging to a ballot code; errStorageNotFound
+	UnregisteredUserByName(name string) (*User, error)
+	// errStorageConflict if email, token or (for unregistered users) name are not unique
+	CreateUser(user *User) (uid int64, err error)
+	// only users nothing refers to yet
+	DeleteUser(uid int64) error
+	// store name, email, token and siteadmin; errStorageConflict if email
+	// or token are not unique
+	UpdateUser(user *User) error
+	// users with email and token, ordered by uid
+	RegisteredUsers() ([]*User, error)
+
+	// errStorageConflict if code already exists
+	CreateBallotCode(eid int64, code string) error
+	// uid is 0 if code wasn't used yet; errStorageNotFound
+	BallotCode(code string) (eid int64, uid int64, err error)
+	// only claims unused codes; errStorageConflict if the code already has
+	// a user (concurrent first use)
+	SetBallotCodeUser(code string, uid int64) error
+	CountBallotCodes(eid int64) (int, error)
+
+	// errStorageNotFound
+	ElectionByName(name string) (*Election, error)
+	Elections() ([]*Election, error)
+	// errStorageConflict if name is not unique; doesn't store candidates
+	CreateElection(e *Election) (eid int64, err error)
+	// store all fields but Eid, Name and Candidates
+	UpdateElection(e *Election) error
+	// replace the candidates of an election: updates candidates with id,
+	// adds those without and removes the missing ones. returns the
+	// candidates with ids; errStorageNotFound for ids of other elections
+	SetCandidates(eid int64, candidates []Candidate) ([]Candidate, error)
+
+	// nominations with nominator name and number of seconds, ordered by id
+	Nominations(eid int64) ([]Nomination, error)
+	// errStorageNotFound (also for nominations of other elections)
+	NominationById(eid, nid int64) (*Nomination, error)
+	// stores all fields but Id, Nominator and Seconds
+	CreateNomination(n *Nomination) (nid int64, err error)
+	SetNominationStatus(nid int64, status NominationStatus) error
+	// errStorageConflict if uid already seconded the nomination
+	InsertSecond(nid, uid int64) error
+
+	// members are listed in the vote table, with or without ranking
+	IsMember(eid, uid int64) (bool, error)
+	// marks existing voters as listed too; group can be empty
+	AddMember(eid, uid int64, group string) error
+	// only removes members without vote
+	RemoveMember(eid, uid int64) error
+	// listed members, ordered by uid; secret: whether participation
+	// records votes
+	ListedMembers(eid int64, secret bool) ([]Member, error)
+	// nil ranking if no vote was cast; errStorageNotFound if not a member
+	VoteRanking(eid, uid int64) (types.Ranking, error)
+	// errStorageConflict if a ranking was already stored. votedAt is a
+	// unix timestamp
+	InsertVote(eid, uid int64, ballot types.Ballot, votedAt int64) error
+	// keeps the time of the first vote
+	InsertOrReplaceVote(eid, uid int64, ballot types.Ballot, votedAt int64) error
+	// removes the rankings of all votes and flags the voters for review;
+	// the rows (and members) stay
+	ResetVotes(eid int64) error
+	CountMembers(eid int64) (int, error)
+	Members(eid int64, offset, limit int) ([]Vote, error)
+
+	// secret elections: participation and anonymous ballots
+	HasParticipated(eid, uid int64) (bool, error)
+	// errStorageConflict if already participated
+	InsertParticipation(eid, uid int64, votedAt int64) error
+	CountParticipants(eid int64) (int, error)
+	Participants(eid int64, offset, limit int) ([]Vote, error)
+	InsertBallot(eid int64, ballot types.Ballot) error
+
+	// members and voters (from participation in secret elections)
+	TurnoutRecords(eid int64, secret bool) ([]TurnoutRecord, error)
+
+	// cast ballots (from votes or secret ballots), ordered by ballot id
+	Ballots(eid int64, secret bool) ([]types.Ballot, error)
+	// replace the ranking of a cast ballot (after the candidates changed)
+	SetBallotRanking(eid int64, secret bool, bid string, ranking types.Ranking) error
+
+	// voters (votes or participation) flagged after the candidates changed;
+	// casting a ballot clears the flag
+	FlagReview(eid int64, secret bool) error
+	NeedsReview(eid, uid int64, secret bool) (bool, error)
+
+	// pairwise preferences maintained along with the cast ballots;
+	// errStorageNotFound if not initialised yet
+	Tally(eid int64, numCandidates int) (types.PairwisePreferences, error)
+	// add delta (entries can be negative) to the initialised tally
+	AddTally(eid int64, delta types.PairwisePreferences) error
+	// (re)initialise tally
+	SetTally(eid int64, prefs types.PairwisePreferences) error
+	// initialise tally; keeps entries a concurrent transaction stored first
+	InitTally(eid int64, prefs types.PairwisePreferences) error
+	// concurrent transactions must not cast ballots in the election (block
+	// them until this transaction ends, or fail retryable)
+	LockElection(eid int64) error
+
+	// errStorageNotFound
+	FrozenResults(eid int64) (types.PairwisePreferences, error)
+	FreezeResults(eid int64, time int64, prefs types.PairwisePreferences) error
+	DeleteFrozenResults(eid int64) error
+
+	// nil if empty; concurrent transactions must not append after the same
+	// entry (block them until this transaction ends, or fail retryable)
+	LastAuditEntry() (*AuditEntry, error)
+	AppendAuditEntry(a AuditEntry) error
+	AuditLog() ([]AuditEntry, error)
+}
@@ -0,0 +1,763 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/stbuehler/go-vote/types"
+	"sort"
+	"sync"
+)
+
+/* in-memory storage, mostly for tests. transactions are serialized; each
+ * transaction works on a copy of the state which replaces the state on
+ * commit.
+ *
+ * stored rankings and candidate lists are never modified in place, so
+ * copying the maps is enough.
+ */
+
+type memVoteKey struct {
+	eid, uid int64
+}
+
+type memVote struct {
+	ranking types.Ranking // nil: member without vote
+	bid     string
+	votedAt int64
+	listed  bool
+	group   string
+	review  bool
+}
+
+type memBallotCode struct {
+	eid, uid int64
+}
+
+type memBallot struct {
+	eid     int64
+	ranking types.Ranking
+}
+
+type memSecondKey struct {
+	nid, uid int64
+}
+
+type memResult struct {
+	time  int64
+	prefs types.PairwisePreferences
+}
+
+type memState struct {
+	nextUid       int64
+	users         map[int64]User
+	nextEid       int64
+	elections     map[int64]Election
+	nextCid       int64
+	nextNid       int64
+	nominations   map[int64]Nomination // without Nominator and Seconds
+	seconds       map[memSecondKey]bool
+	ballotCodes   map[string]memBallotCode
+	votes         map[memVoteKey]memVote
+	participation map[memVoteKey]bool
+	votedAt       map[memVoteKey]int64 // participation
+	review        map[memVoteKey]bool  // participation
+	ballots       map[string]memBallot
+	results       map[int64]memResult
+	tallies       map[int64]types.PairwisePreferences
+	audit         []AuditEntry
+}
+
+func (s *memState) clone() *memState {
+	c := &memState{
+		nextUid:       s.nextUid,
+		users:         make(map[int64]User, len(s.users)),
+		nextEid:       s.nextEid,
+		elections:     make(map[int64]Election, len(s.elections)),
+		nextCid:       s.nextCid,
+		nextNid:       s.nextNid,
+		nominations:   make(map[int64]Nomination, len(s.nominations)),
+		seconds:       make(map[memSecondKey]bool, len(s.seconds)),
+		ballotCodes:   make(map[string]memBallotCode, len(s.ballotCodes)),
+		votes:         make(map[memVoteKey]memVote, len(s.votes)),
+		participation: make(map[memVoteKey]bool, len(s.participation)),
+		votedAt:       make(map[memVoteKey]int64, len(s.votedAt)),
+		review:        make(map[memVoteKey]bool, len(s.review)),
+		ballots:       make(map[string]memBallot, len(s.ballots)),
+		results:       make(map[int64]memResult, len(s.results)),
+		tallies:       make(map[int64]types.PairwisePreferences, len(s.tallies)),
+		// append-only
+		audit: s.audit[:len(s.audit):len(s.audit)],
+	}
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for k, v := range s.elections {
+		c.elections[k] = v
+	}
+	for k, v := range s.nominations {
+		c.nominations[k] = v
+	}
+	for k, v := range s.seconds {
+		c.seconds[k] = v
+	}
+	for k, v := range s.ballotCodes {
+		c.ballotCodes[k] = v
+	}
+	for k, v := range s.votes {
+		c.votes[k] = v
+	}
+	for k, v := range s.participation {
+		c.participation[k] = v
+	}
+	for k, v := range s.votedAt {
+		c.votedAt[k] = v
+	}
+	for k, v := range s.review {
+		c.review[k] = v
+	}
+	for k, v := range s.ballots {
+		c.ballots[k] = v
+	}
+	for k, v := range s.results {
+		c.results[k] = v
+	}
+	for k, v := range s.tallies {
+		c.tallies[k] = v
+	}
+	return c
+}
+
+type memoryStorage struct {
+	mutex sync.Mutex
+	state *memState
+}
+
+func NewMemoryDatabase() ElectionsDb {
+	return NewElectionsDb(&memoryStorage{
+		state: (&memState{nextUid: 1, nextEid: 1, nextCid: 1, nextNid: 1}).clone(),
+	})
+}
+
+func (s *memoryStorage) Begin() (StorageTx, error) {
+	s.mutex.Lock()
+	return &memoryStorageTx{storage: s, state: s.state.clone()}, nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+// transactions are serialized
+func (s *memoryStorage) Retryable(err error) bool {
+	return false
+}
+
+type memoryStorageTx struct {
+	storage *memoryStorage
+	state   *memState
+}
+
+func (t *memoryStorageTx) Commit() error {
+	t.storage.state = t.state
+	t.storage.mutex.Unlock()
+	return nil
+}
+
+func (t *memoryStorageTx) Rollback() error {
+	t.storage.mutex.Unlock()
+	return nil
+}
+
+func (t *memoryStorageTx) user(u User) *User {
+	if !u.Email.Valid || !u.Token.Valid {
+		u.SiteAdmin = false
+		u.Email = sql.NullString{}
+		u.Token = sql.NullString{}
+	}
+	return &u
+}
+
+func (t *memoryStorageTx) UserByUid(uid int64) (*User, error) {
+	if u, ok := t.state.users[uid]; ok {
+		return t.user(u), nil
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) UserByToken(token string) (*User, error) {
+	for _, u := range t.state.users {
+		if u.Token.Valid && u.Token.String == token {
+			return t.user(u), nil
+		}
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) UserByEmail(email string) (*User, error) {
+	for _, u := range t.state.users {
+		if u.Email.Valid && u.Email.String == email {
+			return t.user(u), nil
+		}
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) isBallotCodeUser(uid int64) bool {
+	for _, bc := range t.state.ballotCodes {
+		if bc.uid == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *memoryStorageTx) UnregisteredUserByName(name string) (*User, error) {
+	for _, u := range t.state.users {
+		if u.Name == name && !u.Email.Valid && !t.isBallotCodeUser(u.Uid) {
+			return t.user(u), nil
+		}
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) CreateUser(user *User) (int64, error) {
+	for _, u := range t.state.users {
+		if (user.Email.Valid && u.Email == user.Email) || (user.Token.Valid && u.Token == user.Token) || (!user.Email.Valid && !u.Email.Valid && u.Name == user.Name) {
+			return 0, errStorageConflict
+		}
+	}
+	u := *user
+	u.Uid = t.state.nextUid
+	u.BallotCodeEid = 0
+	t.state.nextUid++
+	t.state.users[u.Uid] = u
+	return u.Uid, nil
+}
+
+func (t *memoryStorageTx) DeleteUser(uid int64) error {
+	delete(t.state.users, uid)
+	return nil
+}
+
+func (t *memoryStorageTx) UpdateUser(user *User) error {
+	for _, u := range t.state.users {
+		if u.Uid != user.Uid && ((user.Email.Valid && u.Email == user.Email) || (user.Token.Valid && u.Token == user.Token)) {
+			return errStorageConflict
+		}
+	}
+	if stored, ok := t.state.users[user.Uid]; ok {
+		stored.Name = user.Name
+		stored.Email = user.Email
+		stored.Token = user.Token
+		stored.SiteAdmin = user.SiteAdmin
+		t.state.users[user.Uid] = stored
+	}
+	return nil
+}
+
+func (t *memoryStorageTx) RegisteredUsers() ([]*User, error) {
+	var users []*User
+	for _, u := range t.state.users {
+		if u.Email.Valid && u.Token.Valid {
+			users = append(users, t.user(u))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Uid < users[j].Uid
+	})
+	return users, nil
+}
+
+func (t *memoryStorageTx) CreateBallotCode(eid int64, code string) error {
+	if _, ok := t.state.ballotCodes[code]; ok {
+		return errStorageConflict
+	}
+	t.state.ballotCodes[code] = memBallotCode{eid: eid}
+	return nil
+}
+
+func (t *memoryStorageTx) BallotCode(code string) (int64, int64, error) {
+	if bc, ok := t.state.ballotCodes[code]; ok {
+		return bc.eid, bc.uid, nil
+	}
+	return 0, 0, errStorageNotFound
+}
+
+func (t *memoryStorageTx) SetBallotCodeUser(code string, uid int64) error {
+	if bc, ok := t.state.ballotCodes[code]; !ok || 0 != bc.uid {
+		return errStorageConflict
+	} else {
+		bc.uid = uid
+		t.state.ballotCodes[code] = bc
+	}
+	return nil
+}
+
+func (t *memoryStorageTx) CountBallotCodes(eid int64) (int, error) {
+	count := 0
+	for _, bc := range t.state.ballotCodes {
+		if bc.eid == eid {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (t *memoryStorageTx) ElectionByName(name string) (*Election, error) {
+	for _, e := range t.state.elections {
+		if e.Name == name {
+			return &e, nil
+		}
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) Elections() ([]*Election, error) {
+	elections := make([]*Election, 0, len(t.state.elections))
+	for _, e := range t.state.elections {
+		e := e
+		elections = append(elections, &e)
+	}
+	sort.Slice(elections, func(i, j int) bool {
+		return elections[i].Eid < elections[j].Eid
+	})
+	return elections, nil
+}
+
+func (t *memoryStorageTx) CreateElection(e *Election) (int64, error) {
+	if _, err := t.ElectionByName(e.Name); nil == err {
+		return 0, errStorageConflict
+	}
+	stored := *e
+	stored.Eid = t.state.nextEid
+	stored.Candidates = nil
+	t.state.nextEid++
+	t.state.elections[stored.Eid] = stored
+	return stored.Eid, nil
+}
+
+func (t *memoryStorageTx) UpdateElection(e *Election) error {
+	if stored, ok := t.state.elections[e.Eid]; ok {
+		updated := *e
+		updated.Name = stored.Name
+		updated.Candidates = stored.Candidates
+		t.state.elections[e.Eid] = updated
+	}
+	return nil
+}
+
+func (t *memoryStorageTx) SetCandidates(eid int64, candidates []Candidate) ([]Candidate, error) {
+	e, ok := t.state.elections[eid]
+	if !ok {
+		return nil, errStorageNotFound
+	}
+	known := make(map[int64]bool)
+	for _, c := range e.Candidates {
+		known[c.Id] = true
+	}
+	stored := make([]Candidate, len(candidates))
+	for i, c := range candidates {
+		if 0 == c.Id {
+			c.Id = t.state.nextCid
+			t.state.nextCid++
+		} else if !known[c.Id] {
+			return nil, errStorageNotFound
+		}
+		stored[i] = c
+	}
+	e.Candidates = stored
+	t.state.elections[eid] = e
+	return stored, nil
+}
+
+// with nominator name and seconds
+func (t *memoryStorageTx) nomination(n Nomination) Nomination {
+	n.Nominator = t.state.users[n.Uid].Name
+	for key := range t.state.seconds {
+		if key.nid == n.Id {
+			n.Seconds++
+		}
+	}
+	return n
+}
+
+func (t *memoryStorageTx) Nominations(eid int64) ([]Nomination, error) {
+	var nominations []Nomination
+	for _, n := range t.state.nominations {
+		if n.Eid == eid {
+			nominations = append(nominations, t.nomination(n))
+		}
+	}
+	sort.Slice(nominations, func(i, j int) bool {
+		return nominations[i].Id < nominations[j].Id
+	})
+	return nominations, nil
+}
+
+func (t *memoryStorageTx) NominationById(eid, nid int64) (*Nomination, error) {
+	if n, ok := t.state.nominations[nid]; ok && n.Eid == eid {
+		n = t.nomination(n)
+		return &n, nil
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) CreateNomination(n *Nomination) (int64, error) {
+	stored := *n
+	stored.Id = t.state.nextNid
+	stored.Nominator = ""
+	stored.Seconds = 0
+	t.state.nextNid++
+	t.state.nominations[stored.Id] = stored
+	return stored.Id, nil
+}
+
+func (t *memoryStorageTx) SetNominationStatus(nid int64, status NominationStatus) error {
+	if n, ok := t.state.nominations[nid]; ok {
+		n.Status = status
+		t.state.nominations[nid] = n
+	}
+	return nil
+}
+
+func (t *memoryStorageTx) InsertSecond(nid, uid int64) error {
+	key := memSecondKey{nid, uid}
+	if t.state.seconds[key] {
+		return errStorageConflict
+	}
+	t.state.seconds[key] = true
+	return nil
+}
+
+func (t *memoryStorageTx) IsMember(eid, uid int64) (bool, error) {
+	_, ok := t.state.votes[memVoteKey{eid, uid}]
+	return ok, nil
+}
+
+func (t *memoryStorageTx) AddMember(eid, uid int64, group string) error {
+	key := memVoteKey{eid, uid}
+	v := t.state.votes[key]
+	v.listed = true
+	v.group = group
+	t.state.votes[key] = v
+	return nil
+}
+
+func (t *memoryStorageTx) RemoveMember(eid, uid int64) error {
+	key := memVoteKey{eid, uid}
+	if v, ok := t.state.votes[key]; ok && nil == v.ranking {
+		delete(t.state.votes, key)
+	}
+	return nil
+}
+
+func (t *memoryStorageTx) ListedMembers(eid int64, secret bool) ([]Member, error) {
+	var members []Member
+	for _, key := range t.memberKeys(eid) {
+		v := t.state.votes[key]
+		if !v.listed {
+			continue
+		}
+		u := t.state.users[key.uid]
+		m := Member{Uid: u.Uid, Name: u.Name, Email: u.Email.String, Group: v.group, Voted: nil != v.ranking}
+		if secret {
+			m.Voted = t.state.participation[key]
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (t *memoryStorageTx) VoteRanking(eid, uid int64) (types.Ranking, error) {
+	if v, ok := t.state.votes[memVoteKey{eid, uid}]; ok {
+		return v.ranking, nil
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) InsertVote(eid, uid int64, ballot types.Ballot, votedAt int64) error {
+	key := memVoteKey{eid, uid}
+	v := t.state.votes[key]
+	if nil != v.ranking {
+		return errStorageConflict
+	}
+	v.ranking = ballot.Ranking
+	v.bid = ballot.Id
+	v.votedAt = votedAt
+	v.review = false
+	t.state.votes[key] = v
+	return nil
+}
+
+func (t *memoryStorageTx) InsertOrReplaceVote(eid, uid int64, ballot types.Ballot, votedAt int64) error {
+	key := memVoteKey{eid, uid}
+	v := t.state.votes[key]
+	if nil == v.ranking {
+		v.votedAt = votedAt
+	}
+	v.ranking = ballot.Ranking
+	v.bid = ballot.Id
+	v.review = false
+	t.state.votes[key] = v
+	return nil
+}
+
+func (t *memoryStorageTx) ResetVotes(eid int64) error {
+	for key, v := range t.state.votes {
+		if key.eid == eid && nil != v.ranking {
+			v.ranking = nil
+			v.bid = ""
+			v.votedAt = 0
+			v.review = true
+			t.state.votes[key] = v
+		}
+	}
+	return nil
+}
+
+// sorted by uid
+func (t *memoryStorageTx) voteKeys(eid int64, table map[memVoteKey]bool) []memVoteKey {
+	var keys []memVoteKey
+	for key := range table {
+		if key.eid == eid {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].uid < keys[j].uid
+	})
+	return keys
+}
+
+func (t *memoryStorageTx) memberKeys(eid int64) []memVoteKey {
+	members := make(map[memVoteKey]bool)
+	for key := range t.state.votes {
+		members[key] = true
+	}
+	return t.voteKeys(eid, members)
+}
+
+func page(keys []memVoteKey, offset, limit int) []memVoteKey {
+	if offset >= len(keys) {
+		return nil
+	}
+	keys = keys[offset:]
+	if limit < len(keys) {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+func (t *memoryStorageTx) CountMembers(eid int64) (int, error) {
+	return len(t.memberKeys(eid)), nil
+}
+
+func (t *memoryStorageTx) Members(eid int64, offset, limit int) ([]Vote, error) {
+	var votes []Vote
+	for _, key := range page(t.memberKeys(eid), offset, limit) {
+		u := t.state.users[key.uid]
+		votes = append(votes, Vote{Name: u.Name, Email: u.Email, Ranking: t.state.votes[key].ranking})
+	}
+	return votes, nil
+}
+
+func (t *memoryStorageTx) HasParticipated(eid, uid int64) (bool, error) {
+	return t.state.participation[memVoteKey{eid, uid}], nil
+}
+
+func (t *memoryStorageTx) InsertParticipation(eid, uid int64, votedAt int64) error {
+	key := memVoteKey{eid, uid}
+	if t.state.participation[key] {
+		return errStorageConflict
+	}
+	t.state.participation[key] = true
+	t.state.votedAt[key] = votedAt
+	return nil
+}
+
+func (t *memoryStorageTx) CountParticipants(eid int64) (int, error) {
+	return len(t.voteKeys(eid, t.state.participation)), nil
+}
+
+func (t *memoryStorageTx) Participants(eid int64, offset, limit int) ([]Vote, error) {
+	var votes []Vote
+	for _, key := range page(t.voteKeys(eid, t.state.participation), offset, limit) {
+		u := t.state.users[key.uid]
+		votes = append(votes, Vote{Name: u.Name, Email: u.Email})
+	}
+	return votes, nil
+}
+
+func (t *memoryStorageTx) InsertBallot(eid int64, ballot types.Ballot) error {
+	t.state.ballots[ballot.Id] = memBallot{eid: eid, ranking: ballot.Ranking}
+	return nil
+}
+
+func (t *memoryStorageTx) TurnoutRecords(eid int64, secret bool) ([]TurnoutRecord, error) {
+	var records []TurnoutRecord
+	for _, key := range t.memberKeys(eid) {
+		v := t.state.votes[key]
+		r := TurnoutRecord{Group: v.group, Listed: v.listed, Voted: nil != v.ranking, VotedAt: v.votedAt}
+		if secret {
+			r.Voted, r.VotedAt = t.state.participation[key], t.state.votedAt[key]
+		}
+		records = append(records, r)
+	}
+	if secret {
+		for _, key := range t.voteKeys(eid, t.state.participation) {
+			if _, ok := t.state.votes[key]; !ok {
+				records = append(records, TurnoutRecord{Voted: true, VotedAt: t.state.votedAt[key]})
+			}
+		}
+	}
+	return records, nil
+}
+
+func (t *memoryStorageTx) Ballots(eid int64, secret bool) ([]types.Ballot, error) {
+	var ballots []types.Ballot
+	if secret {
+		for bid, b := range t.state.ballots {
+			if b.eid == eid {
+				ballots = append(ballots, types.NewBallot(bid, b.ranking))
+			}
+		}
+	} else {
+		for key, v := range t.state.votes {
+			if key.eid == eid && nil != v.ranking && 0 != len(v.bid) {
+				ballots = append(ballots, types.NewBallot(v.bid, v.ranking))
+			}
+		}
+	}
+	sort.Slice(ballots, func(i, j int) bool {
+		return ballots[i].Id < ballots[j].Id
+	})
+	return ballots, nil
+}
+
+func (t *memoryStorageTx) SetBallotRanking(eid int64, secret bool, bid string, ranking types.Ranking) error {
+	if secret {
+		if b, ok := t.state.ballots[bid]; ok && b.eid == eid {
+			b.ranking = ranking
+			t.state.ballots[bid] = b
+		}
+		return nil
+	}
+	for key, v := range t.state.votes {
+		if key.eid == eid && v.bid == bid {
+			v.ranking = ranking
+			t.state.votes[key] = v
+		}
+	}
+	return nil
+}
+
+func (t *memoryStorageTx) FlagReview(eid int64, secret bool) error {
+	if secret {
+		for _, key := range t.voteKeys(eid, t.state.participation) {
+			t.state.review[key] = true
+		}
+		return nil
+	}
+	for key, v := range t.state.votes {
+		if key.eid == eid && nil != v.ranking {
+			v.review = true
+			t.state.votes[key] = v
+		}
+	}
+	return nil
+}
+
+func (t *memoryStorageTx) NeedsReview(eid, uid int64, secret bool) (bool, error) {
+	key := memVoteKey{eid, uid}
+	if secret {
+		return t.state.review[key], nil
+	}
+	return t.state.votes[key].review, nil
+}
+
+func (t *memoryStorageTx) Tally(eid int64, numCandidates int) (types.PairwisePreferences, error) {
+	if prefs, ok := t.state.tallies[eid]; !ok {
+		return nil, errStorageNotFound
+	} else if len(prefs) != numCandidates {
+		return nil, fmt.Errorf("Tally: stored for %d candidates, expected %d", len(prefs), numCandidates)
+	} else {
+		return copyPreferences(prefs), nil
+	}
+}
+
+func copyPreferences(prefs types.PairwisePreferences) types.PairwisePreferences {
+	c := types.PairwisePreferences(types.NewPairwise(len(prefs)))
+	for runner, line := range prefs {
+		copy(c[runner], line)
+	}
+	return c
+}
+
+// tallies are shared with older states: replace instead of modifying
+func (t *memoryStorageTx) AddTally(eid int64, delta types.PairwisePreferences) error {
+	prefs, ok := t.state.tallies[eid]
+	if !ok {
+		return nil
+	}
+	prefs = copyPreferences(prefs)
+	for runner, line := range delta {
+		for opponent, wins := range line {
+			if runner < len(prefs) && opponent < len(prefs) {
+				prefs[runner][opponent] += wins
+			}
+		}
+	}
+	t.state.tallies[eid] = prefs
+	return nil
+}
+
+func (t *memoryStorageTx) SetTally(eid int64, prefs types.PairwisePreferences) error {
+	t.state.tallies[eid] = copyPreferences(prefs)
+	return nil
+}
+
+func (t *memoryStorageTx) InitTally(eid int64, prefs types.PairwisePreferences) error {
+	if _, ok := t.state.tallies[eid]; !ok {
+		t.state.tallies[eid] = copyPreferences(prefs)
+	}
+	return nil
+}
+
+// transactions are serialized anyway
+func (t *memoryStorageTx) LockElection(eid int64) error {
+	return nil
+}
+
+func (t *memoryStorageTx) FrozenResults(eid int64) (types.PairwisePreferences, error) {
+	if r, ok := t.state.results[eid]; ok {
+		return r.prefs, nil
+	}
+	return nil, errStorageNotFound
+}
+
+func (t *memoryStorageTx) FreezeResults(eid int64, time int64, prefs types.PairwisePreferences) error {
+	t.state.results[eid] = memResult{time: time, prefs: prefs}
+	return nil
+}
+
+func (t *memoryStorageTx) DeleteFrozenResults(eid int64) error {
+	delete(t.state.results, eid)
+	return nil
+}
+
+func (t *memoryStorageTx) LastAuditEntry() (*AuditEntry, error) {
+	if 0 == len(t.state.audit) {
+		return nil, nil
+	}
+	a := t.state.audit[len(t.state.audit)-1]
+	return &a, nil
+}
+
+func (t *memoryStorageTx) AppendAuditEntry(a AuditEntry) error {
+	t.state.audit = append(t.state.audit, a)
+	return nil
+}
+
+func (t *memoryStorageTx) AuditLog() ([]AuditEntry, error) {
+	return append([]AuditEntry(nil), t.state.audit...), nil
+}
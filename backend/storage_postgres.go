@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+)
+
+var postgresDialect = sqlDialect{
+	name:                 "postgres",
+	numberedPlaceholders: true,
+	migrations: []Migration{{
+		Version:     1,
+		Description: "initial schema",
+		statements: []string{`
+CREATE TABLE IF NOT EXISTS "user" (
+	uid BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	email TEXT UNIQUE,
+	token TEXT UNIQUE,
+	siteadmin BOOLEAN NOT NULL DEFAULT FALSE
+)`, `
+CREATE UNIQUE INDEX IF NOT EXISTS user_unique_unregistered ON "user" (name) WHERE email IS NULL`, `
+CREATE TABLE IF NOT EXISTS election (
+	eid BIGSERIAL PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	title TEXT NOT NULL DEFAULT '',
+	candidates TEXT NOT NULL,
+	closed BOOLEAN NOT NULL DEFAULT FALSE,
+	public BOOLEAN NOT NULL DEFAULT FALSE,
+	open BOOLEAN NOT NULL DEFAULT FALSE,
+	editopen BOOLEAN NOT NULL DEFAULT FALSE,
+	secret BOOLEAN NOT NULL DEFAULT FALSE,
+	opens_at BIGINT,
+	closes_at BIGINT,
+	started BOOLEAN NOT NULL DEFAULT FALSE,
+	results TEXT NOT NULL DEFAULT 'always'
+)`, `
+CREATE TABLE IF NOT EXISTS vote (
+	eid BIGINT NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid BIGINT NOT NULL REFERENCES "user" ON DELETE RESTRICT ON UPDATE CASCADE,
+	ranking TEXT,
+	bid TEXT UNIQUE,
+	UNIQUE (eid, uid)
+)`, `
+CREATE TABLE IF NOT EXISTS ballotcode (
+	code TEXT PRIMARY KEY,
+	eid BIGINT NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid BIGINT UNIQUE REFERENCES "user" ON DELETE RESTRICT ON UPDATE CASCADE
+)`, `
+CREATE TABLE IF NOT EXISTS participation (
+	eid BIGINT NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid BIGINT NOT NULL REFERENCES "user" ON DELETE RESTRICT ON UPDATE CASCADE,
+	UNIQUE (eid, uid)
+)`,
+			// ballots are only ever accessed ordered by their random id. a
+			// ballot has the same xmin as the participation row inserted
+			// with it: database administrators can link them
+			`
+CREATE TABLE IF NOT EXISTS ballot (
+	bid TEXT PRIMARY KEY,
+	eid BIGINT NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	ranking TEXT NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS audit (
+	seq BIGINT PRIMARY KEY,
+	time BIGINT NOT NULL,
+	action TEXT NOT NULL,
+	eid BIGINT NOT NULL,
+	uid BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	hash TEXT NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS result (
+	eid BIGINT PRIMARY KEY REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	time BIGINT NOT NULL,
+	preferences TEXT NOT NULL
+)`,
+		},
+	}, {
+		Version:     2,
+		Description: "pairwise tallies",
+		statements: []string{
+			// filled from the ballots when first needed
+			`
+CREATE TABLE tally (
+	eid BIGINT NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	runner INTEGER NOT NULL,
+	opponent INTEGER NOT NULL,
+	wins INTEGER NOT NULL,
+	PRIMARY KEY (eid, runner, opponent)
+)`,
+		},
+	}, {
+		Version:     3,
+		Description: "vote timestamps and member groups",
+		statements: []string{
+			// NULL for votes cast before timestamps were recorded
+			`ALTER TABLE vote ADD COLUMN voted_at BIGINT`,
+			// members (eligible voters) as opposed to users who only voted
+			`ALTER TABLE vote ADD COLUMN listed BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE vote ADD COLUMN member_group TEXT`,
+			`UPDATE vote SET listed = TRUE WHERE ranking IS NULL OR (eid IN (SELECT eid FROM election WHERE NOT open) AND uid NOT IN (SELECT uid FROM ballotcode WHERE uid IS NOT NULL))`,
+			`ALTER TABLE participation ADD COLUMN voted_at BIGINT`,
+		},
+	}, {
+		Version:     4,
+		Description: "candidate records",
+		statements: []string{
+			// position: index of the candidate in rankings and results
+			`
+CREATE TABLE candidate (
+	cid BIGSERIAL PRIMARY KEY,
+	eid BIGINT NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	position INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	image TEXT NOT NULL DEFAULT '',
+	UNIQUE (eid, position)
+)`,
+			`INSERT INTO candidate (eid, position, name) SELECT election.eid, c.position - 1, c.name FROM election, jsonb_array_elements_text(election.candidates::jsonb) WITH ORDINALITY AS c(name, position) ORDER BY election.eid, c.position`,
+			`ALTER TABLE election DROP COLUMN candidates`,
+		},
+	}, {
+		Version:     5,
+		Description: "nominations",
+		statements: []string{
+			`ALTER TABLE election ADD COLUMN nominating BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE election ADD COLUMN nomination_seconds INTEGER NOT NULL DEFAULT 0`,
+			`
+CREATE TABLE nomination (
+	nid BIGSERIAL PRIMARY KEY,
+	eid BIGINT NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid BIGINT NOT NULL REFERENCES "user" ON DELETE RESTRICT ON UPDATE CASCADE,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	image TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	created BIGINT NOT NULL
+)`, `
+CREATE TABLE nomination_second (
+	nid BIGINT NOT NULL REFERENCES nomination ON DELETE CASCADE ON UPDATE CASCADE,
+	uid BIGINT NOT NULL REFERENCES "user" ON DELETE RESTRICT ON UPDATE CASCADE,
+	PRIMARY KEY (nid, uid)
+)`,
+		},
+	}, {
+		Version:     6,
+		Description: "ballot review after candidate changes",
+		statements: []string{
+			`ALTER TABLE vote ADD COLUMN review BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE participation ADD COLUMN review BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	}, {
+		Version:     7,
+		Description: "partial ballots",
+		statements: []string{
+			`ALTER TABLE election ADD COLUMN unranked TEXT NOT NULL DEFAULT 'last'`,
+		},
+	}, {
+		Version:     8,
+		Description: "reopen nominations (RON) candidate",
+		statements: []string{
+			`ALTER TABLE candidate ADD COLUMN reserved BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	}},
+	isConflict: func(err error) bool {
+		if e, ok := err.(*pq.Error); ok {
+			return "23505" == e.SQLState() // unique_violation
+		}
+		return false
+	},
+	isRetryable: func(err error) bool {
+		var e *pq.Error
+		if errors.As(err, &e) {
+			// serialization_failure, deadlock_detected
+			return "40001" == e.SQLState() || "40P01" == e.SQLState()
+		}
+		return false
+	},
+	// under READ COMMITTED concurrent transactions would otherwise read the
+	// same chain head and append the same sequence number; the lock is held
+	// until the transaction ends
+	lockAudit: `SELECT pg_advisory_xact_lock(hashtext('go-vote audit'))`,
+	// otherwise concurrent ballots of the same voter both read the same
+	// previous ballot and subtract it from the tally twice
+	lockElection: `SELECT eid FROM election WHERE eid = ? FOR UPDATE`,
+}
+
+// db must use the "postgres" driver
+func ConnectPostgres(db *sql.DB) (ElectionsDb, error) {
+	if storage, err := newSqlStorage(db, &postgresDialect); nil != err {
+		return ElectionsDb{}, err
+	} else {
+		return NewElectionsDb(storage), nil
+	}
+}
+
+// db must use the "postgres" driver
+func MigratePostgres(db *sql.DB, dryRun bool) ([]Migration, error) {
+	return migrateDatabase(db, &postgresDialect, dryRun)
+}
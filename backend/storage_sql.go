@@ -0,0 +1,856 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/stbuehler/go-vote/types"
+	"strconv"
+	"strings"
+)
+
+/* queries are written in the SQL subset shared by SQLite and PostgreSQL
+ * (quoted "user" table, ON CONFLICT, RETURNING) using "?" placeholders;
+ * dialects provide the schema migrations and rewrite placeholders if needed.
+ *
+ * MySQL is not supported: it has neither ON CONFLICT nor RETURNING, so it
+ * would need its own queries instead of a dialect.
+ */
+
+type sqlDialect struct {
+	name string
+	// rewrite "?" to "$1", "$2", ...
+	numberedPlaceholders bool
+	// executed on connect
+	setup []string
+	// migrations[i] has version i+1
+	migrations []Migration
+	// schema version of databases without schema_version entries
+	legacyVersion func(tx *sql.Tx) (int, error)
+	// detect unique constraint violations
+	isConflict func(err error) bool
+	// detect busy database and serialization failures
+	isRetryable func(err error) bool
+	// executed before reading the end of the audit chain to serialize
+	// appends; empty if the database only allows a single writer anyway
+	lockAudit string
+	// executed before reading the previous ballot of a voter; empty if the
+	// database only allows a single writer anyway
+	lockElection string
+}
+
+func (d *sqlDialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+	var buf strings.Builder
+	n := 0
+	for _, c := range query {
+		if '?' == c {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+		} else {
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+type sqlStorage struct {
+	db      *sql.DB
+	dialect *sqlDialect
+}
+
+func newSqlStorage(db *sql.DB, dialect *sqlDialect) (*sqlStorage, error) {
+	for _, stmt := range dialect.setup {
+		if _, err := db.Exec(stmt); nil != err {
+			return nil, err
+		}
+	}
+	if err := dialect.checkSchema(db); nil != err {
+		return nil, err
+	}
+	return &sqlStorage{db: db, dialect: dialect}, nil
+}
+
+func (s *sqlStorage) Begin() (StorageTx, error) {
+	if tx, err := s.db.Begin(); nil != err {
+		return nil, err
+	} else {
+		return &sqlStorageTx{tx: tx, dialect: s.dialect}, nil
+	}
+}
+
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStorage) Retryable(err error) bool {
+	return s.dialect.isRetryable(err)
+}
+
+type sqlStorageTx struct {
+	tx      *sql.Tx
+	dialect *sqlDialect
+}
+
+func (t *sqlStorageTx) exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlStorageTx) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *sqlStorageTx) queryRow(query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+// map unique constraint violations to errStorageConflict
+func (t *sqlStorageTx) conflict(op string, err error) error {
+	if nil == err {
+		return nil
+	} else if t.dialect.isConflict(err) {
+		return errStorageConflict
+	} else {
+		return fmt.Errorf("%s failed: %w", op, err)
+	}
+}
+
+func (t *sqlStorageTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlStorageTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+const userColumns = `uid, name, email, token, siteadmin`
+
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	if err := row.Scan(&user.Uid, &user.Name, &user.Email, &user.Token, &user.SiteAdmin); nil != err {
+		return nil, err
+	} else {
+		if !user.Email.Valid || !user.Token.Valid {
+			user.SiteAdmin = false
+			user.Email = sql.NullString{}
+			user.Token = sql.NullString{}
+		}
+		return &user, nil
+	}
+}
+
+func (t *sqlStorageTx) findUser(op string, where string, args ...interface{}) (*User, error) {
+	if user, err := scanUser(t.queryRow(`SELECT `+userColumns+` FROM "user" WHERE `+where, args...)); sql.ErrNoRows == err {
+		return nil, errStorageNotFound
+	} else if nil != err {
+		return nil, fmt.Errorf("%s failed: %w", op, err)
+	} else {
+		return user, nil
+	}
+}
+
+func (t *sqlStorageTx) UserByUid(uid int64) (*User, error) {
+	return t.findUser("UserByUid", `uid = ?`, uid)
+}
+
+func (t *sqlStorageTx) UserByToken(token string) (*User, error) {
+	return t.findUser("UserByToken", `token = ?`, token)
+}
+
+func (t *sqlStorageTx) UserByEmail(email string) (*User, error) {
+	return t.findUser("UserByEmail", `email = ?`, email)
+}
+
+func (t *sqlStorageTx) UnregisteredUserByName(name string) (*User, error) {
+	return t.findUser("UnregisteredUserByName", `name = ? AND email IS NULL AND uid NOT IN (SELECT uid FROM ballotcode WHERE uid IS NOT NULL)`, name)
+}
+
+func (t *sqlStorageTx) CreateUser(user *User) (int64, error) {
+	var uid int64
+	err := t.queryRow(`INSERT INTO "user" (name, email, token, siteadmin) VALUES (?, ?, ?, ?) RETURNING uid`, user.Name, user.Email, user.Token, user.SiteAdmin).Scan(&uid)
+	return uid, t.conflict("CreateUser", err)
+}
+
+func (t *sqlStorageTx) DeleteUser(uid int64) error {
+	if _, err := t.exec(`DELETE FROM "user" WHERE uid = ?`, uid); nil != err {
+		return fmt.Errorf("DeleteUser failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) UpdateUser(user *User) error {
+	_, err := t.exec(`UPDATE "user" SET name = ?, email = ?, token = ?, siteadmin = ? WHERE uid = ?`, user.Name, user.Email, user.Token, user.SiteAdmin, user.Uid)
+	return t.conflict("UpdateUser", err)
+}
+
+func (t *sqlStorageTx) RegisteredUsers() ([]*User, error) {
+	if rows, err := t.query(`SELECT ` + userColumns + ` FROM "user" WHERE email IS NOT NULL AND token IS NOT NULL ORDER BY uid`); nil != err {
+		return nil, fmt.Errorf("RegisteredUsers failed: %w", err)
+	} else {
+		defer rows.Close()
+		var users []*User
+		for rows.Next() {
+			if user, err := scanUser(rows); nil != err {
+				return nil, fmt.Errorf("RegisteredUsers scan failed: %w", err)
+			} else {
+				users = append(users, user)
+			}
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("RegisteredUsers cursor failed: %w", err)
+		}
+		return users, nil
+	}
+}
+
+func (t *sqlStorageTx) CreateBallotCode(eid int64, code string) error {
+	_, err := t.exec(`INSERT INTO ballotcode (code, eid) VALUES (?, ?)`, code, eid)
+	return t.conflict("CreateBallotCode", err)
+}
+
+func (t *sqlStorageTx) BallotCode(code string) (int64, int64, error) {
+	var eid int64
+	var uid sql.NullInt64
+	if err := t.queryRow(`SELECT eid, uid FROM ballotcode WHERE code = ?`, code).Scan(&eid, &uid); sql.ErrNoRows == err {
+		return 0, 0, errStorageNotFound
+	} else if nil != err {
+		return 0, 0, fmt.Errorf("BallotCode failed: %w", err)
+	}
+	return eid, uid.Int64, nil
+}
+
+func (t *sqlStorageTx) SetBallotCodeUser(code string, uid int64) error {
+	// a concurrent transaction claiming the code first makes this a no-op
+	if result, err := t.exec(`UPDATE ballotcode SET uid = ? WHERE code = ? AND uid IS NULL`, uid, code); nil != err {
+		return fmt.Errorf("SetBallotCodeUser failed: %w", err)
+	} else if n, err := result.RowsAffected(); nil != err {
+		return fmt.Errorf("SetBallotCodeUser failed: %w", err)
+	} else if 0 == n {
+		return errStorageConflict
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) CountBallotCodes(eid int64) (int, error) {
+	return t.count("CountBallotCodes", `SELECT COUNT(*) FROM ballotcode WHERE eid = ?`, eid)
+}
+
+const electionColumns = `eid, name, title, closed, public, open, editopen, secret, opens_at, closes_at, started, results, nominating, nomination_seconds, unranked`
+
+func scanElection(row rowScanner) (*Election, error) {
+	var e Election
+	var opensAt, closesAt sql.NullInt64
+	if err := row.Scan(&e.Eid, &e.Name, &e.Title, &e.Closed, &e.Public, &e.Open, &e.EditOpen, &e.Secret, &opensAt, &closesAt, &e.Started, &e.Results, &e.Nominating, &e.NominationSeconds, &e.Unranked); nil != err {
+		return nil, err
+	} else {
+		e.OpensAt = unixTime(opensAt)
+		e.ClosesAt = unixTime(closesAt)
+		return &e, nil
+	}
+}
+
+// candidates (ordered by position) of the elections
+func (t *sqlStorageTx) loadCandidates(op string, elections ...*Election) error {
+	query := `SELECT eid, cid, name, description, url, image, reserved FROM candidate ORDER BY eid, position`
+	var args []interface{}
+	if 1 == len(elections) {
+		query = `SELECT eid, cid, name, description, url, image, reserved FROM candidate WHERE eid = ? ORDER BY position`
+		args = append(args, elections[0].Eid)
+	}
+	byEid := make(map[int64]*Election, len(elections))
+	for _, e := range elections {
+		byEid[e.Eid] = e
+	}
+	if rows, err := t.query(query, args...); nil != err {
+		return fmt.Errorf("%s candidates failed: %w", op, err)
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var eid int64
+			var c Candidate
+			if err := rows.Scan(&eid, &c.Id, &c.Name, &c.Description, &c.Url, &c.Image, &c.Reserved); nil != err {
+				return fmt.Errorf("%s candidates scan failed: %w", op, err)
+			} else if e, ok := byEid[eid]; ok {
+				e.Candidates = append(e.Candidates, c)
+			}
+		}
+		if err := rows.Err(); nil != err {
+			return fmt.Errorf("%s candidates cursor failed: %w", op, err)
+		}
+		return nil
+	}
+}
+
+func (t *sqlStorageTx) ElectionByName(name string) (*Election, error) {
+	if e, err := scanElection(t.queryRow(`SELECT `+electionColumns+` FROM election WHERE name = ?`, name)); sql.ErrNoRows == err {
+		return nil, errStorageNotFound
+	} else if nil != err {
+		return nil, fmt.Errorf("ElectionByName failed: %w", err)
+	} else if err := t.loadCandidates("ElectionByName", e); nil != err {
+		return nil, err
+	} else {
+		return e, nil
+	}
+}
+
+func (t *sqlStorageTx) Elections() ([]*Election, error) {
+	if rows, err := t.query(`SELECT ` + electionColumns + ` FROM election ORDER BY eid`); nil != err {
+		return nil, fmt.Errorf("Elections failed: %w", err)
+	} else {
+		defer rows.Close()
+		var elections []*Election
+		for rows.Next() {
+			if e, err := scanElection(rows); nil != err {
+				return nil, fmt.Errorf("Elections scan failed: %w", err)
+			} else {
+				elections = append(elections, e)
+			}
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("Elections cursor failed: %w", err)
+		}
+		rows.Close()
+		if 0 != len(elections) {
+			if err := t.loadCandidates("Elections", elections...); nil != err {
+				return nil, err
+			}
+		}
+		return elections, nil
+	}
+}
+
+func (t *sqlStorageTx) CreateElection(e *Election) (int64, error) {
+	var eid int64
+	err := t.queryRow(`INSERT INTO election (name, title, closed, public, open, editopen, secret, opens_at, closes_at, started, results, nominating, nomination_seconds, unranked) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING eid`,
+		e.Name, e.Title, e.Closed, e.Public, e.Open, e.EditOpen, e.Secret, nullUnixTime(e.OpensAt), nullUnixTime(e.ClosesAt), e.Started, string(e.Results), e.Nominating, e.NominationSeconds, string(e.Unranked)).Scan(&eid)
+	return eid, t.conflict("CreateElection", err)
+}
+
+func (t *sqlStorageTx) UpdateElection(e *Election) error {
+	if _, err := t.exec(`UPDATE election SET title = ?, closed = ?, public = ?, open = ?, editopen = ?, secret = ?, opens_at = ?, closes_at = ?, started = ?, results = ?, nominating = ?, nomination_seconds = ?, unranked = ? WHERE eid = ?`,
+		e.Title, e.Closed, e.Public, e.Open, e.EditOpen, e.Secret, nullUnixTime(e.OpensAt), nullUnixTime(e.ClosesAt), e.Started, string(e.Results), e.Nominating, e.NominationSeconds, string(e.Unranked), e.Eid); nil != err {
+		return fmt.Errorf("UpdateElection failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) SetCandidates(eid int64, candidates []Candidate) ([]Candidate, error) {
+	keep := make(map[int64]bool)
+	for _, c := range candidates {
+		keep[c.Id] = true
+	}
+	var remove []int64
+	if rows, err := t.query(`SELECT cid FROM candidate WHERE eid = ?`, eid); nil != err {
+		return nil, fmt.Errorf("SetCandidates failed: %w", err)
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var cid int64
+			if err := rows.Scan(&cid); nil != err {
+				return nil, fmt.Errorf("SetCandidates scan failed: %w", err)
+			} else if !keep[cid] {
+				remove = append(remove, cid)
+			}
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("SetCandidates cursor failed: %w", err)
+		}
+		rows.Close()
+	}
+	for _, cid := range remove {
+		if _, err := t.exec(`DELETE FROM candidate WHERE cid = ?`, cid); nil != err {
+			return nil, fmt.Errorf("SetCandidates failed: %w", err)
+		}
+	}
+	// move remaining candidates out of the way (positions are unique)
+	if _, err := t.exec(`UPDATE candidate SET position = -1 - position WHERE eid = ?`, eid); nil != err {
+		return nil, fmt.Errorf("SetCandidates failed: %w", err)
+	}
+	stored := make([]Candidate, len(candidates))
+	for position, c := range candidates {
+		if 0 == c.Id {
+			if err := t.queryRow(`INSERT INTO candidate (eid, position, name, description, url, image, reserved) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING cid`, eid, position, c.Name, c.Description, c.Url, c.Image, c.Reserved).Scan(&c.Id); nil != err {
+				return nil, fmt.Errorf("SetCandidates failed: %w", err)
+			}
+		} else if result, err := t.exec(`UPDATE candidate SET position = ?, name = ?, description = ?, url = ?, image = ?, reserved = ? WHERE cid = ? AND eid = ?`, position, c.Name, c.Description, c.Url, c.Image, c.Reserved, c.Id, eid); nil != err {
+			return nil, fmt.Errorf("SetCandidates failed: %w", err)
+		} else if n, err := result.RowsAffected(); nil != err {
+			return nil, fmt.Errorf("SetCandidates failed: %w", err)
+		} else if 0 == n {
+			return nil, errStorageNotFound
+		}
+		stored[position] = c
+	}
+	return stored, nil
+}
+
+const nominationColumns = `nomination.nid, nomination.eid, nomination.uid, "user".name, nomination.name, nomination.description, nomination.url, nomination.image, nomination.status, nomination.created, (SELECT COUNT(*) FROM nomination_second WHERE nomination_second.nid = nomination.nid)`
+
+func scanNomination(row rowScanner) (*Nomination, error) {
+	var n Nomination
+	if err := row.Scan(&n.Id, &n.Eid, &n.Uid, &n.Nominator, &n.Name, &n.Description, &n.Url, &n.Image, &n.Status, &n.Created, &n.Seconds); nil != err {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (t *sqlStorageTx) Nominations(eid int64) ([]Nomination, error) {
+	if rows, err := t.query(`SELECT `+nominationColumns+` FROM nomination JOIN "user" ON nomination.uid = "user".uid WHERE nomination.eid = ? ORDER BY nomination.nid`, eid); nil != err {
+		return nil, fmt.Errorf("Nominations failed: %w", err)
+	} else {
+		defer rows.Close()
+		var nominations []Nomination
+		for rows.Next() {
+			if n, err := scanNomination(rows); nil != err {
+				return nil, fmt.Errorf("Nominations scan failed: %w", err)
+			} else {
+				nominations = append(nominations, *n)
+			}
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("Nominations cursor failed: %w", err)
+		}
+		return nominations, nil
+	}
+}
+
+func (t *sqlStorageTx) NominationById(eid, nid int64) (*Nomination, error) {
+	if n, err := scanNomination(t.queryRow(`SELECT `+nominationColumns+` FROM nomination JOIN "user" ON nomination.uid = "user".uid WHERE nomination.nid = ? AND nomination.eid = ?`, nid, eid)); sql.ErrNoRows == err {
+		return nil, errStorageNotFound
+	} else if nil != err {
+		return nil, fmt.Errorf("NominationById failed: %w", err)
+	} else {
+		return n, nil
+	}
+}
+
+func (t *sqlStorageTx) CreateNomination(n *Nomination) (int64, error) {
+	var nid int64
+	if err := t.queryRow(`INSERT INTO nomination (eid, uid, name, description, url, image, status, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING nid`,
+		n.Eid, n.Uid, n.Name, n.Description, n.Url, n.Image, string(n.Status), n.Created).Scan(&nid); nil != err {
+		return 0, fmt.Errorf("CreateNomination failed: %w", err)
+	}
+	return nid, nil
+}
+
+func (t *sqlStorageTx) SetNominationStatus(nid int64, status NominationStatus) error {
+	if _, err := t.exec(`UPDATE nomination SET status = ? WHERE nid = ?`, string(status), nid); nil != err {
+		return fmt.Errorf("SetNominationStatus failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) InsertSecond(nid, uid int64) error {
+	_, err := t.exec(`INSERT INTO nomination_second (nid, uid) VALUES (?, ?)`, nid, uid)
+	return t.conflict("InsertSecond", err)
+}
+
+func (t *sqlStorageTx) exists(op string, query string, args ...interface{}) (bool, error) {
+	var one int
+	if err := t.queryRow(query, args...).Scan(&one); sql.ErrNoRows == err {
+		return false, nil
+	} else if nil != err {
+		return false, fmt.Errorf("%s failed: %w", op, err)
+	}
+	return true, nil
+}
+
+func (t *sqlStorageTx) count(op string, query string, args ...interface{}) (int, error) {
+	var count int
+	if err := t.queryRow(query, args...).Scan(&count); nil != err {
+		return 0, fmt.Errorf("%s failed: %w", op, err)
+	}
+	return count, nil
+}
+
+func (t *sqlStorageTx) IsMember(eid, uid int64) (bool, error) {
+	return t.exists("IsMember", `SELECT 1 FROM vote WHERE eid = ? AND uid = ?`, eid, uid)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: 0 != len(s)}
+}
+
+func (t *sqlStorageTx) AddMember(eid, uid int64, group string) error {
+	if _, err := t.exec(`INSERT INTO vote (eid, uid, listed, member_group) VALUES (?, ?, ?, ?) ON CONFLICT (eid, uid) DO UPDATE SET listed = excluded.listed, member_group = excluded.member_group`, eid, uid, true, nullString(group)); nil != err {
+		return fmt.Errorf("AddMember failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) RemoveMember(eid, uid int64) error {
+	if _, err := t.exec(`DELETE FROM vote WHERE eid = ? AND uid = ? AND ranking IS NULL`, eid, uid); nil != err {
+		return fmt.Errorf("RemoveMember failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) ListedMembers(eid int64, secret bool) ([]Member, error) {
+	voted := `vote.ranking IS NOT NULL`
+	if secret {
+		voted = `EXISTS (SELECT 1 FROM participation WHERE participation.eid = vote.eid AND participation.uid = vote.uid)`
+	}
+	if rows, err := t.query(`SELECT vote.uid, "user".name, COALESCE("user".email, ''), COALESCE(vote.member_group, ''), `+voted+` FROM vote JOIN "user" ON vote.uid = "user".uid WHERE vote.eid = ? AND vote.listed ORDER BY vote.uid`, eid); nil != err {
+		return nil, fmt.Errorf("ListedMembers failed: %w", err)
+	} else {
+		defer rows.Close()
+		var members []Member
+		for rows.Next() {
+			var m Member
+			if err := rows.Scan(&m.Uid, &m.Name, &m.Email, &m.Group, &m.Voted); nil != err {
+				return nil, fmt.Errorf("ListedMembers scan failed: %w", err)
+			}
+			members = append(members, m)
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("ListedMembers cursor failed: %w", err)
+		}
+		return members, nil
+	}
+}
+
+func parseRanking(op string, rankingJson sql.NullString) (types.Ranking, error) {
+	var ranking types.Ranking
+	if !rankingJson.Valid {
+		return nil, nil
+	} else if err := json.Unmarshal([]byte(rankingJson.String), &ranking); nil != err {
+		return nil, fmt.Errorf("%s parse ranking (%+q) failed: %w", op, rankingJson.String, err)
+	}
+	return ranking, nil
+}
+
+func (t *sqlStorageTx) VoteRanking(eid, uid int64) (types.Ranking, error) {
+	var rankingJson sql.NullString
+	if err := t.queryRow(`SELECT ranking FROM vote WHERE eid = ? AND uid = ?`, eid, uid).Scan(&rankingJson); sql.ErrNoRows == err {
+		return nil, errStorageNotFound
+	} else if nil != err {
+		return nil, fmt.Errorf("VoteRanking failed: %w", err)
+	}
+	return parseRanking("VoteRanking", rankingJson)
+}
+
+func (t *sqlStorageTx) InsertVote(eid, uid int64, ballot types.Ballot, votedAt int64) error {
+	// rows without ranking: members, and votes that were reset
+	if result, err := t.exec(`INSERT INTO vote (eid, uid, ranking, bid, voted_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT (eid, uid) DO UPDATE SET ranking = excluded.ranking, bid = excluded.bid, voted_at = excluded.voted_at, review = ? WHERE vote.ranking IS NULL`, eid, uid, types.JsonMustEncodeString(ballot.Ranking), ballot.Id, votedAt, false); nil != err {
+		return t.conflict("InsertVote", err)
+	} else if rows, err := result.RowsAffected(); nil != err {
+		return fmt.Errorf("InsertVote failed: %w", err)
+	} else if 0 == rows {
+		return errStorageConflict
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) InsertOrReplaceVote(eid, uid int64, ballot types.Ballot, votedAt int64) error {
+	// members without a vote get the time of this vote
+	if _, err := t.exec(`INSERT INTO vote (eid, uid, ranking, bid, voted_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT (eid, uid) DO UPDATE SET ranking = excluded.ranking, bid = excluded.bid, voted_at = CASE WHEN vote.ranking IS NULL THEN excluded.voted_at ELSE vote.voted_at END, review = ?`, eid, uid, types.JsonMustEncodeString(ballot.Ranking), ballot.Id, votedAt, false); nil != err {
+		return fmt.Errorf("InsertOrReplaceVote failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) ResetVotes(eid int64) error {
+	if _, err := t.exec(`UPDATE vote SET ranking = NULL, bid = NULL, voted_at = NULL, review = ? WHERE eid = ? AND ranking IS NOT NULL`, true, eid); nil != err {
+		return fmt.Errorf("ResetVotes failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) CountMembers(eid int64) (int, error) {
+	return t.count("CountMembers", `SELECT COUNT(*) FROM vote WHERE eid = ?`, eid)
+}
+
+func (t *sqlStorageTx) votes(op string, query string, args ...interface{}) ([]Vote, error) {
+	if rows, err := t.query(query, args...); nil != err {
+		return nil, fmt.Errorf("%s failed: %w", op, err)
+	} else {
+		defer rows.Close()
+		var votes []Vote
+		for rows.Next() {
+			var v Vote
+			var rankingJson sql.NullString
+			if err := rows.Scan(&v.Name, &v.Email, &rankingJson); nil != err {
+				return nil, fmt.Errorf("%s scan failed: %w", op, err)
+			} else if v.Ranking, err = parseRanking(op, rankingJson); nil != err {
+				return nil, err
+			}
+			votes = append(votes, v)
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("%s cursor failed: %w", op, err)
+		}
+		return votes, nil
+	}
+}
+
+func (t *sqlStorageTx) Members(eid int64, offset, limit int) ([]Vote, error) {
+	return t.votes("Members", `SELECT "user".name, "user".email, vote.ranking FROM vote LEFT JOIN "user" ON vote.uid = "user".uid WHERE vote.eid = ? ORDER BY vote.uid LIMIT ? OFFSET ?`, eid, limit, offset)
+}
+
+func (t *sqlStorageTx) HasParticipated(eid, uid int64) (bool, error) {
+	return t.exists("HasParticipated", `SELECT 1 FROM participation WHERE eid = ? AND uid = ?`, eid, uid)
+}
+
+func (t *sqlStorageTx) InsertParticipation(eid, uid int64, votedAt int64) error {
+	_, err := t.exec(`INSERT INTO participation (eid, uid, voted_at) VALUES (?, ?, ?)`, eid, uid, votedAt)
+	return t.conflict("InsertParticipation", err)
+}
+
+func (t *sqlStorageTx) CountParticipants(eid int64) (int, error) {
+	return t.count("CountParticipants", `SELECT COUNT(*) FROM participation WHERE eid = ?`, eid)
+}
+
+func (t *sqlStorageTx) Participants(eid int64, offset, limit int) ([]Vote, error) {
+	return t.votes("Participants", `SELECT "user".name, "user".email, NULL FROM participation LEFT JOIN "user" ON participation.uid = "user".uid WHERE participation.eid = ? ORDER BY participation.uid LIMIT ? OFFSET ?`, eid, limit, offset)
+}
+
+func (t *sqlStorageTx) InsertBallot(eid int64, ballot types.Ballot) error {
+	if _, err := t.exec(`INSERT INTO ballot (bid, eid, ranking) VALUES (?, ?, ?)`, ballot.Id, eid, types.JsonMustEncodeString(ballot.Ranking)); nil != err {
+		return fmt.Errorf("InsertBallot failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) TurnoutRecords(eid int64, secret bool) ([]TurnoutRecord, error) {
+	query := `SELECT COALESCE(member_group, ''), listed, ranking IS NOT NULL, voted_at FROM vote WHERE eid = ?`
+	args := []interface{}{eid}
+	if secret {
+		// vote only lists members; participants can be unlisted (ballot codes, open elections)
+		query = `SELECT COALESCE(vote.member_group, ''), vote.listed, participation.uid IS NOT NULL, participation.voted_at FROM vote LEFT JOIN participation ON participation.eid = vote.eid AND participation.uid = vote.uid WHERE vote.eid = ?
+UNION ALL SELECT '', FALSE, TRUE, voted_at FROM participation WHERE eid = ? AND uid NOT IN (SELECT uid FROM vote WHERE eid = ?)`
+		args = []interface{}{eid, eid, eid}
+	}
+	if rows, err := t.query(query, args...); nil != err {
+		return nil, fmt.Errorf("TurnoutRecords failed: %w", err)
+	} else {
+		defer rows.Close()
+		var records []TurnoutRecord
+		for rows.Next() {
+			var r TurnoutRecord
+			var votedAt sql.NullInt64
+			if err := rows.Scan(&r.Group, &r.Listed, &r.Voted, &votedAt); nil != err {
+				return nil, fmt.Errorf("TurnoutRecords scan failed: %w", err)
+			}
+			r.VotedAt = votedAt.Int64
+			records = append(records, r)
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("TurnoutRecords cursor failed: %w", err)
+		}
+		return records, nil
+	}
+}
+
+func (t *sqlStorageTx) Ballots(eid int64, secret bool) ([]types.Ballot, error) {
+	query := `SELECT bid, ranking FROM vote WHERE eid = ? AND bid IS NOT NULL AND ranking IS NOT NULL ORDER BY bid`
+	if secret {
+		query = `SELECT bid, ranking FROM ballot WHERE eid = ? ORDER BY bid`
+	}
+	if rows, err := t.query(query, eid); nil != err {
+		return nil, fmt.Errorf("Ballots failed: %w", err)
+	} else {
+		defer rows.Close()
+		var ballots []types.Ballot
+		for rows.Next() {
+			var bid string
+			var rankingJson sql.NullString
+			if err := rows.Scan(&bid, &rankingJson); nil != err {
+				return nil, fmt.Errorf("Ballots scan failed: %w", err)
+			} else if ranking, err := parseRanking("Ballots", rankingJson); nil != err {
+				return nil, err
+			} else {
+				ballots = append(ballots, types.NewBallot(bid, ranking))
+			}
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("Ballots cursor failed: %w", err)
+		}
+		return ballots, nil
+	}
+}
+
+func (t *sqlStorageTx) SetBallotRanking(eid int64, secret bool, bid string, ranking types.Ranking) error {
+	query := `UPDATE vote SET ranking = ? WHERE eid = ? AND bid = ?`
+	if secret {
+		query = `UPDATE ballot SET ranking = ? WHERE eid = ? AND bid = ?`
+	}
+	if _, err := t.exec(query, types.JsonMustEncodeString(ranking), eid, bid); nil != err {
+		return fmt.Errorf("SetBallotRanking failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) FlagReview(eid int64, secret bool) error {
+	query := `UPDATE vote SET review = ? WHERE eid = ? AND ranking IS NOT NULL`
+	if secret {
+		query = `UPDATE participation SET review = ? WHERE eid = ?`
+	}
+	if _, err := t.exec(query, true, eid); nil != err {
+		return fmt.Errorf("FlagReview failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) NeedsReview(eid, uid int64, secret bool) (bool, error) {
+	query := `SELECT 1 FROM vote WHERE eid = ? AND uid = ? AND review`
+	if secret {
+		query = `SELECT 1 FROM participation WHERE eid = ? AND uid = ? AND review`
+	}
+	return t.exists("NeedsReview", query, eid, uid)
+}
+
+func (t *sqlStorageTx) Tally(eid int64, numCandidates int) (types.PairwisePreferences, error) {
+	if rows, err := t.query(`SELECT runner, opponent, wins FROM tally WHERE eid = ?`, eid); nil != err {
+		return nil, fmt.Errorf("Tally failed: %w", err)
+	} else {
+		defer rows.Close()
+		prefs := types.PairwisePreferences(types.NewPairwise(numCandidates))
+		found := false
+		for rows.Next() {
+			var runner, opponent, wins int
+			if err := rows.Scan(&runner, &opponent, &wins); nil != err {
+				return nil, fmt.Errorf("Tally scan failed: %w", err)
+			} else if runner < 0 || runner >= numCandidates || opponent < 0 || opponent >= numCandidates {
+				return nil, fmt.Errorf("Tally: entry (%d, %d) out of range for %d candidates", runner, opponent, numCandidates)
+			}
+			prefs[runner][opponent] = wins
+			found = true
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("Tally cursor failed: %w", err)
+		} else if !found {
+			return nil, errStorageNotFound
+		}
+		return prefs, nil
+	}
+}
+
+func (t *sqlStorageTx) AddTally(eid int64, delta types.PairwisePreferences) error {
+	for runner, line := range delta {
+		for opponent, wins := range line {
+			if 0 == wins {
+				continue
+			}
+			if _, err := t.exec(`UPDATE tally SET wins = wins + ? WHERE eid = ? AND runner = ? AND opponent = ?`, wins, eid, runner, opponent); nil != err {
+				return fmt.Errorf("AddTally failed: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// stores all entries (including zeroes), so an initialised tally is never empty
+func (t *sqlStorageTx) SetTally(eid int64, prefs types.PairwisePreferences) error {
+	if _, err := t.exec(`DELETE FROM tally WHERE eid = ?`, eid); nil != err {
+		return fmt.Errorf("SetTally failed: %w", err)
+	}
+	for runner, line := range prefs {
+		for opponent, wins := range line {
+			if _, err := t.exec(`INSERT INTO tally (eid, runner, opponent, wins) VALUES (?, ?, ?, ?)`, eid, runner, opponent, wins); nil != err {
+				return fmt.Errorf("SetTally failed: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) InitTally(eid int64, prefs types.PairwisePreferences) error {
+	for runner, line := range prefs {
+		for opponent, wins := range line {
+			if _, err := t.exec(`INSERT INTO tally (eid, runner, opponent, wins) VALUES (?, ?, ?, ?) ON CONFLICT (eid, runner, opponent) DO NOTHING`, eid, runner, opponent, wins); nil != err {
+				return fmt.Errorf("InitTally failed: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) LockElection(eid int64) error {
+	if "" != t.dialect.lockElection {
+		if _, err := t.exec(t.dialect.lockElection, eid); nil != err {
+			return fmt.Errorf("LockElection failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) FrozenResults(eid int64) (types.PairwisePreferences, error) {
+	var prefsJson string
+	var prefs types.PairwisePreferences
+	if err := t.queryRow(`SELECT preferences FROM result WHERE eid = ?`, eid).Scan(&prefsJson); sql.ErrNoRows == err {
+		return nil, errStorageNotFound
+	} else if nil != err {
+		return nil, fmt.Errorf("FrozenResults failed: %w", err)
+	} else if err := json.Unmarshal([]byte(prefsJson), &prefs); nil != err {
+		return nil, fmt.Errorf("FrozenResults parse preferences (%+q) failed: %w", prefsJson, err)
+	}
+	return prefs, nil
+}
+
+func (t *sqlStorageTx) FreezeResults(eid int64, time int64, prefs types.PairwisePreferences) error {
+	if _, err := t.exec(`INSERT INTO result (eid, time, preferences) VALUES (?, ?, ?) ON CONFLICT (eid) DO UPDATE SET time = excluded.time, preferences = excluded.preferences`, eid, time, types.JsonMustEncodeString(prefs)); nil != err {
+		return fmt.Errorf("FreezeResults failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) DeleteFrozenResults(eid int64) error {
+	if _, err := t.exec(`DELETE FROM result WHERE eid = ?`, eid); nil != err {
+		return fmt.Errorf("DeleteFrozenResults failed: %w", err)
+	}
+	return nil
+}
+
+const auditColumns = `seq, time, action, eid, uid, data, hash`
+
+func scanAuditEntry(row rowScanner) (*AuditEntry, error) {
+	var a AuditEntry
+	if err := row.Scan(&a.Seq, &a.Time, &a.Action, &a.Eid, &a.Uid, &a.Data, &a.Hash); nil != err {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (t *sqlStorageTx) LastAuditEntry() (*AuditEntry, error) {
+	if "" != t.dialect.lockAudit {
+		if _, err := t.exec(t.dialect.lockAudit); nil != err {
+			return nil, fmt.Errorf("LastAuditEntry lock failed: %w", err)
+		}
+	}
+	if a, err := scanAuditEntry(t.queryRow(`SELECT ` + auditColumns + ` FROM audit ORDER BY seq DESC LIMIT 1`)); sql.ErrNoRows == err {
+		return nil, nil
+	} else if nil != err {
+		return nil, fmt.Errorf("LastAuditEntry failed: %w", err)
+	} else {
+		return a, nil
+	}
+}
+
+func (t *sqlStorageTx) AppendAuditEntry(a AuditEntry) error {
+	if _, err := t.exec(`INSERT INTO audit (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`, a.Seq, a.Time, a.Action, a.Eid, a.Uid, a.Data, a.Hash); nil != err {
+		return fmt.Errorf("AppendAuditEntry failed: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlStorageTx) AuditLog() ([]AuditEntry, error) {
+	if rows, err := t.query(`SELECT ` + auditColumns + ` FROM audit ORDER BY seq`); nil != err {
+		return nil, fmt.Errorf("AuditLog failed: %w", err)
+	} else {
+		defer rows.Close()
+		var entries []AuditEntry
+		for rows.Next() {
+			if a, err := scanAuditEntry(rows); nil != err {
+				return nil, fmt.Errorf("AuditLog scan failed: %w", err)
+			} else {
+				entries = append(entries, *a)
+			}
+		}
+		if err := rows.Err(); nil != err {
+			return nil, fmt.Errorf("AuditLog cursor failed: %w", err)
+		}
+		return entries, nil
+	}
+}
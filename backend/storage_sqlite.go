@@ -0,0 +1,236 @@
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+)
+
+var sqliteDialect = sqlDialect{
+	name: "sqlite",
+	setup: []string{
+		`PRAGMA foreign_keys = ON`,
+	},
+	migrations: []Migration{{
+		Version:     1,
+		Description: "initial schema",
+		statements: []string{`
+CREATE TABLE IF NOT EXISTS user (
+	uid INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	email TEXT UNIQUE,
+	token TEXT UNIQUE,
+	siteadmin BOOLEAN NOT NULL DEFAULT 0
+)`, `
+CREATE UNIQUE INDEX IF NOT EXISTS user_unique_unregistered ON user (name) WHERE email IS NULL`, `
+CREATE TABLE IF NOT EXISTS election (
+	eid INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	title TEXT NOT NULL DEFAULT '',
+	candidates TEXT NOT NULL,
+	closed BOOLEAN NOT NULL DEFAULT 0,
+	public BOOLEAN NOT NULL DEFAULT 0,
+	open BOOLEAN NOT NULL DEFAULT 0,
+	editopen BOOLEAN NOT NULL DEFAULT 0
+)`, `
+CREATE TABLE IF NOT EXISTS vote (
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid INTEGER NOT NULL REFERENCES user ON DELETE RESTRICT ON UPDATE CASCADE,
+	ranking TEXT NOT NULL,
+	UNIQUE (eid, uid)
+)`,
+		},
+	}, {
+		Version:     2,
+		Description: "ballot codes, secret ballots, receipts, audit log, schedules and results policy",
+		statements: []string{
+			`ALTER TABLE election ADD COLUMN secret BOOLEAN NOT NULL DEFAULT 0`,
+			`ALTER TABLE election ADD COLUMN opens_at INTEGER`,
+			`ALTER TABLE election ADD COLUMN closes_at INTEGER`,
+			`ALTER TABLE election ADD COLUMN started BOOLEAN NOT NULL DEFAULT 0`,
+			`ALTER TABLE election ADD COLUMN results TEXT NOT NULL DEFAULT 'always'`,
+			// sqlite can't drop NOT NULL: rebuild vote table
+			`
+CREATE TABLE vote_new (
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid INTEGER NOT NULL REFERENCES user ON DELETE RESTRICT ON UPDATE CASCADE,
+	ranking TEXT,
+	bid TEXT UNIQUE,
+	UNIQUE (eid, uid)
+)`,
+			// existing votes need ballot ids to be counted
+			`INSERT INTO vote_new (eid, uid, ranking, bid) SELECT eid, uid, ranking, lower(hex(randomblob(16))) FROM vote`,
+			`DROP TABLE vote`,
+			`ALTER TABLE vote_new RENAME TO vote`,
+			`
+CREATE TABLE ballotcode (
+	code TEXT PRIMARY KEY,
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid INTEGER UNIQUE REFERENCES user ON DELETE RESTRICT ON UPDATE CASCADE
+)`,
+			// secret elections: who voted
+			`
+CREATE TABLE participation (
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid INTEGER NOT NULL REFERENCES user ON DELETE RESTRICT ON UPDATE CASCADE,
+	UNIQUE (eid, uid)
+)`,
+			// secret elections: anonymous ballots. no rowid: must not reveal insertion order.
+			// pages and journal are still written along with the participation
+			// row: whoever can inspect the database file can link them
+			`
+CREATE TABLE ballot (
+	bid TEXT PRIMARY KEY,
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	ranking TEXT NOT NULL
+) WITHOUT ROWID`,
+			// no foreign keys: entries must never be modified or removed
+			`
+CREATE TABLE audit (
+	seq INTEGER PRIMARY KEY,
+	time INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	eid INTEGER NOT NULL,
+	uid INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	hash TEXT NOT NULL
+)`,
+			// results frozen when an election closed
+			`
+CREATE TABLE result (
+	eid INTEGER PRIMARY KEY REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	time INTEGER NOT NULL,
+	preferences TEXT NOT NULL
+)`,
+		},
+	}, {
+		Version:     3,
+		Description: "pairwise tallies",
+		statements: []string{
+			// filled from the ballots when first needed
+			`
+CREATE TABLE tally (
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	runner INTEGER NOT NULL,
+	opponent INTEGER NOT NULL,
+	wins INTEGER NOT NULL,
+	PRIMARY KEY (eid, runner, opponent)
+)`,
+		},
+	}, {
+		Version:     4,
+		Description: "vote timestamps and member groups",
+		statements: []string{
+			// NULL for votes cast before timestamps were recorded
+			`ALTER TABLE vote ADD COLUMN voted_at INTEGER`,
+			// members (eligible voters) as opposed to users who only voted
+			`ALTER TABLE vote ADD COLUMN listed BOOLEAN NOT NULL DEFAULT 0`,
+			`ALTER TABLE vote ADD COLUMN member_group TEXT`,
+			`UPDATE vote SET listed = 1 WHERE ranking IS NULL OR (eid IN (SELECT eid FROM election WHERE NOT open) AND uid NOT IN (SELECT uid FROM ballotcode WHERE uid IS NOT NULL))`,
+			`ALTER TABLE participation ADD COLUMN voted_at INTEGER`,
+		},
+	}, {
+		Version:     5,
+		Description: "candidate records",
+		statements: []string{
+			// position: index of the candidate in rankings and results
+			`
+CREATE TABLE candidate (
+	cid INTEGER PRIMARY KEY AUTOINCREMENT,
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	position INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	image TEXT NOT NULL DEFAULT '',
+	UNIQUE (eid, position)
+)`,
+			`INSERT INTO candidate (eid, position, name) SELECT election.eid, json_each.key, json_each.value FROM election, json_each(election.candidates) ORDER BY election.eid, json_each.key`,
+			`ALTER TABLE election DROP COLUMN candidates`,
+		},
+	}, {
+		Version:     6,
+		Description: "nominations",
+		statements: []string{
+			`ALTER TABLE election ADD COLUMN nominating BOOLEAN NOT NULL DEFAULT 0`,
+			`ALTER TABLE election ADD COLUMN nomination_seconds INTEGER NOT NULL DEFAULT 0`,
+			`
+CREATE TABLE nomination (
+	nid INTEGER PRIMARY KEY AUTOINCREMENT,
+	eid INTEGER NOT NULL REFERENCES election ON DELETE CASCADE ON UPDATE CASCADE,
+	uid INTEGER NOT NULL REFERENCES "user" ON DELETE RESTRICT ON UPDATE CASCADE,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	image TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	created INTEGER NOT NULL
+)`, `
+CREATE TABLE nomination_second (
+	nid INTEGER NOT NULL REFERENCES nomination ON DELETE CASCADE ON UPDATE CASCADE,
+	uid INTEGER NOT NULL REFERENCES "user" ON DELETE RESTRICT ON UPDATE CASCADE,
+	PRIMARY KEY (nid, uid)
+)`,
+		},
+	}, {
+		Version:     7,
+		Description: "ballot review after candidate changes",
+		statements: []string{
+			`ALTER TABLE vote ADD COLUMN review BOOLEAN NOT NULL DEFAULT 0`,
+			`ALTER TABLE participation ADD COLUMN review BOOLEAN NOT NULL DEFAULT 0`,
+		},
+	}, {
+		Version:     8,
+		Description: "partial ballots",
+		statements: []string{
+			`ALTER TABLE election ADD COLUMN unranked TEXT NOT NULL DEFAULT 'last'`,
+		},
+	}, {
+		Version:     9,
+		Description: "reopen nominations (RON) candidate",
+		statements: []string{
+			`ALTER TABLE candidate ADD COLUMN reserved BOOLEAN NOT NULL DEFAULT 0`,
+		},
+	}},
+	legacyVersion: func(tx *sql.Tx) (int, error) {
+		var tables, columns int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'election'`).Scan(&tables); nil != err {
+			return 0, err
+		} else if 0 == tables {
+			return 0, nil
+		} else if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('election') WHERE name = 'results'`).Scan(&columns); nil != err {
+			return 0, err
+		} else if 0 == columns {
+			return 1, nil
+		} else {
+			return 2, nil
+		}
+	},
+	isConflict: func(err error) bool {
+		if e, ok := err.(sqlite3.Error); ok {
+			return sqlite3.ErrConstraintUnique == e.ExtendedCode || sqlite3.ErrConstraintPrimaryKey == e.ExtendedCode
+		}
+		return false
+	},
+	isRetryable: func(err error) bool {
+		var e sqlite3.Error
+		if errors.As(err, &e) {
+			return sqlite3.ErrBusy == e.Code || sqlite3.ErrLocked == e.Code
+		}
+		return false
+	},
+}
+
+// db must use the "sqlite3" driver
+func ConnectDatabase(db *sql.DB) (ElectionsDb, error) {
+	if storage, err := newSqlStorage(db, &sqliteDialect); nil != err {
+		return ElectionsDb{}, err
+	} else {
+		return NewElectionsDb(storage), nil
+	}
+}
+
+// db must use the "sqlite3" driver
+func MigrateDatabase(db *sql.DB, dryRun bool) ([]Migration, error) {
+	return migrateDatabase(db, &sqliteDialect, dryRun)
+}
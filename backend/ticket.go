@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+/* EventSource can't set headers, and tokens or ballot codes in the URL end
+ * up in access logs. clients get a short-lived ticket with
+ * POST /api/v1/tickets instead and pass it as "ticket" query parameter to
+ * the event streams. the ticket is only needed to connect (and reconnect
+ * while it is valid); tickets are only known to this process (like the
+ * event hub).
+ */
+
+const ticketLifetime = time.Minute
+const ticketLength = 16 // random bytes
+
+var ErrorInvalidTicket = newError("invalid_ticket", "Invalid or expired ticket")
+
+type ticket struct {
+	auth    auth
+	expires time.Time
+}
+
+type ticketStore struct {
+	mutex   sync.Mutex
+	tickets map[string]ticket
+}
+
+func newTicketStore() *ticketStore {
+	return &ticketStore{tickets: make(map[string]ticket)}
+}
+
+func (s *ticketStore) issue(a auth, now time.Time) (string, time.Time, error) {
+	b, err := randomBytes(ticketLength)
+	if nil != err {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(b)
+	expires := now.Add(ticketLifetime)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, t := range s.tickets {
+		if !now.Before(t.expires) {
+			delete(s.tickets, id)
+		}
+	}
+	s.tickets[id] = ticket{auth: a, expires: expires}
+	return id, expires, nil
+}
+
+func (s *ticketStore) find(id string, now time.Time) (auth, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t, ok := s.tickets[id]; ok && now.Before(t.expires) {
+		return t.auth, true
+	}
+	return auth{}, false
+}
+
+// a needs to be a valid token or ballot code
+func (edb ElectionsDb) apiTicket(a auth) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if nil == user {
+		return nil, ErrorInvalidAuthorization
+	}
+	if id, expires, err := edb.tickets.issue(a, edb.now()); nil != err {
+		return nil, internalError(err)
+	} else {
+		return map[string]interface{}{
+			"ticket":  id,
+			"expires": expires.Unix(),
+		}, nil
+	}
+}
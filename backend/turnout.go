@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"sort"
+)
+
+/* turnout: how many of the eligible voters (listed members and issued
+ * ballot codes) cast a ballot, by member group and over time. never
+ * includes anything about the rankings, so it can be shown while results
+ * are hidden.
+ */
+
+var ErrorTurnoutNotVisible = newError("turnout_not_visible", "Turnout is only visible to election managers")
+
+// at most this many timeline points
+const turnoutMaxPoints = 100
+
+// timeline intervals in seconds
+var turnoutIntervals = []int64{60, 5 * 60, 15 * 60, 60 * 60, 6 * 60 * 60, 24 * 60 * 60, 7 * 24 * 60 * 60}
+
+// a member or voter of an election
+type TurnoutRecord struct {
+	Group   string
+	Listed  bool // member (eligible voter)
+	Voted   bool
+	VotedAt int64 // unix timestamp; 0 if unknown (votes from older versions)
+}
+
+type TurnoutGroup struct {
+	Group    string `json:"group"` // "" for members without group, ballot codes and unlisted voters
+	Eligible int    `json:"eligible"`
+	Voted    int    `json:"voted"`
+}
+
+type TurnoutPoint struct {
+	Time  int64 `json:"time"`  // start of the interval (unix timestamp)
+	Voted int   `json:"voted"` // ballots cast until the end of the interval
+}
+
+type Turnout struct {
+	// anybody can vote; eligible only counts members and ballot codes
+	Open     bool           `json:"open"`
+	Eligible int            `json:"eligible"`
+	Voted    int            `json:"voted"`
+	Groups   []TurnoutGroup `json:"groups"`
+	Interval int64          `json:"interval"` // seconds between timeline points
+	// votes without timestamp are included from the first point on
+	Timeline []TurnoutPoint `json:"timeline"`
+}
+
+func (etx *ElectionsTx) CanSeeTurnout(user *User, e *Election) bool {
+	return etx.IsElectionManager(user, e)
+}
+
+func (etx *ElectionsTx) ElectionTurnout(e *Election) (*Turnout, error) {
+	records, err := etx.st.TurnoutRecords(e.Eid, e.Secret)
+	if nil != err {
+		return nil, internalError(err)
+	}
+	ballotCodes, err := etx.st.CountBallotCodes(e.Eid)
+	if nil != err {
+		return nil, internalError(err)
+	}
+
+	t := &Turnout{Open: e.Public && e.Open, Eligible: ballotCodes}
+	groups := map[string]*TurnoutGroup{"": {Eligible: ballotCodes}}
+	untimed := 0
+	var times []int64
+	for _, r := range records {
+		g := groups[r.Group]
+		if nil == g {
+			g = &TurnoutGroup{Group: r.Group}
+			groups[r.Group] = g
+		}
+		if r.Listed {
+			t.Eligible++
+			g.Eligible++
+		}
+		if r.Voted {
+			t.Voted++
+			g.Voted++
+			if 0 == r.VotedAt {
+				untimed++
+			} else {
+				times = append(times, r.VotedAt)
+			}
+		}
+	}
+	for _, g := range groups {
+		if 0 != g.Eligible || 0 != g.Voted {
+			t.Groups = append(t.Groups, *g)
+		}
+	}
+	sort.Slice(t.Groups, func(i, j int) bool {
+		return t.Groups[i].Group < t.Groups[j].Group
+	})
+	t.Interval, t.Timeline = turnoutTimeline(times, untimed)
+	return t, nil
+}
+
+// cumulative votes per interval; the interval is chosen to keep the
+// number of points below turnoutMaxPoints
+func turnoutTimeline(times []int64, untimed int) (int64, []TurnoutPoint) {
+	if 0 == len(times) {
+		return turnoutIntervals[0], nil
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	first, last := times[0], times[len(times)-1]
+	var interval int64
+	for _, interval = range turnoutIntervals {
+		if (last/interval)-(first/interval) < turnoutMaxPoints {
+			break
+		}
+	}
+
+	var timeline []TurnoutPoint
+	voted := untimed
+	for start := first / interval * interval; start <= last; start += interval {
+		for 0 != len(times) && times[0] < start+interval {
+			voted++
+			times = times[1:]
+		}
+		timeline = append(timeline, TurnoutPoint{Time: start, Voted: voted})
+	}
+	return interval, timeline
+}
+
+func (edb ElectionsDb) apiTurnout(election string, a auth) (interface{}, error) {
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	defer etx.Rollback()
+
+	if user, err := etx.findAuth(a); nil != err {
+		return nil, err
+	} else if e, err := etx.FindElectionByName(election, user); nil != err {
+		return nil, err
+	} else if !etx.CanSeeTurnout(user, e) {
+		return nil, ErrorTurnoutNotVisible
+	} else {
+		return etx.ElectionTurnout(e)
+	}
+}
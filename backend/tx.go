@@ -2,42 +2,67 @@ package backend
 
 import (
 	"database/sql"
-	"encoding/json"
-	"errors"
+	"encoding/hex"
 	"fmt"
 	"github.com/stbuehler/go-vote/types"
-	"log"
+	"strings"
+	"time"
 )
 
-var ErrorUserNotFound = errors.New("User not found")
-var ErrorInvalidUsername = errors.New("Invalid username")
-var ErrorInvalidRanking = errors.New("Invalid ranking")
-var ErrorElectionNotFound = errors.New("Election not found")
-var ErrorElectionMembersOnly = errors.New("Only listed members can vote")
-var ErrorElectionMembersOnlyEdit = errors.New("Only listed members can edit vote")
-var ErrorElectionClosed = errors.New("Voting is closed")
+var ErrorUserNotFound = newFieldError("user_not_found", "auth.token", "User not found")
+var ErrorInvalidUsername = newFieldError("invalid_username", "auth.name", "Invalid username")
+var ErrorInvalidRanking = newFieldError("invalid_ranking", "rankgroups", "Invalid ranking")
+var ErrorElectionNotFound = newError("election_not_found", "Election not found")
+var ErrorElectionMembersOnly = newError("members_only", "Only listed members can vote")
+var ErrorElectionMembersOnlyEdit = newError("members_only_edit", "Only listed members can edit vote")
+var ErrorElectionClosed = newError("election_closed", "Voting is closed")
+var ErrorElectionNotOpenYet = newError("election_not_open", "Voting has not started yet")
+var ErrorInvalidBallotCode = newFieldError("invalid_ballot_code", "auth.code", "Invalid ballot code")
+var ErrorBallotCodeUsed = newFieldError("ballot_code_used", "auth.code", "Ballot code was already used")
+var ErrorInvalidSchedule = newError("invalid_schedule", "Election must open before it closes")
+var ErrorAlreadyVoted = newError("already_voted", "Already voted; secret ballots can't be changed")
+var ErrorElectionExists = newError("election_exists", "Election name already in use")
+var ErrorUserExists = newError("user_exists", "User email or token already in use")
 
 type ElectionsTx struct {
-	tx *sql.Tx
+	st       StorageTx
+	now      func() time.Time
+	features Features
+	notify   func(e *Election, event ElectionEvent)
+	events   []pendingEvent // sent after commit
+}
+
+type pendingEvent struct {
+	election *Election
+	event    ElectionEvent
 }
 
 type User struct {
-	Uid       int64
-	Name      string
-	Email     sql.NullString
-	Token     sql.NullString
-	SiteAdmin bool
+	Uid           int64
+	Name          string
+	Email         sql.NullString
+	Token         sql.NullString
+	SiteAdmin     bool
+	BallotCodeEid int64 // election the user holds a ballot code for (0 if none)
 }
 
 type Election struct {
-	Eid        int64
-	Name       string // unique name identifier
-	Title      string
-	Candidates []string
-	Closed     bool // whether election is closed
-	Public     bool // whether unregistered/anonymous users can see election
-	Open       bool // whether unregistered users can vote
-	EditOpen   bool // whether votes from unregistered users can be edited
+	Eid               int64
+	Name              string // unique name identifier
+	Title             string
+	Candidates        []Candidate
+	Closed            bool      // whether election is closed
+	Public            bool      // whether unregistered/anonymous users can see election
+	Open              bool      // whether unregistered users can vote
+	EditOpen          bool      // whether votes from unregistered users can be edited
+	Secret            bool      // whether ballots are stored unlinked from voters (can't be edited; see secretBallot)
+	OpensAt           time.Time // voting not possible before (zero: no schedule)
+	ClosesAt          time.Time // election gets closed at this time (zero: no schedule)
+	Started           bool      // whether the scheduler announced the opening
+	Results           ResultsPolicy
+	Unranked          UnrankedPolicy // how partial ballots count
+	Nominating        bool           // nomination phase: members propose candidates, no voting yet
+	NominationSeconds int            // seconds a nomination needs before it can be approved
 }
 
 type Vote struct {
@@ -46,47 +71,70 @@ type Vote struct {
 	Ranking types.Ranking
 }
 
-func scanUser(row *sql.Row) (*User, error) {
-	var user User
-	if err := row.Scan(&user.Uid, &user.Name, &user.Email, &user.Token, &user.SiteAdmin); nil != err {
-		return nil, err
-	} else {
-		if !user.Email.Valid || !user.Token.Valid {
-			user.SiteAdmin = false
-			user.Email = sql.NullString{}
-			user.Token = sql.NullString{}
-		}
-		return &user, nil
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func unixTime(t sql.NullInt64) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return time.Unix(t.Int64, 0)
+}
+
+func nullUnixTime(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
 	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
 }
 
 func (etx *ElectionsTx) Rollback() error {
-	if nil == etx.tx {
+	if nil == etx.st {
 		return nil
 	} else {
-		tx := etx.tx
-		etx.tx = nil
-		return tx.Rollback()
+		st := etx.st
+		etx.st = nil
+		return st.Rollback()
 	}
 }
 
 func (etx *ElectionsTx) Commit() error {
-	if nil == etx.tx {
+	if nil == etx.st {
 		return nil
-	} else {
-		tx := etx.tx
-		etx.tx = nil
-		return tx.Commit()
 	}
+	st := etx.st
+	etx.st = nil
+	if err := st.Commit(); nil != err {
+		return err
+	}
+	for _, ev := range etx.events {
+		etx.notify(ev.election, ev.event)
+	}
+	etx.events = nil
+	return nil
+}
+
+// sends the notification once the transaction committed
+func (etx *ElectionsTx) notifyAfterCommit(e *Election, event ElectionEvent) {
+	etx.events = append(etx.events, pendingEvent{e, event})
 }
 
 func (etx *ElectionsTx) FindUserByToken(token string) (*User, error) {
-	row := etx.tx.QueryRow("SELECT uid, name, email, token, siteadmin FROM user WHERE token = ?", token)
-	if user, err := scanUser(row); sql.ErrNoRows == err {
+	if user, err := etx.st.UserByToken(token); errStorageNotFound == err {
 		return nil, ErrorUserNotFound
 	} else if nil != err {
-		log.Fatalf("FindUserByToken failed: %v", err)
+		return nil, internalError(err)
+	} else {
+		return user, nil
+	}
+}
+
+func (etx *ElectionsTx) FindUserByEmail(email string) (*User, error) {
+	if user, err := etx.st.UserByEmail(email); errStorageNotFound == err {
 		return nil, ErrorUserNotFound
+	} else if nil != err {
+		return nil, internalError(err)
 	} else {
 		return user, nil
 	}
@@ -96,154 +144,302 @@ func (etx *ElectionsTx) FindOrCreateUnregisteredUser(name string) (*User, error)
 	if 0 == len(name) {
 		return nil, ErrorInvalidUsername
 	}
-	row := etx.tx.QueryRow("SELECT uid, name, email, token, siteadmin FROM user WHERE name = ? AND email IS NULL", name)
-	if user, err := scanUser(row); sql.ErrNoRows == err {
-		etx.tx.Exec("INSERT INTO user (name) VALUES (?)", name)
-		row := etx.tx.QueryRow("SELECT uid, name, email, token, siteadmin FROM user WHERE name = ? AND email IS NULL", name)
-		if user, err := scanUser(row); nil != err {
-			log.Fatalf("Couldn't add unregistered user %+q: %v", name, err)
+	if user, err := etx.st.UnregisteredUserByName(name); errStorageNotFound == err {
+		if uid, err := etx.st.CreateUser(&User{Name: name}); errStorageConflict == err {
+			// name is taken by a ballot code user
 			return nil, ErrorInvalidUsername
+		} else if nil != err {
+			return nil, internalError(err)
+		} else if user, err := etx.st.UserByUid(uid); nil != err {
+			return nil, internalError(err)
 		} else {
 			return user, nil
 		}
 	} else if nil != err {
-		log.Fatalf("FindOrCreateUnregisteredUser failed: %v", err)
-		return nil, ErrorInvalidUsername
+		return nil, internalError(err)
 	} else {
 		return user, nil
 	}
 }
 
-func scanElection(row *sql.Row) (*Election, error) {
-	var e Election
-	var candidatesJson string
-	if err := row.Scan(&e.Eid, &e.Name, &e.Title, &candidatesJson, &e.Closed, &e.Public, &e.Open, &e.EditOpen); nil != err {
-		return nil, err
-	} else if err := json.Unmarshal([]byte(candidatesJson), &e.Candidates); nil != err {
-		return nil, err
+// registered users need email and token
+func (etx *ElectionsTx) CreateUser(user *User, actor *User) error {
+	if 0 == len(user.Name) {
+		return ErrorInvalidUsername
+	} else if user.Email.Valid && !strings.Contains(user.Email.String, "@") {
+		return ErrorInvalidEmail
+	}
+	if uid, err := etx.st.CreateUser(user); errStorageConflict == err {
+		return ErrorUserExists
+	} else if nil != err {
+		return internalError(err)
 	} else {
-		return &e, nil
+		user.Uid = uid
 	}
+	return etx.audit(AuditUserCreated, nil, actor, map[string]interface{}{
+		"uid":       user.Uid,
+		"name":      user.Name,
+		"email":     user.Email.String,
+		"siteadmin": user.SiteAdmin,
+	})
 }
 
-func (etx *ElectionsTx) CanSeeElection(user *User, e *Election) bool {
+func (etx *ElectionsTx) CanSeeElection(user *User, e *Election) (bool, error) {
 	if e.Public {
-		return true
+		return true, nil
 	}
 	if nil == user {
-		return false
+		return false, nil
 	}
-	if user.SiteAdmin {
-		return true
+	if user.SiteAdmin || user.BallotCodeEid == e.Eid {
+		return true, nil
 	}
-	var uid int64
-	err := etx.tx.QueryRow("SELECT vote.uid FROM vote WHERE eid = ? AND uid = ?", e.Eid, user.Uid).Scan(&uid)
-	return nil == err
+	return etx.isMember(user, e)
 }
 
-func (etx *ElectionsTx) FindElectionByName(name string, user *User) *Election {
-	row := etx.tx.QueryRow("SELECT eid, name, title, candidates, closed, public, open, editopen FROM election WHERE name = ?", name)
-	if e, err := scanElection(row); sql.ErrNoRows == err {
-		return nil
+// all elections, without checking whether anyone can see them
+func (etx *ElectionsTx) Elections() ([]*Election, error) {
+	if elections, err := etx.st.Elections(); nil != err {
+		return nil, internalError(err)
+	} else {
+		return elections, nil
+	}
+}
+
+// find election without checking whether anyone can see it
+func (etx *ElectionsTx) ElectionByName(name string) (*Election, error) {
+	if e, err := etx.st.ElectionByName(name); errStorageNotFound == err {
+		return nil, ErrorElectionNotFound
 	} else if nil != err {
-		log.Fatalf("FindElectionByName failed: %v", err)
-		return nil
-	} else if !etx.CanSeeElection(user, e) {
-		return nil
+		return nil, internalError(err)
+	} else {
+		return e, nil
+	}
+}
+
+// ErrorElectionNotFound if user can't see the election
+func (etx *ElectionsTx) FindElectionByName(name string, user *User) (*Election, error) {
+	if e, err := etx.ElectionByName(name); nil != err {
+		return nil, err
+	} else if visible, err := etx.CanSeeElection(user, e); nil != err {
+		return nil, err
+	} else if !visible {
+		return nil, ErrorElectionNotFound
+	} else {
+		return e, nil
+	}
+}
+
+func (etx *ElectionsTx) CreateElection(e *Election, actor *User) error {
+	if !validElectionName(e.Name) {
+		return ErrorInvalidElectionName
+	} else if err := checkCandidates(e.Candidates, minimumCandidates(e)); nil != err {
+		return err
+	} else if e.NominationSeconds < 0 {
+		return ErrorInvalidNominationSeconds
+	}
+	if 0 == len(e.Results) {
+		e.Results = ResultsAlways
+	}
+	if !e.Results.Valid() {
+		return ErrorInvalidResultsPolicy
+	}
+	if 0 == len(e.Unranked) {
+		e.Unranked = UnrankedLast
+	}
+	if !e.Unranked.Valid() {
+		return ErrorInvalidUnrankedPolicy
+	}
+	if eid, err := etx.st.CreateElection(e); errStorageConflict == err {
+		return ErrorElectionExists
+	} else if nil != err {
+		return internalError(err)
 	} else {
-		return e
+		e.Eid = eid
+	}
+	if err := etx.setCandidates(e, e.Candidates); nil != err {
+		return err
 	}
+	return etx.audit(AuditElectionCreated, e, actor, e)
 }
 
-func (etx *ElectionsTx) ElectionVotes(eid int64, offset, limit int) (int, []Vote, error) {
-	var count int
-	if err := etx.tx.QueryRow("SELECT COUNT(*) FROM vote WHERE eid = ?", eid).Scan(&count); nil != err {
-		return 0, nil, fmt.Errorf("ElectionVotes count failed: %v", err)
+// group is only used for turnout statistics and can be empty
+func (etx *ElectionsTx) AddElectionMember(e *Election, user *User, group string, actor *User) error {
+	if err := etx.st.AddMember(e.Eid, user.Uid, group); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditMemberAdded, e, actor, map[string]interface{}{"uid": user.Uid, "group": group})
+}
+
+// in secret elections rankings are not linked to voters and therefore
+// always empty
+func (etx *ElectionsTx) ElectionVotes(e *Election, offset, limit int) (int, []Vote, error) {
+	count, err := etx.st.CountMembers(e.Eid)
+	if e.Secret {
+		count, err = etx.st.CountParticipants(e.Eid)
+	}
+	if nil != err {
+		return 0, nil, internalError(err)
 	} else if offset >= count {
 		return 0, nil, nil
 	} else if limit > count-offset {
 		limit = count - offset
 	}
-	if rows, err := etx.tx.Query("SELECT user.name, user.email, vote.ranking FROM vote LEFT JOIN user ON vote.uid = user.uid WHERE vote.eid = ? ORDER BY vote.uid LIMIT ? OFFSET ?", eid, limit, offset); nil != err {
-		return 0, nil, fmt.Errorf("ElectionVotes failed: %v", err)
+	var votes []Vote
+	if e.Secret {
+		votes, err = etx.st.Participants(e.Eid, offset, limit)
 	} else {
-		defer rows.Close()
-		votes := make([]Vote, 0, limit)
-		for rows.Next() {
-			var v Vote
-			var rankingJson sql.NullString
-			if err := rows.Scan(&v.Name, &v.Email, &rankingJson); nil != err {
-				return 0, nil, fmt.Errorf("ElectionVotes scan failed: %v", err)
-			} else if rankingJson.Valid {
-				if err := json.Unmarshal([]byte(rankingJson.String), &v.Ranking); nil != err {
-					return 0, nil, fmt.Errorf("ElectionVotes parse ranking (%+q) failed: %v", rankingJson, err)
-				}
-			}
-			votes = append(votes, v)
-		}
-		if err := rows.Err(); nil != err {
-			return 0, nil, fmt.Errorf("ElectionVotes cursor failed: %v", err)
+		votes, err = etx.st.Members(e.Eid, offset, limit)
+	}
+	if nil != err {
+		return 0, nil, internalError(err)
+	}
+	return count, votes, nil
+}
+
+// count all cast ballots
+func (etx *ElectionsTx) countPairwisePreferences(e *Election) (types.PairwisePreferences, error) {
+	ballots, err := etx.st.Ballots(e.Eid, e.Secret)
+	if nil != err {
+		return nil, internalError(err)
+	}
+	numCandidates := len(e.Candidates)
+	table := types.PairwisePreferences(types.NewPairwise(numCandidates))
+	for _, b := range ballots {
+		if len(b.Ranking) != numCandidates {
+			return nil, internalError(fmt.Errorf("countPairwisePreferences: inconsistent ranking lengths: %d != %d", numCandidates, len(b.Ranking)))
 		}
-		return count, votes, nil
+		table.Count(b.Ranking, e.Unranked.abstain())
 	}
+	return table, nil
 }
 
+// the tally is updated with each ballot; it is counted from the ballots
+// only if it wasn't initialised yet (elections from older versions). a
+// concurrent transaction might initialise it too: the first one wins, the
+// stored tally is read again
 func (etx *ElectionsTx) ElectionPairwisePreferences(e *Election) (types.PairwisePreferences, error) {
-	if rows, err := etx.tx.Query("SELECT ranking FROM vote WHERE vote.eid = ?", e.Eid); nil != err {
-		return nil, fmt.Errorf("ElectionPairwisePreferences failed: %v", err)
+	if prefs, err := etx.st.Tally(e.Eid, len(e.Candidates)); errStorageNotFound == err {
+		if prefs, err = etx.countPairwisePreferences(e); nil != err {
+			return nil, err
+		} else if err := etx.st.InitTally(e.Eid, prefs); nil != err {
+			return nil, internalError(err)
+		} else if prefs, err = etx.st.Tally(e.Eid, len(e.Candidates)); nil != err {
+			return nil, internalError(err)
+		}
+		return prefs, nil
+	} else if nil != err {
+		return nil, internalError(err)
 	} else {
-		defer rows.Close()
-		numCandidates := len(e.Candidates)
-		table := types.PairwisePreferences(types.NewPairwise(numCandidates))
-		for rows.Next() {
-			var rankingJson sql.NullString
-			var ranking []int
-			if err := rows.Scan(&rankingJson); nil != err {
-				return nil, fmt.Errorf("ElectionPairwisePreferences scan failed: %v", err)
-			} else if !rankingJson.Valid {
-				continue
-			} else if err := json.Unmarshal([]byte(rankingJson.String), &ranking); nil != err {
-				return nil, fmt.Errorf("ElectionPairwisePreferences parse ranking (%+q) failed: %v", rankingJson.String, err)
-			}
-			if len(table) != numCandidates {
-				return nil, fmt.Errorf("ElectionPairwisePreferences: inconsistent ranking lengths: %d != %d", numCandidates, len(ranking))
-			}
-			// count vote
-			for runner := 0; runner < numCandidates; runner++ {
-				for opponent := 0; opponent < numCandidates; opponent++ {
-					if ranking[runner] < ranking[opponent] {
-						table[runner][opponent]++
-					}
-				}
-			}
+		return prefs, nil
+	}
+}
+
+// compares the tally with the ballots and optionally replaces it with the
+// counted preferences; stored is nil if the tally wasn't initialised
+func (etx *ElectionsTx) CheckElectionTally(e *Election, repair bool) (stored, counted types.PairwisePreferences, err error) {
+	if stored, err = etx.st.Tally(e.Eid, len(e.Candidates)); errStorageNotFound == err {
+		stored = nil
+	} else if nil != err {
+		return nil, nil, internalError(err)
+	}
+	if counted, err = etx.countPairwisePreferences(e); nil != err {
+		return nil, nil, err
+	} else if repair && (nil == stored || !stored.Equal(counted)) {
+		if err := etx.st.SetTally(e.Eid, counted); nil != err {
+			return nil, nil, internalError(err)
 		}
-		if err := rows.Err(); nil != err {
-			return nil, fmt.Errorf("ElectionPairwisePreferences cursor failed: %v", err)
+	}
+	return stored, counted, nil
+}
+
+// add ranking (replacing previous, if not nil) to the tally; needs an
+// initialised tally
+func (etx *ElectionsTx) addToTally(e *Election, ranking, previous types.Ranking) error {
+	delta := types.PairwisePreferences(types.NewPairwise(len(e.Candidates)))
+	delta.Count(ranking, e.Unranked.abstain())
+	if len(previous) == len(e.Candidates) {
+		delta.Uncount(previous, e.Unranked.abstain())
+	}
+	if err := etx.st.AddTally(e.Eid, delta); nil != err {
+		return internalError(err)
+	}
+	return nil
+}
+
+// whether user is listed as member (or voted in a non-secret election)
+func (etx *ElectionsTx) isMember(user *User, e *Election) (bool, error) {
+	if isMember, err := etx.st.IsMember(e.Eid, user.Uid); nil != err {
+		return false, internalError(err)
+	} else {
+		return isMember, nil
+	}
+}
+
+// whether user cast a ballot (members are listed without ranking)
+func (etx *ElectionsTx) hasVoted(user *User, e *Election) (bool, error) {
+	if !e.Secret {
+		if ranking, err := etx.st.VoteRanking(e.Eid, user.Uid); errStorageNotFound == err {
+			return false, nil
+		} else if nil != err {
+			return false, internalError(err)
+		} else {
+			return nil != ranking, nil
 		}
-		return table, nil
+	}
+	if hasVoted, err := etx.st.HasParticipated(e.Eid, user.Uid); nil != err {
+		return false, internalError(err)
+	} else {
+		return hasVoted, nil
 	}
 }
 
 func (etx *ElectionsTx) CanVote(user *User, e *Election) error {
-	var uid int64
 	closedErr := error(nil)
-	if e.Closed {
+	now := etx.now()
+	if etx.IsElectionClosed(e) {
 		// only leak "closed" information if all other checks were successful
 		closedErr = ErrorElectionClosed
+	} else if e.Nominating {
+		closedErr = ErrorElectionNominating
+	} else if !e.OpensAt.IsZero() && now.Before(e.OpensAt) {
+		closedErr = ErrorElectionNotOpenYet
 	}
 	if nil == user {
 		return ErrorElectionNotFound
 	}
+	voted, err := etx.hasVoted(user, e)
+	if nil != err {
+		return err
+	}
+	// secret ballots can't be replaced
+	editErr := closedErr
+	if e.Secret && voted {
+		editErr = ErrorAlreadyVoted
+	}
 	if user.SiteAdmin {
-		return closedErr
+		return editErr
+	}
+	if 0 != user.BallotCodeEid {
+		if user.BallotCodeEid != e.Eid {
+			return ErrorElectionMembersOnly
+		}
+		// ballot codes can only be used once
+		if (e.EditOpen && !e.Secret) || !voted {
+			return closedErr
+		} else {
+			return ErrorBallotCodeUsed
+		}
 	}
 	if e.Public && e.Open {
-		if e.EditOpen || user.Email.Valid {
+		if user.Email.Valid {
+			return editErr
+		}
+		if e.EditOpen && !e.Secret {
 			return closedErr
 		}
 		// unregistered users can only vote if they didn't vote yet
-		err := etx.tx.QueryRow("SELECT vote.uid FROM vote WHERE eid = ? AND uid = ?", e.Eid, user.Uid).Scan(&uid)
-		if sql.ErrNoRows == err {
+		if !voted {
 			return closedErr
 		} else {
 			return ErrorElectionMembersOnlyEdit
@@ -253,32 +449,187 @@ func (etx *ElectionsTx) CanVote(user *User, e *Election) error {
 	if !user.Email.Valid {
 		return ErrorElectionMembersOnly
 	}
-	err := etx.tx.QueryRow("SELECT vote.uid FROM vote WHERE eid = ? AND uid = ?", e.Eid, user.Uid).Scan(&uid)
-	if nil == err {
-		return closedErr
+	if isMember, err := etx.isMember(user, e); nil != err {
+		return err
+	} else if isMember {
+		return editErr
 	} else {
 		return ErrorElectionMembersOnly
 	}
 }
 
-func (etx *ElectionsTx) ElectionVote(e *Election, user *User, ranking types.Ranking) error {
+func newBallotId() (string, error) {
+	if bid, err := randomBytes(16); nil != err {
+		return "", err
+	} else {
+		return hex.EncodeToString(bid), nil
+	}
+}
+
+func (etx *ElectionsTx) ElectionVote(e *Election, user *User, ranking types.Ranking) (*types.Receipt, error) {
 	if err := etx.CanVote(user, e); nil != err {
-		return err
+		return nil, err
 	}
 	if len(e.Candidates) != len(ranking) || nil != ranking.Check() {
-		return ErrorInvalidRanking
+		return nil, ErrorInvalidRanking
+	} else if ranking.Empty() {
+		return nil, ErrorEmptyBallot
+	}
+	bid, err := newBallotId()
+	if nil != err {
+		return nil, internalError(err)
+	}
+	// the previous ballot is read, replaced and subtracted from the tally
+	if err := etx.st.LockElection(e.Eid); nil != err {
+		return nil, internalError(err)
+	}
+	// initialise the tally before the ballot is stored
+	if _, err := etx.ElectionPairwisePreferences(e); nil != err {
+		return nil, err
+	}
+	ballot := types.NewBallot(bid, ranking)
+	if e.Secret {
+		if err := etx.secretBallot(e, user, ballot); nil != err {
+			return nil, err
+		} else if err := etx.addToTally(e, ranking, nil); nil != err {
+			return nil, err
+		}
+		etx.notifyAfterCommit(e, EventVoteCast)
+		receipt := ballot.Receipt()
+		return &receipt, nil
+	}
+	previous, err := etx.st.VoteRanking(e.Eid, user.Uid)
+	if nil != err && errStorageNotFound != err {
+		return nil, internalError(err)
 	}
-	rankingJson := types.JsonMustEncodeString(ranking)
 	if !e.EditOpen && !user.Email.Valid {
-		if _, err := etx.tx.Exec("INSERT INTO vote (eid, uid, ranking) VALUES (?, ?, ?)", e.Eid, user.Uid, rankingJson); nil != err {
-			return ErrorElectionMembersOnlyEdit
+		if err := etx.st.InsertVote(e.Eid, user.Uid, ballot, etx.now().Unix()); errStorageConflict == err {
+			return nil, ErrorElectionMembersOnlyEdit
+		} else if nil != err {
+			return nil, internalError(err)
 		}
 	} else {
-		if _, err := etx.tx.Exec("INSERT OR REPLACE INTO vote (eid, uid, ranking) VALUES (?, ?, ?)", e.Eid, user.Uid, rankingJson); nil != err {
-			log.Printf("Internal error when trying to insert vote: %v", err)
-			return ErrorElectionNotFound
+		if err := etx.st.InsertOrReplaceVote(e.Eid, user.Uid, ballot, etx.now().Unix()); nil != err {
+			return nil, internalError(err)
 		}
 	}
-	log.Printf("Cast vote in election %d: user %d: %s", e.Eid, user.Uid, rankingJson)
+	if err := etx.addToTally(e, ranking, previous); nil != err {
+		return nil, err
+	}
+	auditData := map[string]interface{}{
+		"ballot":  bid,
+		"ranking": ranking,
+	}
+	auditAction := AuditVote
+	if nil != previous {
+		auditAction = AuditVoteChanged
+		auditData["previous"] = previous
+	}
+	if err := etx.audit(auditAction, e, user, auditData); nil != err {
+		return nil, err
+	}
+	logDebugf("Cast vote in election %d: user %d: %s", e.Eid, user.Uid, types.JsonMustEncodeString(ranking))
+	etx.notifyAfterCommit(e, EventVoteCast)
+	receipt := ballot.Receipt()
+	return &receipt, nil
+}
+
+// record participation and the ballot separately; the ballot gets a
+// random id and is only listed ordered by it, so neither the API nor the
+// audit log link it to the voter. both rows are inserted by the same
+// transaction though: the database internals (xmin on PostgreSQL, pages and
+// journal on SQLite) still link them, i.e. secret ballots are not secret
+// to whoever can inspect the database itself
+func (etx *ElectionsTx) secretBallot(e *Election, user *User, ballot types.Ballot) error {
+	if err := etx.st.InsertParticipation(e.Eid, user.Uid, etx.now().Unix()); errStorageConflict == err {
+		return ErrorAlreadyVoted
+	} else if nil != err {
+		return internalError(err)
+	}
+	if err := etx.st.InsertBallot(e.Eid, ballot); nil != err {
+		return internalError(err)
+	}
+	// only the participation: neither ballot details nor the time (which
+	// could be matched with changes of the results)
+	return etx.appendAudit(0, AuditSecretBallot, e, user, nil)
+}
+
+// anonymised ballots, ordered by ballot id
+func (etx *ElectionsTx) ElectionBallots(e *Election) ([]types.Ballot, error) {
+	if ballots, err := etx.st.Ballots(e.Eid, e.Secret); nil != err {
+		return nil, internalError(err)
+	} else {
+		return ballots, nil
+	}
+}
+
+func (etx *ElectionsTx) SetElectionClosed(e *Election, actor *User, closed bool) error {
+	if closed == e.Closed {
+		return nil
+	}
+	e.Closed = closed
+	if err := etx.st.UpdateElection(e); nil != err {
+		return internalError(err)
+	}
+	action := AuditElectionClosed
+	if !closed {
+		action = AuditElectionOpened
+	}
+	if err := etx.audit(action, e, actor, nil); nil != err {
+		return err
+	}
+	if closed {
+		return etx.freezeResults(e)
+	} else if err := etx.st.DeleteFrozenResults(e.Eid); nil != err {
+		return internalError(err)
+	}
 	return nil
 }
+
+func unixOrNil(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+// zero times remove the schedule
+func (etx *ElectionsTx) SetElectionSchedule(e *Election, actor *User, opensAt, closesAt time.Time) error {
+	if !opensAt.IsZero() && !closesAt.IsZero() && !opensAt.Before(closesAt) {
+		return ErrorInvalidSchedule
+	}
+	e.OpensAt = opensAt
+	e.ClosesAt = closesAt
+	// announce the (new) opening again
+	e.Started = false
+	if err := etx.st.UpdateElection(e); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditElectionScheduled, e, actor, map[string]interface{}{
+		"opens_at":  unixOrNil(opensAt),
+		"closes_at": unixOrNil(closesAt),
+	})
+}
+
+func (etx *ElectionsTx) freezeResults(e *Election) error {
+	if prefs, err := etx.ElectionPairwisePreferences(e); nil != err {
+		return err
+	} else if err := etx.st.FreezeResults(e.Eid, etx.now().Unix(), prefs); nil != err {
+		return internalError(err)
+	}
+	return nil
+}
+
+// frozen results for closed elections, live results otherwise
+func (etx *ElectionsTx) ElectionResults(e *Election) (types.PairwisePreferences, error) {
+	if e.Closed {
+		if prefs, err := etx.st.FrozenResults(e.Eid); errStorageNotFound == err {
+			// closed before results were frozen
+		} else if nil != err {
+			return nil, internalError(err)
+		} else {
+			return prefs, nil
+		}
+	}
+	return etx.ElectionPairwisePreferences(e)
+}
@@ -0,0 +1,72 @@
+package backend
+
+var ErrorInvalidUnrankedPolicy = newError("invalid_unranked_policy", "Invalid policy for unranked candidates")
+var ErrorUnrankedPolicyLocked = newError("unranked_policy_locked", "The policy for unranked candidates can't be changed after ballots were cast")
+var ErrorEmptyBallot = newFieldError("empty_ballot", "rankgroups", "Rank at least one candidate")
+
+/* partial ballots don't rank all candidates (see types.Unranked); the
+ * policy decides how they count in the pairwise contests of the candidates
+ * they left out.
+ *
+ * ballots must rank at least one candidate. a ballot can still end up
+ * empty if all candidates it ranked are withdrawn (see ChangeCandidates);
+ * it then prefers no candidate, like an abstention.
+ */
+
+type UnrankedPolicy string
+
+const (
+	// tied behind all ranked candidates (like the last group of a
+	// complete ranking)
+	UnrankedLast UnrankedPolicy = "last"
+	// the ballot doesn't count in contests with unranked candidates
+	UnrankedAbstain UnrankedPolicy = "abstain"
+)
+
+var UnrankedPolicies = []UnrankedPolicy{
+	UnrankedLast,
+	UnrankedAbstain,
+}
+
+func (p UnrankedPolicy) Valid() bool {
+	for _, known := range UnrankedPolicies {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (p UnrankedPolicy) Description() string {
+	switch p {
+	case UnrankedLast:
+		return "Candidates a ballot doesn't rank are tied behind all ranked candidates."
+	case UnrankedAbstain:
+		return "Ballots don't count in contests with candidates they don't rank."
+	default:
+		return ""
+	}
+}
+
+// for types.PairwisePreferences.Count
+func (p UnrankedPolicy) abstain() bool {
+	return UnrankedAbstain == p
+}
+
+// the cast ballots were counted with the old policy
+func (etx *ElectionsTx) SetElectionUnrankedPolicy(e *Election, actor *User, policy UnrankedPolicy) error {
+	if !policy.Valid() {
+		return ErrorInvalidUnrankedPolicy
+	} else if policy == e.Unranked {
+		return nil
+	} else if ballots, err := etx.st.Ballots(e.Eid, e.Secret); nil != err {
+		return internalError(err)
+	} else if 0 != len(ballots) {
+		return ErrorUnrankedPolicyLocked
+	}
+	e.Unranked = policy
+	if err := etx.st.UpdateElection(e); nil != err {
+		return internalError(err)
+	}
+	return etx.audit(AuditUnrankedPolicy, e, actor, policy)
+}
@@ -0,0 +1,297 @@
+// Package client implements the go-vote /api/v1 API as described by the
+// OpenAPI document the server provides at /openapi.json.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stbuehler/go-vote/types"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// a request uses the token if set, then the ballot code, then the name
+// (for voting in open elections)
+type Auth struct {
+	Token string `json:"token,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Code  string `json:"code,omitempty"` // ballot code
+}
+
+// error response of the API
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Field      string `json:"field"`
+}
+
+func (e *Error) Error() string {
+	if 0 != len(e.Field) {
+		return fmt.Sprintf("%s (%s, field %s)", e.Message, e.Code, e.Field)
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+type Results struct {
+	Preferences types.PairwisePreferences `json:"preferences"`
+	Paths       types.StrongestPaths      `json:"paths"` // only if there is no Condorcet winner
+	Winner      *int                      `json:"winner"`
+	Ron         *int                      `json:"ron"`        // index of the RON candidate, if any
+	Failed      bool                      `json:"failed"`     // RON won
+	Ineligible  []int                     `json:"ineligible"` // candidates ranked below RON
+}
+
+type Ballots struct {
+	Candidates []string       `json:"candidates"`
+	Unranked   string         `json:"unranked"` // "last" or "abstain"
+	Ballots    []types.Ballot `json:"ballots"`
+	// old ballot hash -> new hash of ballots adjusted after the candidates
+	// changed
+	Adjusted map[string]string `json:"adjusted"`
+}
+
+type AuditEntry struct {
+	Seq    int64
+	Time   int64 // unix timestamp
+	Action string
+	Eid    int64
+	Uid    int64
+	Data   string // json
+	Hash   string
+}
+
+type Client struct {
+	// server url including the prefix, like "https://example.com/vote"
+	BaseUrl    string
+	Auth       Auth
+	HttpClient *http.Client
+}
+
+func New(baseUrl string, auth Auth) *Client {
+	return &Client{
+		BaseUrl:    baseUrl,
+		Auth:       auth,
+		HttpClient: http.DefaultClient,
+	}
+}
+
+// request to the /api/v1 resource at path; request is sent as JSON unless nil
+func (c *Client) do(method, path string, request interface{}, result interface{}) error {
+	u := c.BaseUrl + "/api/v1/" + path
+	var body io.Reader
+	if nil != request {
+		data, err := json.Marshal(request)
+		if nil != err {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if nil != request {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if 0 != len(c.Auth.Token) {
+		req.Header.Set("Authorization", "Bearer "+c.Auth.Token)
+	} else if 0 != len(c.Auth.Code) {
+		req.Header.Set("Authorization", "Ballot-Code "+c.Auth.Code)
+	}
+
+	resp, err := c.HttpClient.Do(req)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if nil != err {
+		return err
+	} else if 200 != resp.StatusCode {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(data, apiErr); nil != err || 0 == len(apiErr.Code) {
+			return fmt.Errorf("%s: %s: %s", u, resp.Status, bytes.TrimSpace(data))
+		}
+		return apiErr
+	}
+	return json.Unmarshal(data, result)
+}
+
+func electionPath(name string) string {
+	return "elections/" + url.PathEscape(name)
+}
+
+type Candidate struct {
+	Id          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"` // Markdown
+	Url         string `json:"url"`
+	Image       string `json:"image"`              // URL
+	Reserved    bool   `json:"reserved,omitempty"` // reopen nominations (RON)
+}
+
+type Election struct {
+	Name             string      `json:"name"`
+	Title            string      `json:"title"`
+	Candidates       []string    `json:"candidates"`
+	CandidateDetails []Candidate `json:"candidate_details"` // same order as Candidates
+	Closed           bool        `json:"closed"`
+	OpensAt          int64       `json:"opens_at"`  // unix timestamp, 0 if not scheduled
+	ClosesAt         int64       `json:"closes_at"` // unix timestamp, 0 if not scheduled
+	// nomination phase: no voting yet
+	Nominating        bool `json:"nominating"`
+	NominationSeconds int  `json:"nomination_seconds"` // seconds a nomination needs
+	// partial ballots: unranked candidates are tied "last", or the
+	// ballots "abstain" from their contests
+	Unranked string `json:"unranked"`
+	// "adjusted" or "revote" if the candidates changed after the user
+	// voted, otherwise empty
+	BallotReview string `json:"ballot_review"`
+}
+
+func (c *Client) Election(name string) (*Election, error) {
+	var election Election
+	if err := c.do("GET", electionPath(name), nil, &election); nil != err {
+		return nil, err
+	}
+	return &election, nil
+}
+
+type ballotRequest struct {
+	Auth       *Auth            `json:"auth,omitempty"` // name of unregistered users
+	RankGroups types.RankGroups `json:"rankgroups"`
+}
+
+// cast or change a ballot; keep the receipt to verify the published ballot
+func (c *Client) Vote(election string, rankGroups types.RankGroups) (*types.Receipt, error) {
+	request := ballotRequest{RankGroups: rankGroups}
+	if 0 != len(c.Auth.Name) {
+		request.Auth = &Auth{Name: c.Auth.Name}
+	}
+	var receipt types.Receipt
+	if err := c.do("PUT", electionPath(election)+"/ballot", request, &receipt); nil != err {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+func (c *Client) Results(election string) (*Results, error) {
+	var results Results
+	if err := c.do("GET", electionPath(election)+"/results", nil, &results); nil != err {
+		return nil, err
+	}
+	return &results, nil
+}
+
+// anonymised ballots, only available after the election closed
+func (c *Client) Ballots(election string) (*Ballots, error) {
+	var ballots Ballots
+	if err := c.do("GET", electionPath(election)+"/ballots", nil, &ballots); nil != err {
+		return nil, err
+	}
+	return &ballots, nil
+}
+
+type TurnoutGroup struct {
+	Group    string `json:"group"`
+	Eligible int    `json:"eligible"`
+	Voted    int    `json:"voted"`
+}
+
+type TurnoutPoint struct {
+	Time  int64 `json:"time"`  // unix timestamp of the interval start
+	Voted int   `json:"voted"` // ballots cast until the end of the interval
+}
+
+type Turnout struct {
+	Open     bool           `json:"open"` // anybody can vote; Eligible only counts members and ballot codes
+	Eligible int            `json:"eligible"`
+	Voted    int            `json:"voted"`
+	Groups   []TurnoutGroup `json:"groups"`
+	Interval int64          `json:"interval"` // seconds between timeline points
+	Timeline []TurnoutPoint `json:"timeline"`
+}
+
+// election managers only
+func (c *Client) Turnout(election string) (*Turnout, error) {
+	var turnout Turnout
+	if err := c.do("GET", electionPath(election)+"/turnout", nil, &turnout); nil != err {
+		return nil, err
+	}
+	return &turnout, nil
+}
+
+type Nomination struct {
+	Id          int64  `json:"id"`
+	Nominator   string `json:"nominator"`
+	Name        string `json:"name"`
+	Description string `json:"description"` // Markdown
+	Url         string `json:"url"`
+	Image       string `json:"image"`   // URL
+	Status      string `json:"status"`  // "pending", "approved" or "rejected"
+	Created     int64  `json:"created"` // unix timestamp
+	Seconds     int    `json:"seconds"`
+}
+
+func (c *Client) Nominations(election string) ([]Nomination, error) {
+	var nominations []Nomination
+	if err := c.do("GET", electionPath(election)+"/nominations", nil, &nominations); nil != err {
+		return nil, err
+	}
+	return nominations, nil
+}
+
+type nominationRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Url         string `json:"url,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// registered users during the nomination phase; the Id of candidate is
+// ignored
+func (c *Client) Nominate(election string, candidate Candidate) (*Nomination, error) {
+	var nomination Nomination
+	request := nominationRequest{Name: candidate.Name, Description: candidate.Description, Url: candidate.Url, Image: candidate.Image}
+	if err := c.do("POST", electionPath(election)+"/nominations", request, &nomination); nil != err {
+		return nil, err
+	}
+	return &nomination, nil
+}
+
+func nominationPath(election string, id int64) string {
+	return electionPath(election) + "/nominations/" + strconv.FormatInt(id, 10)
+}
+
+func (c *Client) Second(election string, id int64) (*Nomination, error) {
+	var nomination Nomination
+	if err := c.do("PUT", nominationPath(election, id)+"/second", nil, &nomination); nil != err {
+		return nil, err
+	}
+	return &nomination, nil
+}
+
+// election managers only; status "approved" or "rejected"
+func (c *Client) DecideNomination(election string, id int64, status string) (*Nomination, error) {
+	var nomination Nomination
+	request := map[string]string{"status": status}
+	if err := c.do("PUT", nominationPath(election, id), request, &nomination); nil != err {
+		return nil, err
+	}
+	return &nomination, nil
+}
+
+// site admins only
+func (c *Client) AuditLog() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if err := c.do("GET", "audit", nil, &entries); nil != err {
+		return nil, err
+	}
+	return entries, nil
+}
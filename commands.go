@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stbuehler/go-vote/backend"
+	"github.com/stbuehler/go-vote/frontend"
+	"github.com/stbuehler/go-vote/types"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type command struct {
+	args string
+	help string
+	run  func(args []string) error
+}
+
+var errUsage = errors.New("invalid arguments")
+
+var commands = map[string]command{
+	"add-member": {
+		args: "ELECTION EMAIL [GROUP]",
+		help: "list registered user as member (eligible voter); GROUP is used for turnout statistics",
+		run:  cmdAddMember,
+	},
+	"ballot-codes": {
+		args: "ELECTION COUNT [URL]",
+		help: "generate COUNT ballot codes and print them as HTML sheet",
+		run:  cmdBallotCodes,
+	},
+	"candidates": {
+		args: "[-revote] ELECTION -NAME|+NAME...",
+		help: "withdraw (-NAME) or add (+NAME) candidates; cast ballots are adjusted (new\n      candidates ranked last), -revote resets the votes instead",
+		run:  cmdCandidates,
+	},
+	"audit-export": {
+		help: "print the audit log as JSON",
+		run:  cmdAuditExport,
+	},
+	"audit-verify": {
+		args: "[FILE]",
+		help: "verify the hash chain of the audit log (or of an exported log)",
+		run:  cmdAuditVerify,
+	},
+	"migrate": {
+		args: "[-dry-run]",
+		help: "upgrade the database schema; -dry-run tries the pending migrations\n      and rolls them back",
+		run:  cmdMigrate,
+	},
+	"close": {
+		args: "ELECTION",
+		help: "close election",
+		run:  cmdClose,
+	},
+	"reopen": {
+		args: "ELECTION",
+		help: "reopen closed election",
+		run:  cmdReopen,
+	},
+	"nominations": {
+		args: "ELECTION on|off [SECONDS]",
+		help: "start or end the nomination phase; SECONDS: seconds a nomination needs\n      before it can be approved (default: unchanged)",
+		run:  cmdNominations,
+	},
+	"results-policy": {
+		args: "ELECTION POLICY",
+		help: "set who can see results (always, after-close, after-close-voters, managers)",
+		run:  cmdResultsPolicy,
+	},
+	"ron": {
+		args: "ELECTION on|off",
+		help: "add or remove the reopen nominations (RON) candidate; only before voting opened",
+		run:  cmdRon,
+	},
+	"schedule": {
+		args: "ELECTION OPENS CLOSES",
+		help: "schedule opening and closing of election (RFC 3339 timestamps, \"-\" for none)",
+		run:  cmdSchedule,
+	},
+	"tally-check": {
+		args: "[-repair] [ELECTION...]",
+		help: "compare the stored pairwise tallies with the ballots (default: all elections);\n      -repair replaces wrong tallies",
+		run:  cmdTallyCheck,
+	},
+	"unranked-policy": {
+		args: "ELECTION POLICY",
+		help: "set how partial ballots count candidates they don't rank (last, abstain);\n      only before ballots were cast",
+		run:  cmdUnrankedPolicy,
+	},
+	"verify": {
+		args: "URL ELECTION [BALLOT-ID RECEIPT-HASH]",
+		help: "recompute the result of a closed election from the published ballots\n      (URL: server including prefix); optionally check that the ballot of a\n      receipt was published unchanged (or adjusted to changed candidates)",
+		run:  cmdVerify,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] [COMMAND ARGS...]\n\nWithout command the server is started.\n\nOptions:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n      %s\n", name, commands[name].args, commands[name].help)
+	}
+}
+
+// run update in a transaction and commit
+func updateElection(name string, update func(etx *backend.ElectionsTx, e *backend.Election) error) error {
+	edb, err := openDatabase()
+	if nil != err {
+		return err
+	}
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return err
+	}
+	defer etx.Rollback()
+
+	if e, err := etx.ElectionByName(name); nil != err {
+		return err
+	} else if err := update(etx, e); nil != err {
+		return err
+	}
+	return etx.Commit()
+}
+
+func cmdBallotCodes(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errUsage
+	}
+	count, err := strconv.Atoi(args[1])
+	if nil != err || count <= 0 {
+		return errUsage
+	}
+	url := "/e/" + args[0]
+	if 3 == len(args) {
+		url = args[2]
+	}
+
+	var codes []string
+	var election *backend.Election
+	if err := updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) (err error) {
+		election = e
+		codes, err = etx.GenerateBallotCodes(e, nil, count)
+		return
+	}); nil != err {
+		return err
+	}
+	return frontend.WriteBallotCodeSheet(os.Stdout, url, election, codes)
+}
+
+func cmdAddMember(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errUsage
+	}
+	group := ""
+	if 3 == len(args) {
+		group = args[2]
+	}
+	return updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) error {
+		if user, err := etx.FindUserByEmail(args[1]); nil != err {
+			return err
+		} else {
+			return etx.AddElectionMember(e, user, group, nil)
+		}
+	})
+}
+
+func cmdVerify(args []string) error {
+	if 2 != len(args) && 4 != len(args) {
+		return errUsage
+	}
+	var receipt *types.Receipt
+	if 4 == len(args) {
+		receipt = &types.Receipt{Ballot: args[2], Hash: args[3]}
+	}
+	return verifyElection(args[0], args[1], receipt)
+}
+
+func auditLog() ([]backend.AuditEntry, error) {
+	edb, err := openDatabase()
+	if nil != err {
+		return nil, err
+	}
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return nil, err
+	}
+	defer etx.Rollback()
+	return etx.AuditLog()
+}
+
+func cmdAuditExport(args []string) error {
+	if 0 != len(args) {
+		return errUsage
+	}
+	entries, err := auditLog()
+	if nil != err {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(entries)
+}
+
+func cmdAuditVerify(args []string) error {
+	var entries []backend.AuditEntry
+	if 0 == len(args) {
+		var err error
+		if entries, err = auditLog(); nil != err {
+			return err
+		}
+	} else if 1 == len(args) {
+		if data, err := ioutil.ReadFile(args[0]); nil != err {
+			return err
+		} else if err := json.Unmarshal(data, &entries); nil != err {
+			return err
+		}
+	} else {
+		return errUsage
+	}
+	if err := backend.VerifyAuditChain(entries); nil != err {
+		return err
+	}
+	if 0 != len(entries) {
+		fmt.Printf("Verified %d audit entries, last hash %s\n", len(entries), entries[len(entries)-1].Hash)
+	} else {
+		fmt.Println("Audit log is empty")
+	}
+	return nil
+}
+
+func setElectionClosed(args []string, closed bool) error {
+	if 1 != len(args) {
+		return errUsage
+	}
+	return updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) error {
+		return etx.SetElectionClosed(e, nil, closed)
+	})
+}
+
+func cmdClose(args []string) error {
+	return setElectionClosed(args, true)
+}
+
+func cmdReopen(args []string) error {
+	return setElectionClosed(args, false)
+}
+
+func cmdMigrate(args []string) error {
+	dryRun := false
+	if 1 == len(args) && "-dry-run" == args[0] {
+		dryRun = true
+	} else if 0 != len(args) {
+		return errUsage
+	}
+
+	db, postgres, err := sqlDatabase()
+	if nil != err {
+		return err
+	}
+	defer db.Close()
+
+	var pending []backend.Migration
+	if postgres {
+		pending, err = backend.MigratePostgres(db, dryRun)
+	} else {
+		pending, err = backend.MigrateDatabase(db, dryRun)
+	}
+	if nil != err {
+		return err
+	}
+
+	if 0 == len(pending) {
+		fmt.Println("Database schema is up to date")
+		return nil
+	}
+	for _, m := range pending {
+		fmt.Printf("Migration %d: %s\n", m.Version, m.Description)
+	}
+	if dryRun {
+		fmt.Printf("Dry run: %d migrations succeeded and were rolled back\n", len(pending))
+	} else {
+		fmt.Printf("Applied %d migrations\n", len(pending))
+	}
+	return nil
+}
+
+func cmdTallyCheck(args []string) error {
+	repair := false
+	if 0 != len(args) && "-repair" == args[0] {
+		repair = true
+		args = args[1:]
+	}
+
+	edb, err := openDatabase()
+	if nil != err {
+		return err
+	}
+	etx, err := edb.StartTransaction()
+	if nil != err {
+		return err
+	}
+	defer etx.Rollback()
+
+	var elections []*backend.Election
+	if 0 == len(args) {
+		if elections, err = etx.Elections(); nil != err {
+			return err
+		}
+	}
+	for _, name := range args {
+		if e, err := etx.ElectionByName(name); nil != err {
+			return fmt.Errorf("%s: %v", name, err)
+		} else {
+			elections = append(elections, e)
+		}
+	}
+
+	inconsistent := 0
+	for _, e := range elections {
+		stored, counted, err := etx.CheckElectionTally(e, repair)
+		if nil != err {
+			return fmt.Errorf("%s: %v", e.Name, err)
+		} else if nil == stored && repair {
+			fmt.Printf("%s: initialised tally\n", e.Name)
+		} else if nil == stored {
+			fmt.Printf("%s: tally not initialised yet\n", e.Name)
+		} else if !stored.Equal(counted) {
+			inconsistent++
+			fmt.Printf("%s: stored tally differs from the ballots\nStored:\n%sCounted:\n%s", e.Name, stored.AsciiTable(backend.CandidateNames(e.Candidates)), counted.AsciiTable(backend.CandidateNames(e.Candidates)))
+		} else {
+			fmt.Printf("%s: ok\n", e.Name)
+		}
+	}
+	if repair {
+		if err := etx.Commit(); nil != err {
+			return err
+		}
+		fmt.Printf("Repaired %d tallies\n", inconsistent)
+	} else if 0 != inconsistent {
+		return fmt.Errorf("%d inconsistent tallies (use -repair to fix them)", inconsistent)
+	}
+	return nil
+}
+
+func parseScheduleTime(arg string) (time.Time, error) {
+	if "-" == arg {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, arg)
+}
+
+func cmdSchedule(args []string) error {
+	if 3 != len(args) {
+		return errUsage
+	}
+	opensAt, err := parseScheduleTime(args[1])
+	if nil != err {
+		return err
+	}
+	closesAt, err := parseScheduleTime(args[2])
+	if nil != err {
+		return err
+	}
+	return updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) error {
+		return etx.SetElectionSchedule(e, nil, opensAt, closesAt)
+	})
+}
+
+func cmdNominations(args []string) error {
+	if len(args) < 2 || len(args) > 3 || ("on" != args[1] && "off" != args[1]) {
+		return errUsage
+	}
+	seconds := -1
+	if 3 == len(args) {
+		var err error
+		if seconds, err = strconv.Atoi(args[2]); nil != err || seconds < 0 {
+			return errUsage
+		}
+	}
+	return updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) error {
+		if seconds < 0 {
+			seconds = e.NominationSeconds
+		}
+		return etx.SetElectionNominations(e, nil, "on" == args[1], seconds)
+	})
+}
+
+func cmdCandidates(args []string) error {
+	// options come before the election: "-revote" after it withdraws a
+	// candidate named "revote"
+	revote := false
+	if 0 != len(args) && "-revote" == args[0] {
+		revote = true
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return errUsage
+	}
+	name, args := args[0], args[1:]
+	var withdraw []string
+	var add []backend.Candidate
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			withdraw = append(withdraw, arg[1:])
+		} else if strings.HasPrefix(arg, "+") {
+			add = append(add, backend.Candidate{Name: arg[1:]})
+		} else {
+			return errUsage
+		}
+	}
+	return updateElection(name, func(etx *backend.ElectionsTx, e *backend.Election) error {
+		var cids []int64
+	nextName:
+		for _, name := range withdraw {
+			for _, c := range e.Candidates {
+				if c.Name == name {
+					cids = append(cids, c.Id)
+					continue nextName
+				}
+			}
+			return fmt.Errorf("%s: no such candidate", name)
+		}
+		return etx.ChangeCandidates(e, nil, cids, add, revote)
+	})
+}
+
+func cmdRon(args []string) error {
+	if 2 != len(args) || ("on" != args[1] && "off" != args[1]) {
+		return errUsage
+	}
+	return updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) error {
+		return etx.SetElectionRon(e, nil, "on" == args[1])
+	})
+}
+
+func cmdResultsPolicy(args []string) error {
+	if 2 != len(args) {
+		return errUsage
+	}
+	return updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) error {
+		return etx.SetElectionResultsPolicy(e, nil, backend.ResultsPolicy(args[1]))
+	})
+}
+
+func cmdUnrankedPolicy(args []string) error {
+	if 2 != len(args) {
+		return errUsage
+	}
+	return updateElection(args[0], func(etx *backend.ElectionsTx, e *backend.Election) error {
+		return etx.SetElectionUnrankedPolicy(e, nil, backend.UnrankedPolicy(args[1]))
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/stbuehler/go-vote/backend"
+	"os"
+	"sort"
+	"strings"
+)
+
+/* settings are read from (later sources override earlier ones):
+ * - the TOML file given by -config or GO_VOTE_CONFIG:
+ *
+ *     database = "postgres://vote@db.example.com/vote"
+ *     listen = ":8443"
+ *     prefix = "/vote"
+ *     tls_cert = "/etc/go-vote/cert.pem"
+ *     tls_key = "/etc/go-vote/key.pem"
+ *     log_level = "info"
+ *
+ *     [features]
+ *     audit_api = false
+ *
+ * - environment variables GO_VOTE_DATABASE, GO_VOTE_LISTEN, ...
+ * - command line flags -database, -listen, ...
+ */
+
+type config struct {
+	Database string        `toml:"database"`
+	Listen   string        `toml:"listen"`
+	Prefix   string        `toml:"prefix"`
+	TLSCert  string        `toml:"tls_cert"`
+	TLSKey   string        `toml:"tls_key"`
+	LogLevel string        `toml:"log_level"`
+	Features featureConfig `toml:"features"`
+}
+
+type featureConfig struct {
+	Scheduler      bool `toml:"scheduler"`
+	BallotCodes    bool `toml:"ballot_codes"`
+	PublishBallots bool `toml:"publish_ballots"`
+	AuditApi       bool `toml:"audit_api"`
+}
+
+var cfg = config{
+	Database: "elections.sqlite",
+	Listen:   ":8080",
+	LogLevel: "info",
+	Features: featureConfig{
+		Scheduler:      true,
+		BallotCodes:    true,
+		PublishBallots: true,
+		AuditApi:       true,
+	},
+}
+
+func (f *featureConfig) toggles() map[string]*bool {
+	return map[string]*bool{
+		"scheduler":       &f.Scheduler,
+		"ballot-codes":    &f.BallotCodes,
+		"publish-ballots": &f.PublishBallots,
+		"audit-api":       &f.AuditApi,
+	}
+}
+
+func (f featureConfig) backend() backend.Features {
+	return backend.Features{
+		BallotCodes:    f.BallotCodes,
+		PublishBallots: f.PublishBallots,
+		AuditApi:       f.AuditApi,
+	}
+}
+
+func featureNames() string {
+	var names []string
+	for name := range cfg.Features.toggles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// "a,-b": enable feature a, disable feature b
+func (f *featureConfig) set(list string) error {
+	toggles := f.toggles()
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		enable := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if 0 == len(name) {
+			continue
+		} else if toggle, ok := toggles[name]; !ok {
+			return fmt.Errorf("unknown feature %+q (known: %s)", name, featureNames())
+		} else {
+			*toggle = enable
+		}
+	}
+	return nil
+}
+
+type setting struct {
+	name string
+	help string
+	set  func(value string) error
+}
+
+var settings = []setting{
+	{"database", "sqlite file or postgres:// url", func(v string) error { cfg.Database = v; return nil }},
+	{"listen", "address to listen on", func(v string) error { cfg.Listen = v; return nil }},
+	{"prefix", "URL path prefix (like \"/vote\")", func(v string) error { cfg.Prefix = v; return nil }},
+	{"tls-cert", "TLS certificate file", func(v string) error { cfg.TLSCert = v; return nil }},
+	{"tls-key", "TLS key file", func(v string) error { cfg.TLSKey = v; return nil }},
+	{"log-level", "debug, info or error", func(v string) error { cfg.LogLevel = v; return nil }},
+	{"features", "comma separated features to enable, \"-\" prefix disables", cfg.Features.set},
+}
+
+func (s setting) env() string {
+	return "GO_VOTE_" + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+func (c *config) check() error {
+	if 0 != len(c.Prefix) && !strings.HasPrefix(c.Prefix, "/") {
+		return fmt.Errorf("prefix must start with \"/\"")
+	}
+	c.Prefix = strings.TrimRight(c.Prefix, "/")
+	if (0 == len(c.TLSCert)) != (0 == len(c.TLSKey)) {
+		return fmt.Errorf("tls-cert and tls-key must be used together")
+	}
+	if level, err := backend.ParseLogLevel(c.LogLevel); nil != err {
+		return err
+	} else {
+		backend.SetLogLevel(level)
+	}
+	return nil
+}
+
+// parses flags; flag.Args() are the remaining arguments
+func loadConfig() error {
+	configFile := flag.String("config", os.Getenv("GO_VOTE_CONFIG"), "TOML configuration file (GO_VOTE_CONFIG)")
+	values := make([]*string, len(settings))
+	for i, s := range settings {
+		values[i] = flag.String(s.name, "", fmt.Sprintf("%s (%s)", s.help, s.env()))
+	}
+	flag.Usage = usage
+	flag.Parse()
+
+	if 0 != len(*configFile) {
+		if meta, err := toml.DecodeFile(*configFile, &cfg); nil != err {
+			return err
+		} else if undecoded := meta.Undecoded(); 0 != len(undecoded) {
+			return fmt.Errorf("%s: unknown setting %s", *configFile, undecoded[0])
+		}
+	}
+	for _, s := range settings {
+		if value := os.Getenv(s.env()); 0 != len(value) {
+			if err := s.set(value); nil != err {
+				return fmt.Errorf("%s: %v", s.env(), err)
+			}
+		}
+	}
+	visited := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		visited[f.Name] = true
+	})
+	for i, s := range settings {
+		if visited[s.name] {
+			if err := s.set(*values[i]); nil != err {
+				return fmt.Errorf("-%s: %v", s.name, err)
+			}
+		}
+	}
+	return cfg.check()
+}
@@ -0,0 +1,631 @@
+package frontend
+
+import (
+	"database/sql"
+	"github.com/stbuehler/go-vote/backend"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* admin pages (site admins only):
+ *
+ *   /admin                elections and users; create elections and users,
+ *                         issue new tokens
+ *   /admin/e/{name}       edit election, members, ballot codes; audit
+ *                         entries of the election
+ *   /admin/audit          complete audit log
+ *
+ * forms post to the page they are on; successful changes redirect back to
+ * it. new tokens are only shown in the response to the form.
+ */
+
+// datetime-local inputs (local time of the server)
+const scheduleInputFormat = "2006-01-02T15:04"
+
+// posted form; values are shown again if it failed
+type adminForm struct {
+	Form    url.Values
+	Error   backend.Error // empty Message: no error
+	Message string        // like a new token
+	Csrf    string        // form token (see formToken), posted as "csrf"
+}
+
+// posted value of field, if the form of action failed
+func (f adminForm) Value(action, field string) string {
+	if action != f.Form.Get("action") || 0 == len(f.Error.Message) {
+		return ""
+	}
+	return f.Form.Get(field)
+}
+
+// whether the form of action failed because of field
+func (f adminForm) Invalid(action, field string) bool {
+	return action == f.Form.Get("action") && 0 != len(f.Error.Field) && field == f.Error.Field
+}
+
+type adminPage struct {
+	page
+	adminForm
+	Elections []backend.ElectionOverview
+	Users     []*backend.User
+	Policies  []backend.ResultsPolicy
+	// partial ballots
+	UnrankedPolicies []backend.UnrankedPolicy
+}
+
+type auditRow struct {
+	Seq      int64
+	Time     string
+	Action   string
+	Election string // name
+	User     string
+	Data     string
+}
+
+type adminElectionPage struct {
+	page
+	adminForm
+	Election   *backend.Election
+	Title      string
+	State      backend.ElectionState
+	Candidates []backend.Candidate // with empty rows to add candidates
+	OpensAt    string
+	ClosesAt   string
+	Policies   []backend.ResultsPolicy
+	// partial ballots
+	UnrankedPolicies []backend.UnrankedPolicy
+	Members          []backend.Member
+	Nominations      []backend.Nomination
+	BallotCodes      bool
+	Audit            []auditRow // without ballots
+}
+
+type auditPage struct {
+	page
+	Valid  bool
+	Broken string // verification error
+	Audit  []auditRow
+}
+
+// site admin of the login cookie; responds with 403 if not logged in as one
+func adminUser(w http.ResponseWriter, etx *backend.ElectionsTx, req *http.Request) *backend.User {
+	if user := loginUser(etx, req); nil == user || !user.SiteAdmin {
+		http.Error(w, backend.ErrorAdminOnly.Message, 403)
+		return nil
+	} else {
+		return user
+	}
+}
+
+// runs action of a site admin in a transaction (retried if the database
+// was busy) and commits
+func (f Frontend) adminPost(req *http.Request, action func(etx *backend.ElectionsTx, admin *backend.User) error) error {
+	return f.Edb.Retry(func() error {
+		etx, err := f.Edb.StartTransaction()
+		if nil != err {
+			return err
+		}
+		defer etx.Rollback()
+
+		if admin := loginUser(etx, req); nil == admin || !admin.SiteAdmin {
+			return backend.ErrorAdminOnly
+		} else if err := action(etx, admin); nil != err {
+			return err
+		}
+		return etx.Commit()
+	})
+}
+
+// status code for the page showing the result of a posted form; 0 if the
+// response was already sent
+func (f Frontend) adminResult(w http.ResponseWriter, req *http.Request, form *adminForm, err error) int {
+	if nil != err {
+		status, e := backend.RequestError(req, err)
+		form.Error = *e
+		return status
+	} else if 0 == len(form.Message) {
+		http.Redirect(w, req, req.URL.Path, 303)
+		return 0
+	}
+	return 200
+}
+
+// names for audit entries
+type adminAudit struct {
+	elections map[int64]string
+	users     map[int64]string
+}
+
+func loadAdminAudit(etx *backend.ElectionsTx) (*adminAudit, error) {
+	a := &adminAudit{elections: make(map[int64]string), users: make(map[int64]string)}
+	if elections, err := etx.Elections(); nil != err {
+		return nil, err
+	} else {
+		for _, e := range elections {
+			a.elections[e.Eid] = e.Name
+		}
+	}
+	if users, err := etx.RegisteredUsers(); nil != err {
+		return nil, err
+	} else {
+		for _, u := range users {
+			a.users[u.Uid] = u.Name
+		}
+	}
+	return a, nil
+}
+
+func (names *adminAudit) row(a backend.AuditEntry) auditRow {
+	row := auditRow{
+		Seq:      a.Seq,
+		Time:     time.Unix(a.Time, 0).Format(time.RFC3339),
+		Action:   a.Action,
+		Election: names.elections[a.Eid],
+		User:     names.users[a.Uid],
+		Data:     a.Data,
+	}
+	if 0 == a.Time {
+		row.Time = "-"
+	}
+	if 0 == a.Uid {
+		row.User = "(command line)"
+	} else if 0 == len(row.User) {
+		row.User = "user " + strconv.FormatInt(a.Uid, 10)
+	}
+	return row
+}
+
+func isBallotAudit(action string) bool {
+	return backend.AuditVote == action || backend.AuditVoteChanged == action || backend.AuditSecretBallot == action
+}
+
+func (f Frontend) postAdmin(w http.ResponseWriter, req *http.Request, p paths, form *adminForm) int {
+	if !checkFormToken(w, req) {
+		return 0
+	}
+	var err error
+	switch req.PostFormValue("action") {
+	case "create-election":
+		e := &backend.Election{
+			Name:       strings.TrimSpace(req.PostFormValue("name")),
+			Title:      strings.TrimSpace(req.PostFormValue("title")),
+			Candidates: candidatesFromNames(splitLines(req.PostFormValue("candidates"))),
+			Nominating: "" != req.PostFormValue("nominating"),
+			Public:     "" != req.PostFormValue("public"),
+			Open:       "" != req.PostFormValue("open"),
+			EditOpen:   "" != req.PostFormValue("editopen"),
+			Secret:     "" != req.PostFormValue("secret"),
+			Results:    backend.ResultsPolicy(req.PostFormValue("results")),
+			Unranked:   backend.UnrankedPolicy(req.PostFormValue("unranked")),
+		}
+		if "" != req.PostFormValue("ron") {
+			e.Candidates = append(e.Candidates, backend.RonCandidate())
+		}
+		if e.NominationSeconds, err = parseSeconds(req.PostFormValue("seconds")); nil != err {
+			break
+		}
+		if err = f.adminPost(req, func(etx *backend.ElectionsTx, admin *backend.User) error {
+			return etx.CreateElection(e, admin)
+		}); nil == err {
+			http.Redirect(w, req, req.URL.Path+"/e/"+url.PathEscape(e.Name), 303)
+			return 0
+		}
+	case "create-user":
+		user := &backend.User{
+			Name:      strings.TrimSpace(req.PostFormValue("name")),
+			Email:     sql.NullString{String: strings.TrimSpace(req.PostFormValue("email")), Valid: true},
+			SiteAdmin: "" != req.PostFormValue("siteadmin"),
+		}
+		var token string
+		if token, err = backend.NewUserToken(); nil == err {
+			user.Token = sql.NullString{String: token, Valid: true}
+			if err = f.adminPost(req, func(etx *backend.ElectionsTx, admin *backend.User) error {
+				return etx.CreateUser(user, admin)
+			}); nil == err {
+				form.Message = "Token for " + user.Name + ": " + token
+			}
+		}
+	case "new-token":
+		var token string
+		if token, err = backend.NewUserToken(); nil == err {
+			var user *backend.User
+			self := false
+			if err = f.adminPost(req, func(etx *backend.ElectionsTx, admin *backend.User) (err error) {
+				if user, err = etx.FindUserByEmail(req.PostFormValue("email")); nil != err {
+					return err
+				}
+				self = user.Uid == admin.Uid
+				return etx.SetUserToken(user, admin, token)
+			}); nil == err {
+				if self {
+					// stay logged in
+					replaceTokenCookie(w, req, p, token)
+				}
+				form.Message = "New token for " + user.Name + ": " + token
+			}
+		}
+	default:
+		http.Error(w, "Unknown action", 400)
+		return 0
+	}
+	return f.adminResult(w, req, form, err)
+}
+
+func (f Frontend) serveAdmin(w http.ResponseWriter, req *http.Request, p paths) {
+	data := adminPage{page: page{paths: p}, Policies: backend.ResultsPolicies, UnrankedPolicies: backend.UnrankedPolicies}
+	status := 200
+	if "POST" == req.Method {
+		if status = f.postAdmin(w, req, p, &data.adminForm); 0 == status {
+			return
+		}
+		data.Form = req.PostForm
+	} else if !allowGet(w, req) {
+		return
+	}
+	// after posting: new tokens replace the login cookie
+	data.Csrf = formToken(req)
+
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	if data.User = adminUser(w, etx, req); nil == data.User {
+		return
+	}
+	if data.Elections, err = etx.ElectionOverviews(data.User); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	} else if data.Users, err = etx.RegisteredUsers(); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	render(w, status, "admin", data)
+}
+
+// non-empty lines without surrounding whitespace
+func splitLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); 0 != len(line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// empty: 0
+func parseSeconds(value string) (int, error) {
+	if 0 == len(value) {
+		return 0, nil
+	} else if seconds, err := strconv.Atoi(value); nil != err || seconds < 0 {
+		return 0, backend.ErrorInvalidNominationSeconds
+	} else {
+		return seconds, nil
+	}
+}
+
+func candidatesFromNames(names []string) []backend.Candidate {
+	candidates := make([]backend.Candidate, len(names))
+	for i, name := range names {
+		candidates[i].Name = name
+	}
+	return candidates
+}
+
+// candidate rows from the "cid-0", "name-0", ... form fields; rows
+// with an empty name are dropped if dropEmpty
+func parseCandidates(form url.Values, dropEmpty bool) ([]backend.Candidate, error) {
+	var candidates []backend.Candidate
+	for i := 0; ; i++ {
+		n := strconv.Itoa(i)
+		if _, ok := form["name-"+n]; !ok {
+			return candidates, nil
+		}
+		c := backend.Candidate{
+			Name:        strings.TrimSpace(form.Get("name-" + n)),
+			Description: strings.TrimSpace(form.Get("description-" + n)),
+			Url:         strings.TrimSpace(form.Get("url-" + n)),
+			Image:       strings.TrimSpace(form.Get("image-" + n)),
+		}
+		if cid := form.Get("cid-" + n); 0 != len(cid) {
+			var err error
+			if c.Id, err = strconv.ParseInt(cid, 10, 64); nil != err {
+				return nil, backend.ErrorInvalidCandidates
+			}
+		}
+		if 0 != len(c.Name) || !dropEmpty {
+			candidates = append(candidates, c)
+		}
+	}
+}
+
+func parseScheduleInput(value string) (time.Time, error) {
+	if 0 == len(value) {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(scheduleInputFormat, value, time.Local)
+}
+
+func formatScheduleInput(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(scheduleInputFormat)
+}
+
+// absolute URL of the election page for ballot code slips
+func voteURL(req *http.Request, p paths, name string) string {
+	scheme := "http"
+	if nil != req.TLS {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + p.Prefix + "/e/" + url.PathEscape(name)
+}
+
+func (f Frontend) postAdminElection(w http.ResponseWriter, req *http.Request, p paths, name string, form *adminForm) int {
+	if !checkFormToken(w, req) {
+		return 0
+	}
+	// update runs with the current election
+	update := func(update func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error) (e *backend.Election, err error) {
+		err = f.adminPost(req, func(etx *backend.ElectionsTx, admin *backend.User) (err error) {
+			if e, err = etx.ElectionByName(name); nil != err {
+				return err
+			}
+			return update(etx, admin, e)
+		})
+		return
+	}
+
+	var err error
+	switch req.PostFormValue("action") {
+	case "details":
+		title := strings.TrimSpace(req.PostFormValue("title"))
+		var candidates []backend.Candidate
+		if candidates, err = parseCandidates(req.PostForm, true); nil == err {
+			_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+				return etx.SetElectionDetails(e, admin, title, candidates)
+			})
+		}
+	case "change-candidates":
+		var withdraw []int64
+		for _, value := range req.PostForm["withdraw"] {
+			var cid int64
+			if cid, err = strconv.ParseInt(value, 10, 64); nil != err {
+				break
+			}
+			withdraw = append(withdraw, cid)
+		}
+		add := candidatesFromNames(splitLines(req.PostFormValue("add")))
+		revote := "" != req.PostFormValue("revote")
+		if nil != err {
+			err = backend.ErrorInvalidCandidates
+		} else {
+			_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+				return etx.ChangeCandidates(e, admin, withdraw, add, revote)
+			})
+		}
+	case "ron":
+		enabled := "" != req.PostFormValue("ron")
+		_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			return etx.SetElectionRon(e, admin, enabled)
+		})
+	case "access":
+		public := "" != req.PostFormValue("public")
+		open := "" != req.PostFormValue("open")
+		editOpen := "" != req.PostFormValue("editopen")
+		_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			return etx.SetElectionAccess(e, admin, public, open, editOpen)
+		})
+	case "results-policy":
+		policy := backend.ResultsPolicy(req.PostFormValue("results"))
+		_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			return etx.SetElectionResultsPolicy(e, admin, policy)
+		})
+	case "unranked-policy":
+		policy := backend.UnrankedPolicy(req.PostFormValue("unranked"))
+		_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			return etx.SetElectionUnrankedPolicy(e, admin, policy)
+		})
+	case "schedule":
+		var opensAt, closesAt time.Time
+		if opensAt, err = parseScheduleInput(req.PostFormValue("opens_at")); nil != err {
+			err = backend.ErrorInvalidSchedule
+		} else if closesAt, err = parseScheduleInput(req.PostFormValue("closes_at")); nil != err {
+			err = backend.ErrorInvalidSchedule
+		} else {
+			_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+				return etx.SetElectionSchedule(e, admin, opensAt, closesAt)
+			})
+		}
+	case "close", "reopen":
+		closed := "close" == req.PostFormValue("action")
+		var e *backend.Election
+		if e, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			return etx.SetElectionClosed(e, admin, closed)
+		}); nil == err {
+			if closed {
+				f.Edb.Notify(e, backend.EventElectionClosed)
+			} else {
+				f.Edb.Notify(e, backend.EventElectionStarted)
+			}
+		}
+	case "nominations":
+		nominating := "" != req.PostFormValue("nominating")
+		var seconds int
+		var e *backend.Election
+		if seconds, err = parseSeconds(req.PostFormValue("seconds")); nil != err {
+			break
+		}
+		wasNominating := false
+		if e, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			wasNominating = e.Nominating
+			return etx.SetElectionNominations(e, admin, nominating, seconds)
+		}); nil == err && wasNominating && !nominating {
+			f.Edb.Notify(e, backend.EventElectionStarted)
+		}
+	case "decide-nomination":
+		nid, _ := strconv.ParseInt(req.PostFormValue("nomination"), 10, 64)
+		status := backend.NominationStatus(req.PostFormValue("status"))
+		_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			if n, err := etx.FindNomination(e, nid); nil != err {
+				return err
+			} else {
+				return etx.DecideNomination(e, admin, n, status)
+			}
+		})
+	case "add-member":
+		email := strings.TrimSpace(req.PostFormValue("email"))
+		group := strings.TrimSpace(req.PostFormValue("group"))
+		_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			if user, err := etx.FindUserByEmail(email); nil != err {
+				return err
+			} else {
+				return etx.AddElectionMember(e, user, group, admin)
+			}
+		})
+	case "remove-member":
+		email := req.PostFormValue("email")
+		_, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) error {
+			if user, err := etx.FindUserByEmail(email); nil != err {
+				return err
+			} else {
+				return etx.RemoveElectionMember(e, user, admin)
+			}
+		})
+	case "ballot-codes":
+		// GenerateBallotCodes rejects 0 (also if not a number)
+		count, _ := strconv.Atoi(req.PostFormValue("count"))
+		if !f.Edb.Features().BallotCodes {
+			err = backend.ErrorFeatureDisabled
+		} else {
+			var codes []string
+			var e *backend.Election
+			if e, err = update(func(etx *backend.ElectionsTx, admin *backend.User, e *backend.Election) (err error) {
+				codes, err = etx.GenerateBallotCodes(e, admin, count)
+				return
+			}); nil == err {
+				w.Header().Add("Content-Type", "text/html; charset=utf-8")
+				WriteBallotCodeSheet(w, voteURL(req, p, name), e, codes)
+				return 0
+			}
+		}
+	default:
+		http.Error(w, "Unknown action", 400)
+		return 0
+	}
+	return f.adminResult(w, req, form, err)
+}
+
+func (f Frontend) serveAdminElection(w http.ResponseWriter, req *http.Request, p paths, name string) {
+	data := adminElectionPage{page: page{paths: p}, Policies: backend.ResultsPolicies, UnrankedPolicies: backend.UnrankedPolicies, BallotCodes: f.Edb.Features().BallotCodes}
+	status := 200
+	if "POST" == req.Method {
+		if status = f.postAdminElection(w, req, p, name, &data.adminForm); 0 == status {
+			return
+		}
+		data.Form = req.PostForm
+	} else if !allowGet(w, req) {
+		return
+	}
+	data.Csrf = formToken(req)
+
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	if data.User = adminUser(w, etx, req); nil == data.User {
+		return
+	}
+	e := findElection(w, etx, name, data.User)
+	if nil == e {
+		return
+	}
+	data.Election = e
+	data.Title = electionTitle(e)
+	data.State = etx.ElectionState(e)
+	// the RON candidate isn't edited with the others
+	data.Candidates = nil
+	for _, c := range e.Candidates {
+		if !c.Reserved {
+			data.Candidates = append(data.Candidates, c)
+		}
+	}
+	if "details" == data.Form.Get("action") {
+		// show the rejected rows again
+		if candidates, err := parseCandidates(data.Form, false); nil == err {
+			data.Candidates = candidates
+		}
+	}
+	data.Candidates = append(data.Candidates, backend.Candidate{}, backend.Candidate{})
+	data.OpensAt = formatScheduleInput(e.OpensAt)
+	data.ClosesAt = formatScheduleInput(e.ClosesAt)
+	if data.Members, err = etx.ElectionMembers(e); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	} else if data.Nominations, err = etx.ElectionNominations(e); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	names, err := loadAdminAudit(etx)
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	entries, err := etx.AuditLog()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	for _, a := range entries {
+		if a.Eid == e.Eid && !isBallotAudit(a.Action) {
+			data.Audit = append(data.Audit, names.row(a))
+		}
+	}
+	render(w, status, "admin-election", data)
+}
+
+func (f Frontend) serveAdminAudit(w http.ResponseWriter, req *http.Request, p paths) {
+	if !allowGet(w, req) {
+		return
+	}
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	data := auditPage{page: page{paths: p}}
+	if data.User = adminUser(w, etx, req); nil == data.User {
+		return
+	}
+	names, err := loadAdminAudit(etx)
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	entries, err := etx.AuditLog()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	if err := backend.VerifyAuditChain(entries); nil != err {
+		data.Broken = err.Error()
+	} else {
+		data.Valid = true
+	}
+	for _, a := range entries {
+		data.Audit = append(data.Audit, names.row(a))
+	}
+	render(w, 200, "admin-audit", data)
+}
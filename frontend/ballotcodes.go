@@ -0,0 +1,25 @@
+package frontend
+
+import (
+	"github.com/stbuehler/go-vote/backend"
+	"io"
+)
+
+type ballotCodeSheet struct {
+	Title   string
+	VoteURL string
+	Codes   []string // formatted
+}
+
+// printable HTML sheet with one slip per ballot code; voteURL should
+// point to the election page
+func WriteBallotCodeSheet(w io.Writer, voteURL string, e *backend.Election, codes []string) error {
+	sheet := ballotCodeSheet{Title: e.Title, VoteURL: voteURL}
+	if 0 == len(sheet.Title) {
+		sheet.Title = e.Name
+	}
+	for _, code := range codes {
+		sheet.Codes = append(sheet.Codes, backend.FormatBallotCode(code))
+	}
+	return templates.ExecuteTemplate(w, "ballotcodes", sheet)
+}
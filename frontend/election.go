@@ -0,0 +1,171 @@
+package frontend
+
+import (
+	"github.com/stbuehler/go-vote/backend"
+	"github.com/stbuehler/go-vote/types"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// candidate for the templates and the ballot script
+type pageCandidate struct {
+	Name        string        `json:"name"`
+	Description template.HTML `json:"description,omitempty"` // rendered Markdown
+	Url         string        `json:"url,omitempty"`
+	Image       string        `json:"image,omitempty"`
+	Reserved    bool          `json:"reserved,omitempty"` // RON
+}
+
+func newPageCandidate(c backend.Candidate) pageCandidate {
+	result := pageCandidate{Name: c.Name, Url: c.Url, Image: c.Image, Reserved: c.Reserved}
+	if 0 != len(c.Description) {
+		result.Description = renderMarkdown(c.Description)
+	}
+	return result
+}
+
+func pageCandidates(candidates []backend.Candidate) []pageCandidate {
+	result := make([]pageCandidate, len(candidates))
+	for i, c := range candidates {
+		result[i] = newPageCandidate(c)
+	}
+	return result
+}
+
+type electionPage struct {
+	page
+	Election       *backend.Election
+	Candidates     []pageCandidate
+	BallotCodes    bool
+	RankGroups     types.RankGroups
+	Ranks          []int // rank (from 1, or types.Unranked) of each candidate for the drop-downs
+	RankOptions    []int
+	ResultsVisible bool
+	Results        *pageResults         // nil if not visible
+	Review         backend.BallotReview // the candidates changed after the user voted
+	Csrf           string               // form token (see formToken); "" if not logged in
+
+	// posted form
+	Name    string
+	Code    string
+	Error   backend.Error // empty Message: no error
+	Receipt *types.Receipt
+}
+
+type turnoutPage struct {
+	page
+	Election *backend.Election
+	Title    string
+}
+
+// ranks (from 1) for the drop-downs; candidates not in any group are
+// unranked
+func ranksFromGroups(rankGroups types.RankGroups, numCandidates int) []int {
+	ranks := make([]int, numCandidates)
+	for candidate := range ranks {
+		ranks[candidate] = types.Unranked
+	}
+	for rank, g := range rankGroups {
+		for _, candidate := range g {
+			ranks[candidate] = rank + 1
+		}
+	}
+	return ranks
+}
+
+// ranks from the "rank-0", "rank-1", ... form fields; the number of
+// candidates is checked when voting
+func parseRanks(req *http.Request) ([]int, error) {
+	var ranks []int
+	for candidate := 0; ; candidate++ {
+		values, ok := req.PostForm["rank-"+strconv.Itoa(candidate)]
+		if !ok {
+			return ranks, nil
+		} else if rank, err := strconv.Atoi(values[0]); nil != err {
+			return nil, backend.ErrorInvalidRanking
+		} else {
+			ranks = append(ranks, rank)
+		}
+	}
+}
+
+func (f Frontend) serveElection(w http.ResponseWriter, req *http.Request, p paths, name string) {
+	data := electionPage{page: page{paths: p}, BallotCodes: f.Edb.Features().BallotCodes}
+	status := 200
+	if "POST" == req.Method {
+		// form ballot (without JavaScript, or with the login cookie). other
+		// sites must not cast ballots with the login cookie; the name or
+		// ballot code of anonymous ballots is part of the form anyway
+		if 0 != len(loginToken(req)) && !checkFormToken(w, req) {
+			return
+		}
+		data.Name = req.PostFormValue("name")
+		data.Code = req.PostFormValue("code")
+		var err error
+		if data.Ranks, err = parseRanks(req); nil == err {
+			data.Receipt, err = f.Edb.Vote(name, loginToken(req), data.Name, data.Code, types.RankGroupsFromRanks(data.Ranks))
+		}
+		if nil != err {
+			var e *backend.Error
+			status, e = backend.RequestError(req, err)
+			data.Error = *e
+		}
+	} else if !allowGet(w, req) {
+		return
+	}
+	data.Csrf = formToken(req)
+
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	data.User = loginUser(etx, req)
+	e := findElection(w, etx, name, data.User)
+	if nil == e {
+		return
+	}
+	data.Election = e
+	if data.Review, err = etx.BallotReview(data.User, e); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	data.Candidates = pageCandidates(e.Candidates)
+	data.RankGroups = types.RankGroups{}.Sanitize(len(e.Candidates))
+	if len(data.Ranks) != len(e.Candidates) {
+		data.Ranks = ranksFromGroups(data.RankGroups, len(e.Candidates))
+	}
+	for rank := 1; rank <= len(e.Candidates); rank++ {
+		data.RankOptions = append(data.RankOptions, rank)
+	}
+	if data.ResultsVisible, err = etx.CanSeeResults(data.User, e); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	} else if data.ResultsVisible {
+		if data.Results, err = electionResults(etx, e); nil != err {
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+	render(w, status, "election", data)
+}
+
+func (f Frontend) serveTurnout(w http.ResponseWriter, req *http.Request, p paths, name string) {
+	if !allowGet(w, req) {
+		return
+	}
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	user := loginUser(etx, req)
+	if e := findElection(w, etx, name, user); nil != e {
+		render(w, 200, "turnout", turnoutPage{page: page{paths: p, User: user}, Election: e, Title: electionTitle(e)})
+	}
+}
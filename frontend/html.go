@@ -1,85 +1,125 @@
 package frontend
 
 import (
-	"fmt"
 	"github.com/stbuehler/go-vote/backend"
 	"github.com/stbuehler/go-vote/static"
-	"github.com/stbuehler/go-vote/types"
+	"log"
 	"net/http"
+	"strings"
 )
 
+/* pages:
+ *
+ *   /                     public elections; dashboard of logged-in users
+ *   /login, /logout       token login (stored in a cookie)
+ *   /e/{name}             election page with ballot
+ *   /e/{name}/results     results (like after the election closed)
+ *   /e/{name}/turnout     turnout for election managers
+ *   /e/{name}/nominations nominations; members nominate candidates
+ *   /admin/...            admin pages, see admin.go
+ */
+
 type Frontend struct {
 	Edb backend.ElectionsDb
 }
 
+type paths struct {
+	Prefix         string
+	PathSortableJS string
+	PathVoteJS     string
+	PathApiJS      string
+	PathTurnoutJS  string
+	PathVoteCSS    string
+}
+
+// common page data
+type page struct {
+	paths
+	User *backend.User // logged in user, if any
+}
+
 func (f Frontend) BindServeMux(mux *http.ServeMux, prefix string) {
 	path := prefix + "/e/"
 
-	pathSortableJS := static.PathSortableJS(prefix)
-	pathVoteJS := static.PathVoteJS(prefix)
-	pathApiJS := static.PathApiJS(prefix)
-	pathVoteCSS := static.PathVoteCSS(prefix)
+	p := paths{
+		Prefix:         prefix,
+		PathSortableJS: static.PathSortableJS(prefix),
+		PathVoteJS:     static.PathVoteJS(prefix),
+		PathApiJS:      static.PathApiJS(prefix),
+		PathTurnoutJS:  static.PathTurnoutJS(prefix),
+		PathVoteCSS:    static.PathVoteCSS(prefix),
+	}
 
+	mux.HandleFunc(prefix+"/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != prefix+"/" {
+			http.NotFound(w, req)
+		} else {
+			f.serveIndex(w, req, p)
+		}
+	})
+	mux.HandleFunc(prefix+"/login", func(w http.ResponseWriter, req *http.Request) {
+		f.serveLogin(w, req, p)
+	})
+	mux.HandleFunc(prefix+"/logout", func(w http.ResponseWriter, req *http.Request) {
+		f.serveLogout(w, req, p)
+	})
+	mux.HandleFunc(prefix+"/admin", func(w http.ResponseWriter, req *http.Request) {
+		f.serveAdmin(w, req, p)
+	})
+	mux.HandleFunc(prefix+"/admin/audit", func(w http.ResponseWriter, req *http.Request) {
+		f.serveAdminAudit(w, req, p)
+	})
+	mux.HandleFunc(prefix+"/admin/e/", func(w http.ResponseWriter, req *http.Request) {
+		f.serveAdminElection(w, req, p, req.URL.Path[len(prefix+"/admin/e/"):])
+	})
 	mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
 		electionName := req.URL.Path[len(path):]
-		if etx, err := f.Edb.StartTransaction(); nil != err {
-			http.Error(w, "Internal server error", 500)
+		if strings.HasSuffix(electionName, "/turnout") {
+			f.serveTurnout(w, req, p, strings.TrimSuffix(electionName, "/turnout"))
+		} else if strings.HasSuffix(electionName, "/nominations") {
+			f.serveNominations(w, req, p, strings.TrimSuffix(electionName, "/nominations"))
+		} else if strings.HasSuffix(electionName, "/results") {
+			f.serveResults(w, req, p, strings.TrimSuffix(electionName, "/results"))
 		} else {
-			defer etx.Rollback()
+			f.serveElection(w, req, p, electionName)
+		}
+	})
+}
 
-			if e := etx.FindElectionByName(electionName, nil); nil == e {
-				http.Error(w, "Election not found", 404)
-			} else {
-				w.Header().Add("Content-Type", "text/html; charset=utf-8")
-				fmt.Fprintf(w, `<!DOCTYPE html>
-<html>
-<head>
-  <meta charset="utf-8">
-  <title>Vote</title>
+// GET (and HEAD) only; otherwise responds with 405
+func allowGet(w http.ResponseWriter, req *http.Request) bool {
+	if "GET" != req.Method && "HEAD" != req.Method {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", 405)
+		return false
+	}
+	return true
+}
 
-  <script src="%s"></script>
-  <script src="%s"></script>
-  <script src="%s"></script>
-  <link rel="stylesheet" href="%s">
-</head>
-<body style="text-align: center;">
-  <div style="display: inline-block; text-align: left;">
-    <div class="block" id="vote-block">
-      <h2>Rank according to your preferences</h2>
-      <p>Choices in the same block have equal preference. Choices in blocks at the top are preferred over choices in lower blocks.</p>
-      <div id="vote"></div>
-      <p><label>Name: <input id="voter" type="text" size="30"></input></label></p>
-      <p><button id="submit-vote">Submit</button></p>
-    </div>
-    <div class="block" id="result-block">
-      <h2>Result</h2>
-      <p><button id="submit-result">Reload</button></p>
-      <div id="result"></div>
-    </div>
-  </div>
-  <script>//<![CDATA[
+// election visible to user; responds with an error page if not found
+func findElection(w http.ResponseWriter, etx *backend.ElectionsTx, name string, user *backend.User) *backend.Election {
+	if e, err := etx.FindElectionByName(name, user); backend.ErrorElectionNotFound == err {
+		http.Error(w, "Election not found", 404)
+		return nil
+	} else if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return nil
+	} else {
+		return e
+	}
+}
 
-(function() {
-  var prefix = %s;
-  var electionName = %s;
-  var choices = %s;
-  var rankGroups = %s;
-  setup(prefix, electionName, choices, rankGroups);
-})();
+func electionTitle(e *backend.Election) string {
+	if 0 == len(e.Title) {
+		return e.Name
+	}
+	return e.Title
+}
 
-  //]]></script>
-</body>
-</html>`,
-					pathSortableJS,
-					pathVoteJS,
-					pathApiJS,
-					pathVoteCSS,
-					types.JsonMustEncodeString(prefix),
-					types.JsonMustEncodeString(electionName),
-					types.JsonMustEncodeString(e.Candidates),
-					types.JsonMustEncodeString(types.RankGroups{}.Sanitize(len(e.Candidates))),
-				)
-			}
-		}
-	})
+func render(w http.ResponseWriter, status int, name string, data interface{}) {
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, name, data); nil != err {
+		log.Printf("Rendering %s failed: %v", name, err)
+	}
 }
@@ -0,0 +1,39 @@
+package frontend
+
+import (
+	"github.com/stbuehler/go-vote/backend"
+	"net/http"
+)
+
+type indexPage struct {
+	page
+	Mine   []backend.ElectionOverview // elections the user can vote in
+	Public []backend.ElectionOverview // other public elections
+}
+
+func (f Frontend) serveIndex(w http.ResponseWriter, req *http.Request, p paths) {
+	if !allowGet(w, req) {
+		return
+	}
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	data := indexPage{page: page{paths: p, User: loginUser(etx, req)}}
+	overviews, err := etx.ElectionOverviews(data.User)
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	for _, o := range overviews {
+		if o.CanVote {
+			data.Mine = append(data.Mine, o)
+		} else if o.Election.Public {
+			data.Public = append(data.Public, o)
+		}
+	}
+	render(w, 200, "index", data)
+}
@@ -0,0 +1,136 @@
+package frontend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stbuehler/go-vote/backend"
+	"net/http"
+	"net/url"
+)
+
+/* users log in with their token, which is stored in a cookie. the cookie
+ * is SameSite=Lax: browsers don't send it with forms posted from other
+ * sites, so the ballot form can use it.
+ *
+ * admin and nomination forms don't rely on that alone: they carry a form
+ * token derived from the login token, which other sites can't know.
+ */
+
+const tokenCookie = "vote_token"
+
+type loginPage struct {
+	page
+	Error string
+}
+
+func loginToken(req *http.Request) string {
+	if c, err := req.Cookie(tokenCookie); nil != err {
+		return ""
+	} else if token, err := url.QueryUnescape(c.Value); nil != err {
+		return ""
+	} else {
+		return token
+	}
+}
+
+// "" if not logged in
+func formToken(req *http.Request) string {
+	token := loginToken(req)
+	if 0 == len(token) {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("go-vote form"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// whether the posted form has the form token of the login cookie; responds
+// with 403 if not
+func checkFormToken(w http.ResponseWriter, req *http.Request) bool {
+	if expected := formToken(req); 0 == len(expected) || !hmac.Equal([]byte(expected), []byte(req.PostFormValue("csrf"))) {
+		http.Error(w, "Invalid form, reload the page and try again", 403)
+		return false
+	}
+	return true
+}
+
+// user of the login cookie; nil if not logged in or the token is unknown
+func loginUser(etx *backend.ElectionsTx, req *http.Request) *backend.User {
+	if token := loginToken(req); 0 == len(token) {
+		return nil
+	} else if user, err := etx.FindUserByToken(token); nil != err {
+		return nil
+	} else {
+		return user
+	}
+}
+
+func setTokenCookie(w http.ResponseWriter, req *http.Request, p paths, token string) {
+	c := &http.Cookie{
+		Name:     tokenCookie,
+		Value:    url.QueryEscape(token),
+		Path:     p.Prefix + "/",
+		Secure:   nil != req.TLS,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if 0 == len(token) {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// set the cookie, and use the new token for the rest of the request too
+func replaceTokenCookie(w http.ResponseWriter, req *http.Request, p paths, token string) {
+	setTokenCookie(w, req, p, token)
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if tokenCookie != c.Name {
+			req.AddCookie(c)
+		}
+	}
+	req.AddCookie(&http.Cookie{Name: tokenCookie, Value: url.QueryEscape(token)})
+}
+
+func (f Frontend) serveLogin(w http.ResponseWriter, req *http.Request, p paths) {
+	if "POST" != req.Method {
+		if allowGet(w, req) {
+			render(w, 200, "login", loginPage{page: page{paths: p}})
+		}
+		return
+	}
+
+	token := req.PostFormValue("token")
+	err := f.Edb.Retry(func() error {
+		if 0 == len(token) {
+			return backend.ErrorUserNotFound
+		}
+		etx, err := f.Edb.StartTransaction()
+		if nil != err {
+			return err
+		}
+		defer etx.Rollback()
+
+		_, err = etx.FindUserByToken(token)
+		return err
+	})
+	if nil != err {
+		status, e := backend.RequestError(req, err)
+		render(w, status, "login", loginPage{page: page{paths: p}, Error: e.Message})
+		return
+	}
+	setTokenCookie(w, req, p, token)
+	http.Redirect(w, req, p.Prefix+"/", 303)
+}
+
+func (f Frontend) serveLogout(w http.ResponseWriter, req *http.Request, p paths) {
+	if "POST" != req.Method {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	setTokenCookie(w, req, p, "")
+	http.Redirect(w, req, p.Prefix+"/", 303)
+}
@@ -0,0 +1,105 @@
+package frontend
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+/* a small Markdown subset for candidate descriptions: paragraphs
+ * (separated by blank lines), lists ("- " or "* "), *emphasis*,
+ * **strong**, `code` and [links](https://...). everything else is shown
+ * as text; all text is escaped.
+ */
+
+func renderMarkdown(text string) template.HTML {
+	var out strings.Builder
+	var paragraph, list []string
+	flush := func() {
+		if 0 != len(paragraph) {
+			out.WriteString("<p>" + renderInline(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = nil
+		}
+		if 0 != len(list) {
+			out.WriteString("<ul>\n")
+			for _, item := range list {
+				out.WriteString("<li>" + renderInline(item) + "</li>\n")
+			}
+			out.WriteString("</ul>\n")
+			list = nil
+		}
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if 0 == len(trimmed) {
+			flush()
+		} else if strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") {
+			if 0 != len(paragraph) {
+				flush()
+			}
+			list = append(list, trimmed[2:])
+		} else if 0 != len(list) && line != trimmed {
+			// indented continuation of the last item
+			list[len(list)-1] += "\n" + trimmed
+		} else {
+			if 0 != len(list) {
+				flush()
+			}
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+	return template.HTML(out.String())
+}
+
+// only absolute http(s) and mailto links
+func safeLink(target string) bool {
+	u, err := url.Parse(target)
+	return nil == err && ("http" == u.Scheme || "https" == u.Scheme || "mailto" == u.Scheme)
+}
+
+func renderInline(text string) string {
+	var out strings.Builder
+	for 0 != len(text) {
+		if strings.HasPrefix(text, "`") {
+			if end := strings.Index(text[1:], "`"); -1 != end {
+				out.WriteString("<code>" + template.HTMLEscapeString(text[1:1+end]) + "</code>")
+				text = text[2+end:]
+				continue
+			}
+		} else if strings.HasPrefix(text, "**") {
+			if end := strings.Index(text[2:], "**"); end > 0 {
+				out.WriteString("<strong>" + renderInline(text[2:2+end]) + "</strong>")
+				text = text[4+end:]
+				continue
+			}
+		} else if strings.HasPrefix(text, "*") {
+			if end := strings.Index(text[1:], "*"); end > 0 {
+				out.WriteString("<em>" + renderInline(text[1:1+end]) + "</em>")
+				text = text[2+end:]
+				continue
+			}
+		} else if strings.HasPrefix(text, "[") {
+			if mid := strings.Index(text, "]("); -1 != mid {
+				if end := strings.Index(text[mid:], ")"); -1 != end {
+					target := text[mid+2 : mid+end]
+					if safeLink(target) {
+						out.WriteString(`<a href="` + template.HTMLEscapeString(target) + `">` + renderInline(text[1:mid]) + "</a>")
+						text = text[mid+end+1:]
+						continue
+					}
+				}
+			}
+		}
+		// plain text up to the next possible markup
+		next := strings.IndexAny(text[1:], "`*[")
+		if -1 == next {
+			next = len(text)
+		} else {
+			next++
+		}
+		out.WriteString(template.HTMLEscapeString(text[:next]))
+		text = text[next:]
+	}
+	return out.String()
+}
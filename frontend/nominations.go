@@ -0,0 +1,110 @@
+package frontend
+
+import (
+	"github.com/stbuehler/go-vote/backend"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* nominations page: everyone who can see the election sees the
+ * nominations; logged-in members nominate candidates and second
+ * nominations during the nomination phase.
+ */
+
+type pageNomination struct {
+	backend.Nomination
+	Details pageCandidate
+}
+
+type nominationsPage struct {
+	page
+	adminForm
+	Election    *backend.Election
+	Title       string
+	State       backend.ElectionState
+	CanNominate bool
+	Nominations []pageNomination
+}
+
+func (f Frontend) postNominations(w http.ResponseWriter, req *http.Request, name string, form *adminForm) int {
+	if !checkFormToken(w, req) {
+		return 0
+	}
+	err := f.Edb.Retry(func() error {
+		etx, err := f.Edb.StartTransaction()
+		if nil != err {
+			return err
+		}
+		defer etx.Rollback()
+
+		user := loginUser(etx, req)
+		e, err := etx.FindElectionByName(name, user)
+		if nil != err {
+			return err
+		}
+		switch req.PostFormValue("action") {
+		case "nominate":
+			err = etx.Nominate(e, user, &backend.Nomination{
+				Name:        strings.TrimSpace(req.PostFormValue("name")),
+				Description: strings.TrimSpace(req.PostFormValue("description")),
+				Url:         strings.TrimSpace(req.PostFormValue("url")),
+				Image:       strings.TrimSpace(req.PostFormValue("image")),
+			})
+		case "second":
+			nid, _ := strconv.ParseInt(req.PostFormValue("nomination"), 10, 64)
+			var n *backend.Nomination
+			if n, err = etx.FindNomination(e, nid); nil == err {
+				err = etx.SecondNomination(e, user, n)
+			}
+		default:
+			err = backend.ErrorNotFound
+		}
+		if nil != err {
+			return err
+		}
+		return etx.Commit()
+	})
+	return f.adminResult(w, req, form, err)
+}
+
+func (f Frontend) serveNominations(w http.ResponseWriter, req *http.Request, p paths, name string) {
+	data := nominationsPage{page: page{paths: p}}
+	status := 200
+	if "POST" == req.Method {
+		if status = f.postNominations(w, req, name, &data.adminForm); 0 == status {
+			return
+		}
+		data.Form = req.PostForm
+	} else if !allowGet(w, req) {
+		return
+	}
+	data.Csrf = formToken(req)
+
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	data.User = loginUser(etx, req)
+	e := findElection(w, etx, name, data.User)
+	if nil == e {
+		return
+	}
+	data.Election = e
+	data.Title = electionTitle(e)
+	data.State = etx.ElectionState(e)
+	data.CanNominate = nil == etx.CanNominate(data.User, e)
+	nominations, err := etx.ElectionNominations(e)
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	for _, n := range nominations {
+		details := newPageCandidate(backend.Candidate{Name: n.Name, Description: n.Description, Url: n.Url, Image: n.Image})
+		data.Nominations = append(data.Nominations, pageNomination{Nomination: n, Details: details})
+	}
+	render(w, status, "nominations", data)
+}
@@ -0,0 +1,125 @@
+package frontend
+
+import (
+	"github.com/stbuehler/go-vote/backend"
+	"github.com/stbuehler/go-vote/types"
+	"net/http"
+)
+
+type winningCell struct {
+	Value int
+	Class string // "self", "win", "loose" or ""
+}
+
+type winningRow struct {
+	Candidate string
+	Cells     []winningCell
+}
+
+type winningTable struct {
+	Candidates []string
+	Rows       []winningRow
+}
+
+type pageResults struct {
+	HasWinner   bool
+	Winner      string
+	Preferences winningTable
+	Paths       *winningTable // only if there is no Condorcet winner
+	Ron         string        // name of the RON candidate, if any
+	Failed      bool          // RON won
+	Ineligible  []string      // candidates ranked below RON
+}
+
+type resultsPage struct {
+	page
+	Election   *backend.Election
+	Candidates []pageCandidate
+	Title      string
+	State      backend.ElectionState
+	Results    *pageResults // nil if not visible
+}
+
+func makeWinningTable(candidates []string, numbers [][]int) winningTable {
+	table := winningTable{Candidates: candidates}
+	for i := range candidates {
+		row := winningRow{Candidate: candidates[i]}
+		for j := range candidates {
+			cell := winningCell{Value: numbers[i][j]}
+			if i == j {
+				cell.Class = "self"
+			} else if diff := numbers[i][j] - numbers[j][i]; diff > 0 {
+				cell.Class = "win"
+			} else if diff < 0 {
+				cell.Class = "loose"
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// ron: index of the RON candidate, -1 if there is none
+func makePageResults(candidates []string, prefs types.PairwisePreferences, ron int) *pageResults {
+	results := &pageResults{Preferences: makeWinningTable(candidates, prefs)}
+	r := prefs.Result(ron)
+	if nil != r.Paths {
+		table := makeWinningTable(candidates, r.Paths)
+		results.Paths = &table
+	}
+	if -1 != r.Winner {
+		results.HasWinner = true
+		results.Winner = candidates[r.Winner]
+	}
+	if -1 != ron {
+		results.Ron = candidates[ron]
+		results.Failed = r.Failed
+		for _, candidate := range r.Ineligible {
+			results.Ineligible = append(results.Ineligible, candidates[candidate])
+		}
+	}
+	return results
+}
+
+// results of an election the user can see results of; commits etx
+// (counting might initialise the tally)
+func electionResults(etx *backend.ElectionsTx, e *backend.Election) (*pageResults, error) {
+	if prefs, err := etx.ElectionResults(e); nil != err {
+		return nil, err
+	} else if err := etx.Commit(); nil != err {
+		return nil, err
+	} else {
+		return makePageResults(backend.CandidateNames(e.Candidates), prefs, e.Ron()), nil
+	}
+}
+
+func (f Frontend) serveResults(w http.ResponseWriter, req *http.Request, p paths, name string) {
+	if !allowGet(w, req) {
+		return
+	}
+	etx, err := f.Edb.StartTransaction()
+	if nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer etx.Rollback()
+
+	user := loginUser(etx, req)
+	e := findElection(w, etx, name, user)
+	if nil == e {
+		return
+	}
+	data := resultsPage{page: page{paths: p, User: user}, Election: e, Candidates: pageCandidates(e.Candidates), Title: electionTitle(e), State: etx.ElectionState(e)}
+	status := 200
+	if visible, err := etx.CanSeeResults(user, e); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	} else if !visible {
+		status = 403
+	} else if data.Results, err = electionResults(etx, e); nil != err {
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	render(w, status, "results", data)
+}
@@ -0,0 +1,673 @@
+package frontend
+
+import (
+	"github.com/stbuehler/go-vote/backend"
+	"html/template"
+	"net/url"
+)
+
+/* all pages are rendered with html/template; values in scripts are
+ * encoded as JavaScript by the template engine.
+ *
+ * the election page works without JavaScript: the ballot is a form with a
+ * rank drop-down per candidate, and the results are rendered on the server.
+ * with JavaScript the drag-and-drop ballot replaces the drop-downs (body
+ * class "js"), unless the voter switches back to them.
+ */
+
+type overviewList struct {
+	Prefix    string
+	Elections []backend.ElectionOverview
+}
+
+type policyList struct {
+	Policies []backend.ResultsPolicy
+	Selected backend.ResultsPolicy
+}
+
+type unrankedPolicyList struct {
+	Policies []backend.UnrankedPolicy
+	Selected backend.UnrankedPolicy
+}
+
+var templateFuncs = template.FuncMap{
+	"electionUrl": func(prefix, name string) string {
+		return prefix + "/e/" + url.PathEscape(name)
+	},
+	"overviews": func(prefix string, elections []backend.ElectionOverview) overviewList {
+		return overviewList{Prefix: prefix, Elections: elections}
+	},
+	"pathEscape": url.PathEscape,
+	"policies": func(policies []backend.ResultsPolicy, selected string) policyList {
+		return policyList{Policies: policies, Selected: backend.ResultsPolicy(selected)}
+	},
+	"unrankedPolicies": func(policies []backend.UnrankedPolicy, selected string) unrankedPolicyList {
+		return unrankedPolicyList{Policies: policies, Selected: backend.UnrankedPolicy(selected)}
+	},
+}
+
+var templates = template.Must(template.New("").Funcs(templateFuncs).Parse(`
+{{define "head"}}
+<head>
+  <meta charset="utf-8">
+  <title>{{.}}</title>
+{{end}}
+
+{{define "nav"}}
+<div class="nav">
+  <a href="{{.Prefix}}/">Elections</a>
+  {{- if and .User .User.SiteAdmin}}
+  <a href="{{.Prefix}}/admin">Admin</a>
+  {{- end}}
+  {{- if .User}}
+  <form method="post" action="{{.Prefix}}/logout">Logged in as {{.User.Name}} <button type="submit">Log out</button></form>
+  {{- else}}
+  <a href="{{.Prefix}}/login">Log in</a>
+  {{- end}}
+</div>
+{{end}}
+
+{{define "overviews"}}
+<table class="elections">
+  <tr><th>Election</th><th>Status</th><th>Results</th></tr>
+  {{- range .Elections}}
+  <tr>
+    <td><a href="{{electionUrl $.Prefix .Election.Name}}">{{with .Election.Title}}{{.}}{{else}}{{.Election.Name}}{{end}}</a></td>
+    <td>{{if eq "nominating" .State}}<a href="{{electionUrl $.Prefix .Election.Name}}/nominations">nominating</a>{{else}}{{.State}}{{end}}{{if .Voted}}, voted{{end}}</td>
+    <td>{{if .ResultsVisible}}<a href="{{electionUrl $.Prefix .Election.Name}}/results">{{if eq "closed" .State}}Results{{else}}Current results{{end}}</a>{{end}}</td>
+  </tr>
+  {{- end}}
+</table>
+{{end}}
+
+{{define "index"}}<!DOCTYPE html>
+<html>
+{{- template "head" "Elections"}}
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    {{- if .User}}
+    <h2>Your elections</h2>
+    {{- if .Mine}}
+    {{- template "overviews" (overviews .Prefix .Mine)}}
+    {{- else}}
+    <p>You can't vote in any election.</p>
+    {{- end}}
+    {{- end}}
+    <h2>{{if .User}}Other public elections{{else}}Public elections{{end}}</h2>
+    {{- if .Public}}
+    {{- template "overviews" (overviews .Prefix .Public)}}
+    {{- else}}
+    <p>No elections.</p>
+    {{- end}}
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "login"}}<!DOCTYPE html>
+<html>
+{{- template "head" "Log in"}}
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    <h2>Log in</h2>
+    <form method="post" action="">
+      <p><label>Token: <input name="token" type="password" size="30"{{if .Error}} class="invalid"{{end}}></input></label></p>
+      <p><button type="submit">Log in</button></p>
+      {{- with .Error}}
+      <p class="error">{{.}}</p>
+      {{- end}}
+    </form>
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "results"}}<!DOCTYPE html>
+<html>
+{{- template "head" (print "Results: " .Title)}}
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    <h2>Results: <a href="{{electionUrl .Prefix .Election.Name}}">{{.Title}}</a></h2>
+    <p>The election is {{.State}}. {{.Election.Results.Description}}</p>
+    {{- with .Results}}
+    {{- template "result" .}}
+    {{- else}}
+    <p class="error">Results are not available.</p>
+    {{- end}}
+    <h3>Candidates</h3>
+    {{- range .Candidates}}
+    <div class="candidate">
+      <h4>{{.Name}}</h4>
+      {{- template "candidate-details" .}}
+    </div>
+    {{- end}}
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "candidate-details"}}
+      {{- with .Image}}
+      <img src="{{.}}" alt="">
+      {{- end}}
+      {{- .Description}}
+      {{- with .Url}}
+      <p><a href="{{.}}" target="_blank">{{.}}</a></p>
+      {{- end}}
+{{- end}}
+
+{{define "result"}}
+{{- if .Failed}}
+<p class="error">The election failed: {{.Ron}} won.</p>
+{{- else}}
+<p>{{if .HasWinner}}The winner is: {{.Winner}}{{else}}There is no winner{{end}}</p>
+{{- end}}
+{{- with .Ineligible}}
+<p>Ineligible (ranked below {{$.Ron}}): {{range $i, $c := .}}{{if $i}}, {{end}}{{$c}}{{end}}</p>
+{{- end}}
+<p>How often row wins over column:</p>
+{{- template "winning" .Preferences}}
+{{- with .Paths}}
+<p>Strengths of strongest paths for Schwarz method:</p>
+{{- template "winning" .}}
+{{- end}}
+{{end}}
+
+{{define "winning"}}
+<table class="winning">
+  <tr>
+    <th></th>
+    {{- range .Candidates}}
+    <th>{{.}}</th>
+    {{- end}}
+  </tr>
+  {{- range .Rows}}
+  <tr>
+    <th>{{.Candidate}}</th>
+    {{- range .Cells}}
+    <td class="{{.Class}}">{{if ne "self" .Class}}{{.Value}}{{end}}</td>
+    {{- end}}
+  </tr>
+  {{- end}}
+</table>
+{{end}}
+
+{{define "election"}}<!DOCTYPE html>
+<html>
+{{- template "head" "Vote"}}
+  <script src="{{.PathSortableJS}}"></script>
+  <script src="{{.PathVoteJS}}"></script>
+  <script src="{{.PathApiJS}}"></script>
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    <form class="block" id="vote-block" method="post" action="">
+      {{- if .Csrf}}
+      <input type="hidden" name="csrf" value="{{.Csrf}}">
+      {{- end}}
+      <h2>Rank according to your preferences</h2>
+      {{- if .Election.Nominating}}
+      <p class="error">Candidates are being nominated; voting starts after the <a href="{{electionUrl .Prefix .Election.Name}}/nominations">nomination phase</a>.</p>
+      {{- end}}
+      {{- if eq "adjusted" .Review}}
+      <p class="error">The candidates changed after you voted: withdrawn candidates were removed from your ballot and new candidates ranked last (or left unranked on partial ballots).{{if not .Election.Secret}} Please review your ballot and vote again to change it.{{end}}</p>
+      {{- else if eq "revote" .Review}}
+      <p class="error">The candidates changed after you voted and your ballot was reset; please vote again.</p>
+      {{- end}}
+      <p>{{.Election.Unranked.Description}}</p>
+      <div class="js-only">
+        <p>Choices in the same block have equal preference. Choices in blocks at the top are preferred over choices in lower blocks. Choices you move to "Not ranked" are left off your ballot.</p>
+        <div id="vote"></div>
+        <p><a href="#" id="use-form">Rank with drop-down lists instead</a></p>
+      </div>
+      <div class="no-js">
+        <p>Give each choice a rank; choices with rank 1 are preferred most. Choices with the same rank have equal preference. Choices you don't rank are left off your ballot.</p>
+        <table id="vote-form"{{if eq "rankgroups" .Error.Field}} class="invalid"{{end}}>
+          {{- range $candidate, $c := .Candidates}}
+          <tr>
+            <td><label for="rank-{{$candidate}}">{{$c.Name}}</label>
+              {{- if or $c.Description $c.Url $c.Image}}
+              <details class="candidate">
+                <summary>Details</summary>
+                {{- template "candidate-details" $c}}
+              </details>
+              {{- end}}
+            </td>
+            <td><select id="rank-{{$candidate}}" name="rank-{{$candidate}}">
+              {{- $selected := index $.Ranks $candidate}}
+              {{- range $.RankOptions}}
+              <option{{if eq . $selected}} selected{{end}}>{{.}}</option>
+              {{- end}}
+              <option value="-1"{{if eq -1 $selected}} selected{{end}}>not ranked</option>
+            </select></td>
+          </tr>
+          {{- end}}
+        </table>
+      </div>
+      {{- with .User}}
+      <p>Voting as {{.Name}}.</p>
+      {{- end}}
+      <p{{if .User}} style="display: none;"{{end}}><label>Name: <input id="voter" name="name" type="text" size="30" value="{{.Name}}"{{if eq "auth.name" .Error.Field}} class="invalid"{{end}}></input></label></p>
+      <p{{if or .User (not .BallotCodes)}} style="display: none;"{{end}}><label>Ballot code (if you got one): <input id="ballot-code" name="code" type="text" size="20" value="{{.Code}}"{{if eq "auth.code" .Error.Field}} class="invalid"{{end}}></input></label></p>
+      <p><button id="submit-vote" type="submit">Submit</button></p>
+      <p id="vote-status"{{if .Error.Message}} class="error"{{else if .Receipt}} class="success"{{end}}>
+        {{- if .Error.Message}}{{.Error.Message}}{{else if .Receipt}}Your vote was recorded.{{end -}}
+      </p>
+      <p>Keep your receipt to verify your ballot after the election closed: <span id="receipt">
+        {{- with .Receipt}}Ballot {{.Ballot}} (hash {{.Hash}}){{end -}}
+      </span></p>
+    </form>
+    <div class="block" id="result-block">
+      <h2>Result</h2>
+      <p>{{.Election.Results.Description}}</p>
+      <p class="js-only"><button id="submit-result">Reload</button></p>
+      <div id="result">
+        {{- with .Results}}
+        {{- template "result" .}}
+        {{- end}}
+      </div>
+    </div>
+  </div>
+  <script>
+(function() {
+  var prefix = {{.Prefix}};
+  var electionName = {{.Election.Name}};
+  var choices = {{.Candidates}};
+  var rankGroups = {{.RankGroups}};
+  var resultsVisible = {{.ResultsVisible}};
+  var loggedIn = {{if .User}}true{{else}}false{{end}};
+  setup(prefix, electionName, choices, rankGroups, resultsVisible, loggedIn);
+})();
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "turnout"}}<!DOCTYPE html>
+<html>
+{{- template "head" "Turnout"}}
+  <script src="{{.PathVoteJS}}"></script>
+  <script src="{{.PathTurnoutJS}}"></script>
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    <h2>Turnout: {{.Title}}</h2>
+    <p><label>Token: <input id="token" type="password" size="30"></input></label> <button id="submit-token">Show</button></p>
+    <p><button id="submit-reload">Reload</button></p>
+    <div id="turnout"></div>
+  </div>
+  <script>
+(function() {
+  var prefix = {{.Prefix}};
+  var electionName = {{.Election.Name}};
+  setupTurnout(prefix, electionName);
+})();
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "nominations"}}<!DOCTYPE html>
+<html>
+{{- template "head" (print "Nominations: " .Title)}}
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    <h2>Nominations: <a href="{{electionUrl .Prefix .Election.Name}}">{{.Title}}</a></h2>
+    {{- if .Election.Nominating}}
+    <p>Candidates are being nominated; voting starts afterwards.{{if .Election.NominationSeconds}} Nominations need {{.Election.NominationSeconds}} second(s) by other members before they can be approved.{{end}}</p>
+    {{- else}}
+    <p>Nominations are closed; the election is {{.State}}.</p>
+    {{- end}}
+    {{- template "form-status" .}}
+    {{- range .Nominations}}
+    <div class="candidate">
+      <h4>{{.Name}}</h4>
+      <p>Nominated by {{.Nominator}}; {{.Seconds}} second(s); {{.Status}}.</p>
+      {{- if and $.CanNominate (eq "pending" .Status) (ne $.User.Uid .Uid)}}
+      <form method="post" action=""><input type="hidden" name="csrf" value="{{$.Csrf}}"><input type="hidden" name="action" value="second"><input type="hidden" name="nomination" value="{{.Id}}"><button type="submit">Second</button></form>
+      {{- end}}
+      {{- template "candidate-details" .Details}}
+    </div>
+    {{- else}}
+    <p>No nominations yet.</p>
+    {{- end}}
+    {{- if .CanNominate}}
+    <h3>Nominate a candidate</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="nominate">
+      <p><label>Name: <input name="name" type="text" size="30" value="{{.Value "nominate" "name"}}"{{if .Invalid "nominate" "name"}} class="invalid"{{end}}></input></label></p>
+      <p><label>Link: <input name="url" type="text" size="40" value="{{.Value "nominate" "url"}}"></input></label></p>
+      <p><label>Image URL: <input name="image" type="text" size="40" value="{{.Value "nominate" "image"}}"></input></label></p>
+      <p><label>Description (Markdown):<br><textarea name="description" rows="4" cols="50">{{.Value "nominate" "description"}}</textarea></label></p>
+      <p><button type="submit">Nominate</button></p>
+    </form>
+    {{- else if and .Election.Nominating (not .User)}}
+    <p><a href="{{.Prefix}}/login">Log in</a> to nominate candidates.</p>
+    {{- end}}
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "form-status"}}
+{{- with .Error.Message}}
+<p class="error">{{.}}</p>
+{{- end}}
+{{- with .Message}}
+<p class="success">{{.}}</p>
+{{- end}}
+{{end}}
+
+{{define "admin"}}<!DOCTYPE html>
+<html>
+{{- template "head" "Admin"}}
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    {{- template "form-status" .}}
+    <h2>Elections</h2>
+    <table class="elections">
+      <tr><th>Election</th><th>Title</th><th>Status</th><th>Access</th></tr>
+      {{- range .Elections}}
+      <tr>
+        <td><a href="{{$.Prefix}}/admin/e/{{pathEscape .Election.Name}}">{{.Election.Name}}</a></td>
+        <td>{{.Election.Title}}</td>
+        <td>{{.State}}</td>
+        <td>{{if .Election.Public}}public{{else}}members only{{end}}{{if .Election.Open}}, open{{end}}{{if .Election.Secret}}, secret{{end}}</td>
+      </tr>
+      {{- end}}
+    </table>
+    <h3>Create election</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="create-election">
+      <p><label>Name (used in the URL): <input name="name" type="text" size="30" value="{{.Value "create-election" "name"}}"{{if .Invalid "create-election" "name"}} class="invalid"{{end}}></input></label></p>
+      <p><label>Title: <input name="title" type="text" size="50" value="{{.Value "create-election" "title"}}"></input></label></p>
+      <p><label>Candidates (one per line; optional with a nomination phase):<br><textarea name="candidates" rows="6" cols="40"{{if .Invalid "create-election" "candidates"}} class="invalid"{{end}}>{{.Value "create-election" "candidates"}}</textarea></label></p>
+      <p><label><input name="nominating" type="checkbox"{{if .Value "create-election" "nominating"}} checked{{end}}> Nomination phase (members nominate candidates before voting starts)</label>
+        <label>Seconds needed: <input name="seconds" type="number" min="0" size="4" value="{{or (.Value "create-election" "seconds") "0"}}"{{if .Invalid "create-election" "seconds"}} class="invalid"{{end}}></input></label></p>
+      <p><label><input name="ron" type="checkbox"{{if .Value "create-election" "ron"}} checked{{end}}> Reopen nominations (RON): add a "none of the above" candidate; the election fails if it wins</label></p>
+      <p>
+        <label><input name="public" type="checkbox"{{if .Value "create-election" "public"}} checked{{end}}> Public (anyone can see the election)</label><br>
+        <label><input name="open" type="checkbox"{{if .Value "create-election" "open"}} checked{{end}}> Open (anyone can vote in public elections)</label><br>
+        <label><input name="editopen" type="checkbox"{{if .Value "create-election" "editopen"}} checked{{end}}> Unregistered voters can change their vote</label><br>
+        <label><input name="secret" type="checkbox"{{if .Value "create-election" "secret"}} checked{{end}}> Secret ballots (can't be changed later)</label>
+      </p>
+      <p><label>Results: {{template "policies" (policies .Policies (.Value "create-election" "results"))}}</label></p>
+      <p><label>Partial ballots: {{template "unranked-policies" (unrankedPolicies .UnrankedPolicies (.Value "create-election" "unranked"))}}</label></p>
+      <p><button type="submit">Create</button></p>
+    </form>
+    <h2>Users</h2>
+    <table class="elections">
+      <tr><th>Name</th><th>Email</th><th>Site admin</th><th></th></tr>
+      {{- range .Users}}
+      <tr>
+        <td>{{.Name}}</td>
+        <td>{{.Email.String}}</td>
+        <td>{{if .SiteAdmin}}yes{{end}}</td>
+        <td><form method="post" action=""><input type="hidden" name="csrf" value="{{$.Csrf}}"><input type="hidden" name="action" value="new-token"><input type="hidden" name="email" value="{{.Email.String}}"><button type="submit">New token</button></form></td>
+      </tr>
+      {{- end}}
+    </table>
+    <h3>Create user</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="create-user">
+      <p><label>Name: <input name="name" type="text" size="30" value="{{.Value "create-user" "name"}}"{{if .Invalid "create-user" "auth.name"}} class="invalid"{{end}}></input></label></p>
+      <p><label>Email: <input name="email" type="text" size="30" value="{{.Value "create-user" "email"}}"{{if .Invalid "create-user" "email"}} class="invalid"{{end}}></input></label></p>
+      <p><label><input name="siteadmin" type="checkbox"{{if .Value "create-user" "siteadmin"}} checked{{end}}> Site admin</label></p>
+      <p><button type="submit">Create</button> (a token is generated and shown once)</p>
+    </form>
+    <p><a href="{{.Prefix}}/admin/audit">Audit log</a></p>
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "policies"}}
+<select name="results">
+  {{- range .Policies}}
+  <option value="{{.}}"{{if eq $.Selected .}} selected{{end}}>{{.Description}}</option>
+  {{- end}}
+</select>
+{{- end}}
+
+{{define "unranked-policies"}}
+<select name="unranked">
+  {{- range .Policies}}
+  <option value="{{.}}"{{if eq $.Selected .}} selected{{end}}>{{.Description}}</option>
+  {{- end}}
+</select>
+{{- end}}
+
+{{define "admin-election"}}<!DOCTYPE html>
+<html>
+{{- template "head" (print "Admin: " .Title)}}
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    <h2>Election {{.Election.Name}}</h2>
+    <p>
+      <a href="{{electionUrl .Prefix .Election.Name}}">Ballot</a>
+      <a href="{{electionUrl .Prefix .Election.Name}}/results">Results</a>
+      <a href="{{electionUrl .Prefix .Election.Name}}/turnout">Turnout</a>
+      <a href="{{electionUrl .Prefix .Election.Name}}/nominations">Nominations</a>
+    </p>
+    {{- template "form-status" .}}
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="{{if eq "closed" .State}}reopen{{else}}close{{end}}">
+      <p>The election is {{.State}}{{if .Election.Secret}} (secret ballots){{end}}. <button type="submit">{{if eq "closed" .State}}Reopen{{else}}Close now{{end}}</button></p>
+    </form>
+    <h3>Details</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="details">
+      <p><label>Title: <input name="title" type="text" size="50" value="{{or (.Value "details" "title") .Election.Title}}"></input></label></p>
+      <p>Candidates can't be added, removed or reordered after voting opened (withdraw or add them below instead); clear the name to remove a candidate. Descriptions use Markdown (*emphasis*, **strong**, code in backquotes, [links](https://...) and "- " lists).</p>
+      <table class="candidates{{if .Invalid "details" "candidates"}} invalid{{end}}">
+        <tr><th>Name</th><th>Link</th><th>Image URL</th><th>Description</th></tr>
+        {{- range $i, $c := .Candidates}}
+        <tr>
+          <td><input type="hidden" name="cid-{{$i}}" value="{{if $c.Id}}{{$c.Id}}{{end}}"><input name="name-{{$i}}" type="text" size="20" value="{{$c.Name}}"></td>
+          <td><input name="url-{{$i}}" type="text" size="25" value="{{$c.Url}}"></td>
+          <td><input name="image-{{$i}}" type="text" size="25" value="{{$c.Image}}"></td>
+          <td><textarea name="description-{{$i}}" rows="2" cols="40">{{$c.Description}}</textarea></td>
+        </tr>
+        {{- end}}
+      </table>
+      <p><button type="submit">Save</button></p>
+    </form>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="ron">
+      <p><label><input name="ron" type="checkbox"{{if ge .Election.Ron 0}} checked{{end}}> Reopen nominations (RON): a "none of the above" candidate; the election fails if it wins, candidates ranked below it are ineligible</label> <button type="submit">Save</button> (only before voting opened)</p>
+    </form>
+    {{- if ne "closed" .State}}
+    <h3>Withdraw or add candidates</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="change-candidates">
+      <p>Cast ballots are adjusted: withdrawn candidates are removed and new candidates ranked last (the hashes on the receipts change). Voters are asked to review their ballot.</p>
+      <p>Withdraw: {{range .Election.Candidates}}{{if not .Reserved}}<label><input name="withdraw" type="checkbox" value="{{.Id}}"> {{.Name}}</label> {{end}}{{end}}</p>
+      <p><label>New candidates (one per line):<br><textarea name="add" rows="3" cols="40"{{if .Invalid "change-candidates" "candidates"}} class="invalid"{{end}}>{{.Value "change-candidates" "add"}}</textarea></label></p>
+      {{- if not .Election.Secret}}
+      <p><label><input name="revote" type="checkbox"> Reset all votes instead; voters need to vote again</label></p>
+      {{- end}}
+      <p><button type="submit">Change candidates</button></p>
+    </form>
+    {{- end}}
+    <h3>Nominations</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="nominations">
+      <p><label><input name="nominating" type="checkbox"{{if .Election.Nominating}} checked{{end}}> Nomination phase (no voting; ends at the scheduled opening)</label>
+        <label>Seconds needed: <input name="seconds" type="number" min="0" size="4" value="{{or (.Value "nominations" "seconds") .Election.NominationSeconds}}"{{if .Invalid "nominations" "seconds"}} class="invalid"{{end}}></input></label>
+        <button type="submit">Save</button></p>
+    </form>
+    {{- if .Nominations}}
+    <table class="elections">
+      <tr><th>Candidate</th><th>Nominated by</th><th>Seconds</th><th>Status</th><th></th></tr>
+      {{- range .Nominations}}
+      <tr>
+        <td>{{.Name}}</td>
+        <td>{{.Nominator}}</td>
+        <td>{{.Seconds}}</td>
+        <td>{{.Status}}</td>
+        <td>{{if and $.Election.Nominating (eq "pending" .Status)}}<form method="post" action=""><input type="hidden" name="csrf" value="{{$.Csrf}}"><input type="hidden" name="action" value="decide-nomination"><input type="hidden" name="nomination" value="{{.Id}}"><button type="submit" name="status" value="approved"{{if lt .Seconds $.Election.NominationSeconds}} disabled{{end}}>Approve</button> <button type="submit" name="status" value="rejected">Reject</button></form>{{end}}</td>
+      </tr>
+      {{- end}}
+    </table>
+    {{- end}}
+    <h3>Access</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="access">
+      <p>
+        <label><input name="public" type="checkbox"{{if .Election.Public}} checked{{end}}> Public (anyone can see the election)</label><br>
+        <label><input name="open" type="checkbox"{{if .Election.Open}} checked{{end}}> Open (anyone can vote in public elections)</label><br>
+        <label><input name="editopen" type="checkbox"{{if .Election.EditOpen}} checked{{end}}> Unregistered voters can change their vote</label>
+      </p>
+      <p><button type="submit">Save</button></p>
+    </form>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="results-policy">
+      <p><label>Results: {{template "policies" (policies .Policies (print .Election.Results))}}</label> <button type="submit">Save</button></p>
+    </form>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="unranked-policy">
+      <p><label>Partial ballots: {{template "unranked-policies" (unrankedPolicies .UnrankedPolicies (print .Election.Unranked))}}</label> <button type="submit">Save</button> (only before ballots were cast)</p>
+    </form>
+    <h3>Schedule</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="schedule">
+      <p><label>Opens: <input name="opens_at" type="datetime-local" value="{{.OpensAt}}"></input></label>
+        <label>Closes: <input name="closes_at" type="datetime-local" value="{{.ClosesAt}}"></input></label>
+        <button type="submit">Save</button></p>
+      <p>Leave empty for no schedule.</p>
+    </form>
+    <h3>Members</h3>
+    <table class="elections">
+      <tr><th>Name</th><th>Email</th><th>Group</th><th>Voted</th><th></th></tr>
+      {{- range .Members}}
+      <tr>
+        <td>{{.Name}}</td>
+        <td>{{.Email}}</td>
+        <td>{{.Group}}</td>
+        <td>{{if .Voted}}yes{{end}}</td>
+        <td>{{if and .Email (not .Voted)}}<form method="post" action=""><input type="hidden" name="csrf" value="{{$.Csrf}}"><input type="hidden" name="action" value="remove-member"><input type="hidden" name="email" value="{{.Email}}"><button type="submit">Remove</button></form>{{end}}</td>
+      </tr>
+      {{- end}}
+    </table>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="add-member">
+      <p><label>Email: <input name="email" type="text" size="30" value="{{.Value "add-member" "email"}}"{{if .Invalid "add-member" "auth.token"}} class="invalid"{{end}}></input></label>
+        <label>Group: <input name="group" type="text" size="15" value="{{.Value "add-member" "group"}}"></input></label>
+        <button type="submit">Add member</button></p>
+    </form>
+    {{- if .BallotCodes}}
+    <h3>Ballot codes</h3>
+    <form method="post" action="">
+      <input type="hidden" name="csrf" value="{{$.Csrf}}">
+      <input type="hidden" name="action" value="ballot-codes">
+      <p><label>Number: <input name="count" type="number" min="1" size="6"{{if .Invalid "ballot-codes" "count"}} class="invalid"{{end}}></input></label>
+        <button type="submit">Generate and print</button></p>
+    </form>
+    {{- end}}
+    <h3>Audit log</h3>
+    <p>Without ballots; see the <a href="{{.Prefix}}/admin/audit">complete audit log</a>.</p>
+    {{- template "audit" .Audit}}
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "audit"}}
+<table class="elections">
+  <tr><th>#</th><th>Time</th><th>Action</th><th>Election</th><th>User</th><th>Data</th></tr>
+  {{- range .}}
+  <tr>
+    <td>{{.Seq}}</td>
+    <td>{{.Time}}</td>
+    <td>{{.Action}}</td>
+    <td>{{.Election}}</td>
+    <td>{{.User}}</td>
+    <td><code>{{.Data}}</code></td>
+  </tr>
+  {{- end}}
+</table>
+{{end}}
+
+{{define "admin-audit"}}<!DOCTYPE html>
+<html>
+{{- template "head" "Audit log"}}
+  <link rel="stylesheet" href="{{.PathVoteCSS}}">
+</head>
+<body style="text-align: center;">
+  <div style="display: inline-block; text-align: left;">
+    {{- template "nav" .}}
+    <h2>Audit log</h2>
+    {{- if .Valid}}
+    <p class="success">The hash chain is valid.</p>
+    {{- else}}
+    <p class="error">The hash chain is broken: {{.Broken}}</p>
+    {{- end}}
+    {{- template "audit" .Audit}}
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "ballotcodes"}}<!DOCTYPE html>
+<html>
+{{- template "head" (print "Ballot codes: " .Title)}}
+  <style>
+body { font-family: sans-serif; margin: 0; }
+div.slip {
+  display: inline-block;
+  box-sizing: border-box;
+  width: 50%;
+  padding: 1em;
+  border: 1px dashed grey;
+  page-break-inside: avoid;
+}
+div.slip p.code { font-family: monospace; font-size: 150%; }
+  </style>
+</head>
+<body>
+{{- range .Codes}}
+<div class="slip">
+  <h3>{{$.Title}}</h3>
+  <p>Vote at: {{$.VoteURL}}</p>
+  <p class="code">{{.}}</p>
+</div>
+{{- end}}
+</body>
+</html>
+{{end}}
+`))
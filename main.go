@@ -2,26 +2,82 @@ package main
 
 import (
 	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
+	"flag"
+	"fmt"
 	"github.com/stbuehler/go-vote/backend"
 	"github.com/stbuehler/go-vote/frontend"
 	"github.com/stbuehler/go-vote/static"
+	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
-func main() {
-	db, err := sql.Open("sqlite3", "elections.sqlite")
+func sqlDatabase() (db *sql.DB, postgres bool, err error) {
+	if strings.HasPrefix(cfg.Database, "postgres://") || strings.HasPrefix(cfg.Database, "postgresql://") {
+		db, err = sql.Open("postgres", cfg.Database)
+		return db, true, err
+	}
+	db, err = sql.Open("sqlite3", cfg.Database)
+	return db, false, err
+}
+
+func openDatabase() (backend.ElectionsDb, error) {
+	var edb backend.ElectionsDb
+	db, postgres, err := sqlDatabase()
 	if nil != err {
-		panic(err)
+		return edb, err
+	} else if postgres {
+		edb, err = backend.ConnectPostgres(db)
+	} else {
+		edb, err = backend.ConnectDatabase(db)
+	}
+	return edb.WithFeatures(cfg.Features.backend()), err
+}
+
+func main() {
+	if err := loadConfig(); nil != err {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
 	}
-	edb, err := backend.ConnectDatabase(db)
+
+	if args := flag.Args(); len(args) > 0 {
+		if cmd, ok := commands[args[0]]; !ok {
+			usage()
+			os.Exit(2)
+		} else if err := cmd.run(args[1:]); errUsage == err {
+			usage()
+			os.Exit(2)
+		} else if nil != err {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	edb, err := openDatabase()
 	if nil != err {
 		panic(err)
 	}
 
+	stopScheduler := func() {}
+	if cfg.Features.Scheduler {
+		stopScheduler = edb.StartScheduler(time.Minute)
+	}
+
 	mux := http.NewServeMux()
-	frontend.Frontend{edb}.BindServeMux(mux, "")
-	edb.BindServeMux(mux, "")
-	static.BindServeMux(mux, "")
-	http.ListenAndServe(":8080", mux)
+	frontend.Frontend{Edb: edb}.BindServeMux(mux, cfg.Prefix)
+	edb.BindServeMux(mux, cfg.Prefix)
+	static.BindServeMux(mux, cfg.Prefix)
+	err = serve(mux, edb.CloseSubscriptions)
+
+	stopScheduler()
+	if err := edb.Close(); nil != err {
+		log.Printf("Closing database failed: %v", err)
+	}
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+// first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// use the socket passed by systemd if there is one (see sd_listen_fds(3))
+func listen() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); nil != err || pid != os.Getpid() {
+		return net.Listen("tcp", cfg.Listen)
+	} else if fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); nil != err || fds < 1 {
+		return net.Listen("tcp", cfg.Listen)
+	} else if 1 != fds {
+		return nil, fmt.Errorf("expected a single socket from systemd, got %d", fds)
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(listenFdsStart, "systemd socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// reloads certificate and key when the certificate file changed
+type certReloader struct {
+	certFile, keyFile string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if info, err := os.Stat(r.certFile); nil != err {
+		if nil == r.cert {
+			return nil, err
+		}
+		log.Printf("Couldn't check certificate %+q, using loaded one: %v", r.certFile, err)
+	} else if nil == r.cert || !info.ModTime().Equal(r.modTime) {
+		if cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile); nil != err {
+			if nil == r.cert {
+				return nil, err
+			}
+			log.Printf("Couldn't reload certificate %+q, using loaded one: %v", r.certFile, err)
+		} else {
+			r.cert = &cert
+			r.modTime = info.ModTime()
+		}
+	}
+	return r.cert, nil
+}
+
+// serves until SIGINT or SIGTERM, then waits for running requests;
+// onShutdown should end long-running requests (event streams)
+func serve(handler http.Handler, onShutdown func()) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	server.RegisterOnShutdown(onShutdown)
+
+	listener, err := listen()
+	if nil != err {
+		return err
+	}
+	if 0 != len(cfg.TLSCert) {
+		reloader := &certReloader{certFile: cfg.TLSCert, keyFile: cfg.TLSKey}
+		// fail early on broken certificates
+		if _, err := reloader.GetCertificate(nil); nil != err {
+			listener.Close()
+			return err
+		}
+		server.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	shutdown := make(chan error, 1)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+
+	log.Printf("Listening on %v", listener.Addr())
+	if err := server.Serve(listener); http.ErrServerClosed != err {
+		return err
+	}
+	return <-shutdown
+}
@@ -5,7 +5,11 @@ var api_js = StaticContent{
 	FileName:    "api-##.js",
 	ContentType: "application/javascript",
 	Body: []byte(`
-function setup(prefix, electionName, choices, rankGroups) {
+// logged in users post the form (the login cookie isn't available to the API)
+// choices: candidate records, see Vote
+function setup(prefix, electionName, choices, rankGroups, resultsVisible, loggedIn) {
+  var names = choices.map(function(choice) { return choice.name; });
+
   function make_winning_table(numbers) {
     var i, j, table, row, cell, diff;
 
@@ -14,21 +18,21 @@ function setup(prefix, electionName, choices, rankGroups) {
 
     row = document.createElement("tr");
     row.appendChild(document.createElement("th"));
-    for (j = 0; j < choices.length; j++) {
+    for (j = 0; j < names.length; j++) {
       cell = document.createElement("th");
-      cell.innerText = choices[j];
+      cell.innerText = names[j];
       row.appendChild(cell);
     }
     table.appendChild(row);
 
-    for (i = 0; i < choices.length; i++) {
+    for (i = 0; i < names.length; i++) {
       row = document.createElement("tr");
 
       cell = document.createElement("th");
-      cell.innerText = choices[i];
+      cell.innerText = names[i];
       row.appendChild(cell);
 
-      for (j = 0; j < choices.length; j++) {
+      for (j = 0; j < names.length; j++) {
         cell = document.createElement("td");
         if (i == j) {
           cell.className = "self";
@@ -51,13 +55,23 @@ function setup(prefix, electionName, choices, rankGroups) {
     r.innerText = ""; //JSON.stringify(result);
 
     p = document.createElement("p");
-    if (0 === result.winner || result.winner) {
-      p.innerText = "The winner is: " + choices[result.winner];
+    if (result.failed) {
+      p.className = "error";
+      p.innerText = "The election failed: " + names[result.ron] + " won.";
+    } else if (0 === result.winner || result.winner) {
+      p.innerText = "The winner is: " + names[result.winner];
     } else {
       p.innerText = "There is no winner";
     }
     r.appendChild(p);
 
+    // only with a reopen nominations (RON) candidate
+    if (result.ineligible && result.ineligible.length) {
+      p = document.createElement("p");
+      p.innerText = "Ineligible (ranked below " + names[result.ron] + "): " + result.ineligible.map(function(c) { return names[c]; }).join(", ");
+      r.appendChild(p);
+    }
+
     p = document.createElement("p");
     p.innerText = "How often row wins over column:";
     r.appendChild(p);
@@ -73,24 +87,136 @@ function setup(prefix, electionName, choices, rankGroups) {
 
   function load_result() {
     var xhr = new XMLHttpRequest();
-    xhr.responseType = "json";
-    xhr.open('POST', prefix + "/result?election=" + electionName, true);
+    var code = document.getElementById('ballot-code').value;
+    xhr.open('GET', prefix + "/api/v1/elections/" + encodeURIComponent(electionName) + "/results", true);
+    xhr.setRequestHeader("Accept", "application/json");
+    if (code) xhr.setRequestHeader("Authorization", "Ballot-Code " + code);
     xhr.onreadystatechange = function() {
       if (xhr.readyState != 4) return; // not done
-      show_result(xhr.response);
+      if (200 == xhr.status) {
+        show_result(JSON.parse(xhr.responseText));
+      } else {
+        show_result_error(api_error(xhr));
+      }
     };
-    xhr.send(JSON.stringify({
-      auth: {},
-    }));
+    xhr.send();
+  }
+  function show_result_error(err) {
+    var p;
+    r.innerText = "";
+    p = document.createElement("p");
+    p.className = "error";
+    p.innerText = err.message;
+    r.appendChild(p);
+  }
+
+  // live updates (for projectors); EventSource reconnects by itself
+  // until the ticket expired, then a new ticket is needed
+  var events = null, subscription = 0;
+  function subscribe_results() {
+    var code = document.getElementById('ballot-code').value;
+    var id = ++subscription;
+    if (events) events.close();
+    events = null;
+    if (!code) {
+      listen_results(id, null);
+      return;
+    }
+    request_ticket(prefix, "Ballot-Code " + code, function(err, ticket) {
+      if (id != subscription) return; // subscribed again meanwhile
+      if (err) {
+        show_result_error(err);
+      } else {
+        listen_results(id, ticket);
+      }
+    });
+  }
+  function listen_results(id, ticket) {
+    var url = prefix + "/api/v1/elections/" + encodeURIComponent(electionName) + "/events";
+    if (ticket) url += "?ticket=" + encodeURIComponent(ticket);
+    events = new EventSource(url);
+    events.addEventListener("results", function(ev) {
+      show_result(JSON.parse(ev.data));
+    });
+    events.addEventListener("error", function(ev) {
+      // without data: connection problem, not an error sent by the server
+      if (ev.data) {
+        show_result_error(JSON.parse(ev.data));
+      } else if (id == subscription && EventSource.CLOSED == events.readyState) {
+        setTimeout(function() { if (id == subscription) subscribe_results(); }, 5000);
+      }
+    });
   }
 
+  // request fields to the inputs to highlight
+  var fieldInputs = {
+    "auth.name": document.getElementById('voter'),
+    "auth.code": document.getElementById('ballot-code'),
+    "rankgroups": document.getElementById('vote'),
+  };
+  function show_vote_status(err, receipt) {
+    var status = document.getElementById('vote-status'), field;
+    document.getElementById('receipt').innerText = receipt ? "Ballot " + receipt.Ballot + " (hash " + receipt.Hash + ")" : "";
+    for (field in fieldInputs) {
+      fieldInputs[field].classList.toggle("invalid", !!err && err.field == field);
+    }
+    if (err) {
+      status.className = "error";
+      status.innerText = err.message;
+    } else {
+      status.className = "success";
+      status.innerText = "Your vote was recorded.";
+    }
+  }
+
+  // drag-and-drop instead of the drop-downs of the form
+  document.body.classList.add("js");
+  document.getElementById('use-form').onclick = function() {
+    document.body.classList.remove("js");
+    return false;
+  };
+
   v = new Vote(document.getElementById('vote'), choices, rankGroups);
-  document.getElementById('submit-vote').onclick = function() {
-    v.submit(prefix, electionName, document.getElementById('voter').value, load_result);
+  document.getElementById('vote-block').onsubmit = function() {
+    var i, j;
+    // the drop-downs are posted as form
+    if (!document.body.classList.contains("js")) return true;
+    if (loggedIn) {
+      for (i = 0; i < v.selection.length; i++) {
+        for (j = 0; j < v.selection[i].length; j++) {
+          document.getElementById('rank-' + v.selection[i][j]).value = i + 1;
+        }
+      }
+      for (i = 0; i < v.unranked.length; i++) {
+        document.getElementById('rank-' + v.unranked[i]).value = -1;
+      }
+      return true;
+    }
+    v.submit(prefix, electionName, {
+      name: document.getElementById('voter').value,
+      code: document.getElementById('ballot-code').value,
+    }, function(err, receipt) {
+      show_vote_status(err, receipt);
+      // subscribed pages get the new results anyway
+      if (!err && resultsVisible && !events) load_result();
+    });
+    return false;
   };
 
-  document.getElementById('submit-result').onclick = load_result;
-  load_result();
+  document.getElementById('submit-result').onclick = function() {
+    // results visible to logged in users are rendered on the server
+    if (loggedIn) {
+      window.location.reload();
+      return;
+    }
+    load_result();
+    // the ballot code might have changed
+    if (events) subscribe_results();
+  };
+  // the results are rendered with the page; live updates for projectors
+  if (resultsVisible && !loggedIn && window.EventSource) {
+    subscribe_results();
+  }
 }
 `),
 }
@@ -5,11 +5,12 @@ import (
 	"net/http"
 )
 
-func IndexForPrefix(prefix string) http.HandlerFunc {
+// drag-and-drop example
+func DemoForPrefix(prefix string) http.HandlerFunc {
 	pathSortableJS := PathSortableJS(prefix)
 	pathVoteJS := PathVoteJS(prefix)
 	pathVoteCSS := PathVoteCSS(prefix)
-	path := prefix + "/"
+	path := prefix + "/demo"
 
 	return func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path != path {
@@ -37,6 +37,7 @@ var contents = []*StaticContent{
 	&sortable_min_js,
 	&vote_js,
 	&api_js,
+	&turnout_js,
 	&vote_css,
 }
 
@@ -63,7 +64,7 @@ func BindServeMux(mux *http.ServeMux, prefix string) {
 			Content: c,
 		})
 	}
-	mux.HandleFunc(prefix+"/", IndexForPrefix(prefix))
+	mux.HandleFunc(prefix+"/demo", DemoForPrefix(prefix))
 }
 
 func PathSortableJS(prefix string) string {
@@ -81,6 +82,11 @@ func PathApiJS(prefix string) string {
 	return prefix + "/" + api_js.FileName
 }
 
+func PathTurnoutJS(prefix string) string {
+	initContents()
+	return prefix + "/" + turnout_js.FileName
+}
+
 func PathVoteCSS(prefix string) string {
 	initContents()
 	return prefix + "/" + vote_css.FileName
@@ -0,0 +1,157 @@
+package static
+
+var turnout_js = StaticContent{
+	Hash:        true,
+	FileName:    "turnout-##.js",
+	ContentType: "application/javascript",
+	Body: []byte(`
+function setupTurnout(prefix, electionName) {
+  var t = document.getElementById('turnout');
+  var tokenInput = document.getElementById('token');
+
+  function percent(voted, eligible) {
+    if (!eligible) return "";
+    return Math.round(100 * voted / eligible) + "%";
+  }
+
+  function make_row(cellType, values) {
+    var row = document.createElement("tr"), cell, i;
+    for (i = 0; i < values.length; i++) {
+      cell = document.createElement(cellType);
+      cell.innerText = values[i];
+      row.appendChild(cell);
+    }
+    return row;
+  }
+
+  function make_groups_table(turnout) {
+    var table = document.createElement("table"), i, g;
+    table.className = "turnout";
+    table.appendChild(make_row("th", ["Group", "Eligible", "Voted", ""]));
+    for (i = 0; i < turnout.groups.length; i++) {
+      g = turnout.groups[i];
+      table.appendChild(make_row("td", [g.group || "(no group)", g.eligible, g.voted, percent(g.voted, g.eligible)]));
+    }
+    table.appendChild(make_row("th", ["Total", turnout.eligible, turnout.voted, percent(turnout.voted, turnout.eligible)]));
+    return table;
+  }
+
+  function make_timeline_table(turnout) {
+    var table = document.createElement("table"), i, point, row, cell, bar;
+    var max = Math.max(turnout.voted, turnout.eligible);
+    table.className = "turnout";
+    table.appendChild(make_row("th", ["Time", "Voted", ""]));
+    for (i = 0; i < turnout.timeline.length; i++) {
+      point = turnout.timeline[i];
+      row = make_row("td", [new Date(point.time * 1000).toLocaleString(), point.voted]);
+      cell = document.createElement("td");
+      bar = document.createElement("div");
+      bar.className = "bar";
+      bar.style.width = Math.round(200 * point.voted / max) + "px";
+      cell.appendChild(bar);
+      row.appendChild(cell);
+      table.appendChild(row);
+    }
+    return table;
+  }
+
+  function show_turnout(turnout) {
+    var p;
+    t.innerText = "";
+
+    p = document.createElement("p");
+    p.innerText = turnout.voted + " of " + turnout.eligible + " eligible voters cast a ballot";
+    if (turnout.eligible) p.innerText += " (" + percent(turnout.voted, turnout.eligible) + ")";
+    p.innerText += ".";
+    if (turnout.open) p.innerText += " Anybody can vote in this election; only members and ballot codes are counted as eligible.";
+    t.appendChild(p);
+    if (turnout.groups) t.appendChild(make_groups_table(turnout));
+
+    if (turnout.timeline) {
+      p = document.createElement("p");
+      p.innerText = "Ballots cast over time (per " + Math.round(turnout.interval / 60) + " minutes):";
+      t.appendChild(p);
+      t.appendChild(make_timeline_table(turnout));
+    }
+  }
+
+  function show_turnout_error(err) {
+    var p;
+    t.innerText = "";
+    p = document.createElement("p");
+    p.className = "error";
+    p.innerText = err.message;
+    t.appendChild(p);
+  }
+
+  function load_turnout() {
+    var xhr = new XMLHttpRequest();
+    xhr.open('GET', prefix + "/api/v1/elections/" + encodeURIComponent(electionName) + "/turnout", true);
+    xhr.setRequestHeader("Accept", "application/json");
+    if (tokenInput.value) xhr.setRequestHeader("Authorization", "Bearer " + tokenInput.value);
+    xhr.onreadystatechange = function() {
+      if (xhr.readyState != 4) return; // not done
+      if (200 == xhr.status) {
+        show_turnout(JSON.parse(xhr.responseText));
+      } else {
+        show_turnout_error(api_error(xhr));
+      }
+    };
+    xhr.send();
+  }
+
+  // live updates; EventSource reconnects by itself until the ticket
+  // expired, then a new ticket is needed
+  var events = null, subscription = 0;
+  function subscribe_turnout() {
+    var id = ++subscription;
+    if (events) events.close();
+    events = null;
+    if (!tokenInput.value) {
+      listen_turnout(id, null);
+      return;
+    }
+    request_ticket(prefix, "Bearer " + tokenInput.value, function(err, ticket) {
+      if (id != subscription) return; // subscribed again meanwhile
+      if (err) {
+        show_turnout_error(err);
+      } else {
+        listen_turnout(id, ticket);
+      }
+    });
+  }
+  function listen_turnout(id, ticket) {
+    var url = prefix + "/api/v1/elections/" + encodeURIComponent(electionName) + "/turnout/events";
+    if (ticket) url += "?ticket=" + encodeURIComponent(ticket);
+    events = new EventSource(url);
+    events.addEventListener("turnout", function(ev) {
+      show_turnout(JSON.parse(ev.data));
+    });
+    events.addEventListener("error", function(ev) {
+      // without data: connection problem, not an error sent by the server
+      if (ev.data) {
+        show_turnout_error(JSON.parse(ev.data));
+      } else if (id == subscription && EventSource.CLOSED == events.readyState) {
+        setTimeout(function() { if (id == subscription) subscribe_turnout(); }, 5000);
+      }
+    });
+  }
+
+  function show() {
+    if (window.sessionStorage) sessionStorage.setItem("go-vote-token", tokenInput.value);
+    if (window.EventSource) {
+      subscribe_turnout();
+    } else {
+      load_turnout();
+    }
+  }
+
+  document.getElementById('submit-token').onclick = show;
+  document.getElementById('submit-reload').onclick = load_turnout;
+  if (window.sessionStorage && sessionStorage.getItem("go-vote-token")) {
+    tokenInput.value = sessionStorage.getItem("go-vote-token");
+    show();
+  }
+}
+`),
+}
@@ -27,6 +27,18 @@ div.block {
   min-height: 30px;
 }
 
+#vote ul.unranked {
+  background: #ddd;
+  min-height: 30px;
+  margin-top: 30px;
+}
+
+#vote ul.unranked::before {
+  content: 'Not ranked';
+  display: block;
+  color: #555;
+}
+
 #vote ul::after {
   clear: both;
   content: '';
@@ -45,11 +57,67 @@ div.block {
   min-width: 50px;
 }
 
+#vote li.reserved {
+  background: #777;
+}
+
 #vote li.sortable-ghost {
   opacity: .3;
   background: #f60;
 }
 
+.error {
+  color: #c00;
+}
+
+.success {
+  color: green;
+}
+
+input.invalid, #vote.invalid ul, table.invalid {
+  outline: 2px solid #c00;
+}
+
+details.candidate, div.candidate {
+  max-width: 400px;
+}
+details.candidate img, div.candidate img {
+  max-width: 200px;
+  max-height: 200px;
+}
+#vote details.candidate {
+  text-align: left;
+  cursor: auto;
+}
+#vote details.candidate a {
+  color: white;
+}
+
+div.nav form {
+  display: inline;
+  margin-left: 1em;
+}
+
+table.elections {
+  border-collapse: collapse;
+}
+table.elections td, table.elections th {
+  padding: 3px 5px;
+  border: 1px solid grey;
+  margin: 0;
+}
+
+/* drag-and-drop ballot and reloading results need JavaScript */
+.js-only {
+  display: none;
+}
+body.js .js-only {
+  display: block;
+}
+body.js .no-js {
+  display: none;
+}
+
 table.winning {
   border-collapse: collapse;
 }
@@ -67,5 +135,21 @@ table.winning td.win {
 table.winning td.loose {
   background: red;
 }
+
+table.turnout {
+  border-collapse: collapse;
+}
+table.turnout td, table.turnout th {
+  padding: 3px 5px;
+  border: 1px solid grey;
+  margin: 0;
+}
+table.turnout td {
+  text-align: right;
+}
+table.turnout div.bar {
+  height: 1em;
+  background: #5F9EDF;
+}
 `),
 }
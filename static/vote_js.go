@@ -10,18 +10,24 @@ function Vote(node, choices, initialSelection) {
   this.node = node;
   this.choices = choices;
   this.selection = initialSelection;
+  this.unranked = []; // choices left off the (partial) ballot
   this.sortables = [];
   this.separators = [];
+  this.unrankedList = null;
   this.sorting = {
     group: "group-for-" + this.node.id,
     onAdd: function(evt) {
-      var ndx = +evt.to.getAttribute("data-index");
+      var ndx, sel;
       // handle add
-      if (!evt.to.hasAttribute("data-separator")) {
+      if (evt.to === self.unrankedList.el) {
+        self.unranked = self.unrankedList.toArray().map(Number);
+      } else if (!evt.to.hasAttribute("data-separator")) {
         // not a separator
+        ndx = +evt.to.getAttribute("data-index");
         self.selection[ndx] = self.sortables[ndx].toArray().map(Number);
       } else {
         // convert separator into real list
+        ndx = +evt.to.getAttribute("data-index");
         evt.to.removeAttribute("data-separator");
         evt.to.className = "";
         self.sortables.splice(ndx, 0, self.separators[ndx]);
@@ -34,22 +40,26 @@ function Vote(node, choices, initialSelection) {
         }
       }
       // handle remove
-      ndx = +evt.from.getAttribute("data-index");
-      var sel = self.selection[ndx] = self.sortables[ndx].toArray().map(Number);
-      if (0 === sel.length) {
-        // remove now empty list and following separator
-        self.node.removeChild(self.sortables[ndx].el);
-        self.node.removeChild(self.separators[ndx+1].el);
-        self.selection.splice(ndx, 1);
-        self.sortables.splice(ndx, 1);
-        self.separators.splice(ndx+1, 1);
-        // fix index
-        for (; ndx < self.sortables.length; ndx++) {
-          self.sortables[ndx].el.setAttribute("data-index", ndx);
-          self.separators[ndx+1].el.setAttribute("data-index", ndx+1);
+      if (evt.from === self.unrankedList.el) {
+        self.unranked = self.unrankedList.toArray().map(Number);
+      } else {
+        ndx = +evt.from.getAttribute("data-index");
+        sel = self.selection[ndx] = self.sortables[ndx].toArray().map(Number);
+        if (0 === sel.length) {
+          // remove now empty list and following separator
+          self.node.removeChild(self.sortables[ndx].el);
+          self.node.removeChild(self.separators[ndx+1].el);
+          self.selection.splice(ndx, 1);
+          self.sortables.splice(ndx, 1);
+          self.separators.splice(ndx+1, 1);
+          // fix index
+          for (; ndx < self.sortables.length; ndx++) {
+            self.sortables[ndx].el.setAttribute("data-index", ndx);
+            self.separators[ndx+1].el.setAttribute("data-index", ndx+1);
+          }
         }
       }
-      console.log("Current vote: " + JSON.stringify(self.selection));
+      console.log("Current vote: " + JSON.stringify(self.selection) + ", not ranked: " + JSON.stringify(self.unranked));
     },
   };
   this.redraw();
@@ -59,7 +69,7 @@ Vote.prototype._appendList = function(list) {
   var ndx = this.sortables.length;
   list.setAttribute("data-index", ndx);
   this.sortables[ndx] = Sortable.create(list, this.sorting);
-  this.node.appendChild(list);
+  this.node.insertBefore(list, this.unrankedList.el);
 };
 
 Vote.prototype._addSeparator = function(ndx, before) {
@@ -78,40 +88,137 @@ Vote.prototype.clear = function() {
   this.node.innertHtml = "";
   this.sortables = [];
   this.separators = [];
+  this.unrankedList = null;
+};
+
+// choices are names or {name, description (HTML), url, image}
+Vote.prototype._choiceElement = function(ndx) {
+  var choice = this.choices[ndx], elem, details, node;
+  elem = document.createElement('li');
+  elem.setAttribute("data-id", ndx);
+  if ("string" === typeof choice) {
+    elem.innerText = choice;
+    return elem;
+  }
+  // reopen nominations (RON)
+  if (choice.reserved) elem.className = "reserved";
+  elem.appendChild(document.createTextNode(choice.name));
+  if (choice.description || choice.url || choice.image) {
+    details = document.createElement('details');
+    details.className = "candidate";
+    node = document.createElement('summary');
+    node.innerText = "Details";
+    details.appendChild(node);
+    if (choice.image) {
+      node = document.createElement('img');
+      node.src = choice.image;
+      node.alt = "";
+      details.appendChild(node);
+    }
+    if (choice.description) {
+      // rendered and escaped on the server
+      node = document.createElement('div');
+      node.innerHTML = choice.description;
+      details.appendChild(node);
+    }
+    if (choice.url) {
+      node = document.createElement('a');
+      node.href = choice.url;
+      node.target = "_blank";
+      node.innerText = choice.url;
+      details.appendChild(node);
+    }
+    elem.appendChild(details);
+  }
+  return elem;
 };
 
 Vote.prototype.redraw = function() {
-  var i, j, sel, list, elem;
+  var i, j, sel, list, ranked = [];
   this.clear();
   for (i = 0; i < this.selection.length; i++) {
     if (0 === this.selection[i].length) {
       this.selection.splice(i--, 1);
     }
   }
-  this._addSeparator(0);
+  // choices not in the selection are unranked
+  for (i = 0; i < this.selection.length; i++) {
+    for (j = 0; j < this.selection[i].length; j++) {
+      ranked[this.selection[i][j]] = true;
+    }
+  }
+  this.unranked = [];
+  for (i = 0; i < this.choices.length; i++) {
+    if (!ranked[i]) this.unranked.push(i);
+  }
+  list = document.createElement('ul');
+  list.className = "unranked";
+  for (j = 0; j < this.unranked.length; j++) {
+    list.appendChild(this._choiceElement(this.unranked[j]));
+  }
+  this.unrankedList = Sortable.create(list, this.sorting);
+  this.node.appendChild(list);
+
+  this._addSeparator(0, list);
   for (i = 0; i < this.selection.length; i++) {
     sel = this.selection[i].sort();
     list = document.createElement('ul');
     for (j = 0; j < sel.length; j++) {
-      elem = document.createElement('li');
-      elem.setAttribute("data-id", sel[j]);
-      elem.innerText = this.choices[sel[j]];
-      list.appendChild(elem);
+      list.appendChild(this._choiceElement(sel[j]));
     }
     this._appendList(list);
-    this._addSeparator(i+1);
+    this._addSeparator(i+1, this.unrankedList.el);
   }
 };
 
-Vote.prototype.submit = function(prefix, elId, voter, onfinished) {
+// error envelope {code, message, field} of a failed api request
+function api_error(xhr) {
+  var err;
+  try {
+    err = JSON.parse(xhr.responseText);
+  } catch (e) {
+  }
+  if (!err || !err.code) {
+    err = { code: "http", message: "Request failed: " + xhr.status + " " + xhr.statusText };
+  }
+  return err;
+}
+
+// EventSource can't set headers: event streams authenticate with a
+// short-lived ticket; onfinished(err, ticket): err is null on success
+function request_ticket(prefix, authorization, onfinished) {
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', prefix + "/api/v1/tickets", true);
+  xhr.setRequestHeader("Accept", "application/json");
+  xhr.setRequestHeader("Authorization", authorization);
+  xhr.onreadystatechange = function() {
+    if (xhr.readyState != 4) return; // not done
+    if (200 == xhr.status) {
+      onfinished(null, JSON.parse(xhr.responseText).ticket);
+    } else {
+      onfinished(api_error(xhr), null);
+    }
+  };
+  xhr.send();
+}
+
+// onfinished(err, receipt): err is null on success
+Vote.prototype.submit = function(prefix, elId, auth, onfinished) {
   var xhr = new XMLHttpRequest();
-  xhr.open('POST', prefix + "/vote?election=" + elId, true);
+  xhr.open('PUT', prefix + "/api/v1/elections/" + encodeURIComponent(elId) + "/ballot", true);
+  xhr.setRequestHeader("Content-Type", "application/json");
+  xhr.setRequestHeader("Accept", "application/json");
   xhr.onreadystatechange = function() {
     if (xhr.readyState != 4) return; // not done
-    if (onfinished) onfinished();
+    if (!onfinished) return;
+    if (200 == xhr.status) {
+      onfinished(null, JSON.parse(xhr.responseText));
+    } else {
+      onfinished(api_error(xhr), null);
+    }
   };
   xhr.send(JSON.stringify({
-    auth: { name: voter },
+    auth: auth,
     rankgroups: this.selection,
   }));
 }
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// anonymised ballot as published after an election closed
+type Ballot struct {
+	Id      string
+	Ranking Ranking
+	Hash    string
+}
+
+// given to voters after casting a ballot; the hash allows checking that
+// the published ballot wasn't modified
+type Receipt struct {
+	Ballot string
+	Hash   string
+}
+
+func BallotHash(id string, ranking Ranking) string {
+	h := sha256.New()
+	h.Write([]byte(id))
+	h.Write([]byte{':'})
+	h.Write(JsonMustEncode(ranking))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func NewBallot(id string, ranking Ranking) Ballot {
+	return Ballot{
+		Id:      id,
+		Ranking: ranking,
+		Hash:    BallotHash(id, ranking),
+	}
+}
+
+func (b Ballot) Receipt() Receipt {
+	return Receipt{
+		Ballot: b.Id,
+		Hash:   b.Hash,
+	}
+}
+
+// check hash of ballot
+func (b Ballot) Valid() bool {
+	return b.Hash == BallotHash(b.Id, b.Ranking)
+}
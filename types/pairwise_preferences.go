@@ -9,3 +9,58 @@ func (p PairwisePreferences) AsciiTable(candidates []string) string {
 func (p PairwisePreferences) Winner() int {
 	return Pairwise(p).Winner()
 }
+
+// count ranking (see Prefers for abstain); panics if ranking has the
+// wrong number of candidates
+func (p PairwisePreferences) Count(ranking Ranking, abstain bool) {
+	numCandidates := len(p)
+	for runner := 0; runner < numCandidates; runner++ {
+		for opponent := 0; opponent < numCandidates; opponent++ {
+			if ranking.Prefers(runner, opponent, abstain) {
+				p[runner][opponent]++
+			}
+		}
+	}
+}
+
+// remove a counted ranking; panics if ranking has the wrong number of candidates
+func (p PairwisePreferences) Uncount(ranking Ranking, abstain bool) {
+	numCandidates := len(p)
+	for runner := 0; runner < numCandidates; runner++ {
+		for opponent := 0; opponent < numCandidates; opponent++ {
+			if ranking.Prefers(runner, opponent, abstain) {
+				p[runner][opponent]--
+			}
+		}
+	}
+}
+
+func (p PairwisePreferences) Equal(other PairwisePreferences) bool {
+	if len(p) != len(other) {
+		return false
+	}
+	for runner, line := range p {
+		if len(line) != len(other[runner]) {
+			return false
+		}
+		for opponent, count := range line {
+			if count != other[runner][opponent] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func PairwisePreferencesFromBallots(numCandidates int, ballots []Ballot, abstain bool) (PairwisePreferences, error) {
+	table := PairwisePreferences(NewPairwise(numCandidates))
+	for _, b := range ballots {
+		if len(b.Ranking) != numCandidates {
+			return nil, ErrUnexpectedNumberOfCandidates
+		} else if err := b.Ranking.Check(); nil != err {
+			return nil, err
+		}
+		table.Count(b.Ranking, abstain)
+	}
+	return table, nil
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"errors"
+	"sort"
 )
 
 var ErrRankOutOfRange = errors.New("Ranking contained negative rank")
@@ -11,23 +12,28 @@ var ErrDuplicateCandidate = errors.New("RangGroups contained a candidate twice")
 var ErrCandidateOutOfRange = errors.New("RangGroups contained a negative candidate")
 var ErrCandidateMissing = errors.New("RangGroups misses a candidate")
 var ErrEmptyRankGroup = errors.New("RangGroups contained an empty group")
+var ErrUnknownCandidate = errors.New("RangGroups contained an unknown candidate")
 
 /* for each candidate specify a "rank". each rank must be >= 0,
  * and all ranks between 0 and the highest rank must be used (i.e.
  * the ranking must be compact).
  *
  * smaller rank value means higher preference
+ *
+ * partial ballots (truncated rankings) don't rank all candidates; the
+ * others have the rank Unranked.
  */
 
 type Ranking []int
 
+const Unranked = -1
+
 func (r Ranking) Check() error {
-	if 0 == len(r) {
-		return nil
-	}
-	highRank := 0
+	highRank := -1
 	for _, rank := range r {
-		if rank < 0 {
+		if Unranked == rank {
+			continue
+		} else if rank < 0 {
 			return ErrRankOutOfRange
 		} else if highRank < rank {
 			highRank = rank
@@ -39,7 +45,7 @@ func (r Ranking) Check() error {
 	numUsedRanks := 0
 	usedRank := make([]bool, highRank+1)
 	for _, rank := range r {
-		if !usedRank[rank] {
+		if Unranked != rank && !usedRank[rank] {
 			usedRank[rank] = true
 			numUsedRanks++
 		}
@@ -50,12 +56,82 @@ func (r Ranking) Check() error {
 	return nil
 }
 
+// whether no candidate is ranked
+func (r Ranking) Empty() bool {
+	for _, rank := range r {
+		if Unranked != rank {
+			return false
+		}
+	}
+	return true
+}
+
+// whether ranking prefers runner over opponent. unranked candidates are
+// tied last; with abstain the ranking doesn't prefer anything over them.
+func (r Ranking) Prefers(runner, opponent int, abstain bool) bool {
+	if Unranked == r[runner] {
+		return false
+	} else if Unranked == r[opponent] {
+		return !abstain
+	}
+	return r[runner] < r[opponent]
+}
+
+// ranking for a changed candidate list: mapping[candidate] is the new
+// index of each old candidate (-1 if removed). ranks are compacted again;
+// candidates nothing maps to (new ones) share the lowest rank, or are
+// unranked if the ranking is partial. r must be valid (see Check).
+func (r Ranking) Remap(mapping []int, numCandidates int) Ranking {
+	usedRank := make([]bool, len(r)+1)
+	partial := false
+	for candidate, rank := range r {
+		if mapping[candidate] < 0 {
+			continue
+		} else if Unranked == rank {
+			partial = true
+		} else {
+			usedRank[rank] = true
+		}
+	}
+	compactRank := make([]int, len(usedRank))
+	numRanks := 0
+	for rank, used := range usedRank {
+		if used {
+			compactRank[rank] = numRanks
+			numRanks++
+		}
+	}
+	newRank := numRanks
+	if partial {
+		newRank = Unranked
+	}
+	remapped := make(Ranking, numCandidates)
+	for candidate := range remapped {
+		remapped[candidate] = newRank
+	}
+	for candidate, rank := range r {
+		if to := mapping[candidate]; to < 0 {
+			continue
+		} else if Unranked == rank {
+			remapped[to] = Unranked
+		} else {
+			remapped[to] = compactRank[rank]
+		}
+	}
+	return remapped
+}
+
+// unranked candidates are not part of any group
 func (r Ranking) RankGroups() (RankGroups, error) {
-	highRank := 0
+	highRank := -1
+	numRanked := 0
 	for _, rank := range r {
-		if rank < 0 {
+		if Unranked == rank {
+			continue
+		} else if rank < 0 {
 			return nil, ErrRankOutOfRange
 		}
+		numRanked++
 		if highRank < rank {
 			highRank = rank
 		}
@@ -65,9 +141,11 @@ func (r Ranking) RankGroups() (RankGroups, error) {
 	}
 	rankCountsOrOffset := make([]int, highRank+1)
 	for _, rank := range r {
-		rankCountsOrOffset[rank]++
+		if Unranked != rank {
+			rankCountsOrOffset[rank]++
+		}
 	}
-	groupsMemory := make([]int, len(r))
+	groupsMemory := make([]int, numRanked)
 	rg := make(RankGroups, highRank+1)
 	{
 		offset := 0
@@ -81,6 +159,9 @@ func (r Ranking) RankGroups() (RankGroups, error) {
 		}
 	}
 	for candidate, rank := range r {
+		if Unranked == rank {
+			continue
+		}
 		groupsMemory[rankCountsOrOffset[rank]] = candidate
 		rankCountsOrOffset[rank]++
 	}
@@ -90,10 +171,33 @@ func (r Ranking) RankGroups() (RankGroups, error) {
 /* for each rank in a `Ranking` this contains the list of all candidates
  * of that rank. similar to `Ranking` this must be compact, i.e. all
  * inner lists must be non-empty. Also all candidates [0..numCandidates[
- * must be present exactly once.
+ * must be present exactly once (at most once for partial ballots, see
+ * `PartialRanking`).
  */
 type RankGroups [][]int
 
+// ranks[candidate] is any number (like a position entered in a form);
+// candidates with lower numbers are preferred, equal numbers are grouped.
+// candidates with rank Unranked are left out.
+func RankGroupsFromRanks(ranks []int) RankGroups {
+	var values []int
+	groups := make(map[int][]int)
+	for candidate, rank := range ranks {
+		if Unranked == rank {
+			continue
+		} else if _, ok := groups[rank]; !ok {
+			values = append(values, rank)
+		}
+		groups[rank] = append(groups[rank], candidate)
+	}
+	sort.Ints(values)
+	rg := make(RankGroups, 0, len(values))
+	for _, rank := range values {
+		rg = append(rg, groups[rank])
+	}
+	return rg
+}
+
 func (rg RankGroups) Sanitize(numCandidates int) RankGroups {
 	mem := make([]int, 0, numCandidates)
 	have := make([]bool, numCandidates)
@@ -186,3 +290,28 @@ func (rg RankGroups) Ranking() (Ranking, error) {
 	}
 	return r, nil
 }
+
+// like Ranking, but the groups don't need to contain all candidates; the
+// missing ones are Unranked
+func (rg RankGroups) PartialRanking(numCandidates int) (Ranking, error) {
+	r := make(Ranking, numCandidates)
+	for candidate := range r {
+		r[candidate] = Unranked
+	}
+	for rank, g := range rg {
+		if 0 == len(g) {
+			return nil, ErrEmptyRankGroup
+		}
+		for _, candidate := range g {
+			if candidate < 0 {
+				return nil, ErrCandidateOutOfRange
+			} else if candidate >= numCandidates {
+				return nil, ErrUnknownCandidate
+			} else if Unranked != r[candidate] {
+				return nil, ErrDuplicateCandidate
+			}
+			r[candidate] = rank
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartialRanking(t *testing.T) {
+	tests := []struct {
+		name          string
+		groups        RankGroups
+		numCandidates int
+		expected      Ranking
+		err           error
+	}{
+		{"complete", RankGroups{{1}, {0, 2}}, 3, Ranking{1, 0, 1}, nil},
+		{"partial", RankGroups{{2}, {0}}, 3, Ranking{1, Unranked, 0}, nil},
+		{"all unranked", RankGroups{}, 3, Ranking{Unranked, Unranked, Unranked}, nil},
+		{"empty group", RankGroups{{0}, {}}, 3, nil, ErrEmptyRankGroup},
+		{"negative candidate", RankGroups{{-1}}, 3, nil, ErrCandidateOutOfRange},
+		{"unknown candidate", RankGroups{{3}}, 3, nil, ErrUnknownCandidate},
+		{"duplicate candidate", RankGroups{{0}, {0}}, 3, nil, ErrDuplicateCandidate},
+	}
+	for _, test := range tests {
+		if r, err := test.groups.PartialRanking(test.numCandidates); test.err != err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		} else if !reflect.DeepEqual(test.expected, r) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, r)
+		}
+	}
+}
+
+func TestRankingEmpty(t *testing.T) {
+	tests := []struct {
+		ranking  Ranking
+		expected bool
+	}{
+		{Ranking{0, 1}, false},
+		{Ranking{Unranked, 0}, false},
+		{Ranking{Unranked, Unranked}, true},
+		{Ranking{}, true},
+	}
+	for _, test := range tests {
+		if empty := test.ranking.Empty(); test.expected != empty {
+			t.Errorf("%v: expected Empty() %v, got %v", test.ranking, test.expected, empty)
+		}
+	}
+}
+
+func TestRankingPrefers(t *testing.T) {
+	tests := []struct {
+		name             string
+		ranking          Ranking
+		runner, opponent int
+		abstain          bool
+		expected         bool
+	}{
+		{"ranked higher", Ranking{0, 1}, 0, 1, false, true},
+		{"ranked lower", Ranking{0, 1}, 1, 0, false, false},
+		{"tied", Ranking{0, 0}, 0, 1, false, false},
+		{"over unranked (last)", Ranking{0, Unranked}, 0, 1, false, true},
+		{"over unranked (abstain)", Ranking{0, Unranked}, 0, 1, true, false},
+		{"unranked over ranked (last)", Ranking{0, Unranked}, 1, 0, false, false},
+		{"unranked over ranked (abstain)", Ranking{0, Unranked}, 1, 0, true, false},
+		{"both unranked (last)", Ranking{Unranked, Unranked}, 0, 1, false, false},
+	}
+	for _, test := range tests {
+		if prefers := test.ranking.Prefers(test.runner, test.opponent, test.abstain); test.expected != prefers {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, prefers)
+		}
+	}
+}
+
+// pairwise contributions of partial ballots
+func TestCountUnranked(t *testing.T) {
+	tests := []struct {
+		name     string
+		ranking  Ranking
+		abstain  bool
+		expected PairwisePreferences
+	}{
+		{"complete", Ranking{0, 1, 2}, false, PairwisePreferences{{0, 1, 1}, {0, 0, 1}, {0, 0, 0}}},
+		{"complete (abstain)", Ranking{0, 1, 2}, true, PairwisePreferences{{0, 1, 1}, {0, 0, 1}, {0, 0, 0}}},
+		{"unranked last", Ranking{0, 1, Unranked}, false, PairwisePreferences{{0, 1, 1}, {0, 0, 1}, {0, 0, 0}}},
+		{"unranked abstain", Ranking{0, 1, Unranked}, true, PairwisePreferences{{0, 1, 0}, {0, 0, 0}, {0, 0, 0}}},
+		{"single ranked last", Ranking{Unranked, 0, Unranked}, false, PairwisePreferences{{0, 0, 0}, {1, 0, 1}, {0, 0, 0}}},
+		{"single ranked abstain", Ranking{Unranked, 0, Unranked}, true, PairwisePreferences{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}}},
+		{"all unranked last", Ranking{Unranked, Unranked, Unranked}, false, PairwisePreferences{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}}},
+		{"all unranked abstain", Ranking{Unranked, Unranked, Unranked}, true, PairwisePreferences{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}}},
+	}
+	for _, test := range tests {
+		prefs := PairwisePreferences(NewPairwise(len(test.ranking)))
+		prefs.Count(test.ranking, test.abstain)
+		if !prefs.Equal(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, prefs)
+		}
+		prefs.Uncount(test.ranking, test.abstain)
+		if !prefs.Equal(PairwisePreferences(NewPairwise(len(test.ranking)))) {
+			t.Errorf("%s: not empty after Uncount: %v", test.name, prefs)
+		}
+	}
+}
+
+func TestRankingRemap(t *testing.T) {
+	tests := []struct {
+		name          string
+		ranking       Ranking
+		mapping       []int
+		numCandidates int
+		expected      Ranking
+	}{
+		{"unchanged", Ranking{1, 0, 2}, []int{0, 1, 2}, 3, Ranking{1, 0, 2}},
+		{"removed", Ranking{2, 1, 0}, []int{0, -1, 1}, 2, Ranking{1, 0}},
+		{"removed tied", Ranking{0, 0, 1}, []int{0, -1, 1}, 2, Ranking{0, 1}},
+		{"removed only first", Ranking{0, 1, 2}, []int{-1, 0, 1}, 2, Ranking{0, 1}},
+		{"added last", Ranking{1, 0}, []int{0, 1}, 3, Ranking{1, 0, 2}},
+		{"removed and added", Ranking{2, 1, 0}, []int{0, -1, 1}, 3, Ranking{1, 0, 2}},
+		{"reordered", Ranking{0, 1, 2}, []int{2, 0, 1}, 3, Ranking{1, 2, 0}},
+		{"partial added unranked", Ranking{0, Unranked}, []int{0, 1}, 3, Ranking{0, Unranked, Unranked}},
+		{"partial removed ranked", Ranking{0, 1, Unranked}, []int{-1, 0, 1}, 2, Ranking{0, Unranked}},
+		// without unranked candidates left the ballot is complete again
+		{"partial removed unranked", Ranking{0, 1, Unranked}, []int{0, 1, -1}, 3, Ranking{0, 1, 2}},
+		{"removed only ranked", Ranking{Unranked, 0}, []int{0, -1}, 1, Ranking{Unranked}},
+	}
+	for _, test := range tests {
+		if r := test.ranking.Remap(test.mapping, test.numCandidates); !reflect.DeepEqual(test.expected, r) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, r)
+		} else if err := r.Check(); nil != err {
+			t.Errorf("%s: invalid remapped ranking %v: %v", test.name, r, err)
+		}
+	}
+}
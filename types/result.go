@@ -0,0 +1,37 @@
+package types
+
+/* the result of an election: the Condorcet winner if there is one, the
+ * winner by strongest paths (Schwarz method) otherwise.
+ *
+ * elections can have a "reopen nominations" (RON) pseudo-candidate,
+ * voters rank it like any other candidate. if RON wins the election
+ * failed; candidates ranked below RON (RON has the stronger path) are
+ * ineligible.
+ */
+
+type Result struct {
+	Winner     int            // -1 if there is no winner
+	Paths      StrongestPaths // nil if there is a Condorcet winner
+	Failed     bool           // RON won
+	Ineligible []int          // candidates ranked below RON
+}
+
+// ron is the index of the RON candidate, -1 if there is none
+func (p PairwisePreferences) Result(ron int) Result {
+	result := Result{Winner: p.Winner()}
+	paths := p.StrongestPaths()
+	if -1 == result.Winner {
+		result.Paths = paths
+		result.Winner = paths.Winner()
+	}
+	if -1 == ron {
+		return result
+	}
+	result.Failed = ron == result.Winner
+	for candidate := range p {
+		if candidate != ron && paths[ron][candidate] > paths[candidate][ron] {
+			result.Ineligible = append(result.Ineligible, candidate)
+		}
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+// candidate 2 is RON where ron is set
+func TestResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefs      PairwisePreferences
+		ron        int
+		winner     int
+		paths      bool // no Condorcet winner
+		failed     bool
+		ineligible []int
+	}{
+		{"no RON", PairwisePreferences{{0, 2, 1}, {1, 0, 1}, {2, 2, 0}}, -1, 2, false, false, nil},
+		{"RON wins", PairwisePreferences{{0, 2, 1}, {1, 0, 1}, {2, 2, 0}}, 2, 2, false, true, []int{0, 1}},
+		{"candidate beats RON", PairwisePreferences{{0, 1, 1}, {0, 0, 0}, {0, 1, 0}}, 2, 0, false, false, []int{1}},
+		// equal paths: the tied candidate stays eligible
+		{"RON ties candidate", PairwisePreferences{{0, 2, 1}, {0, 0, 0}, {1, 2, 0}}, 2, -1, true, false, []int{1}},
+		{"cycle", PairwisePreferences{{0, 6, 4}, {3, 0, 7}, {5, 2, 0}}, -1, 0, true, false, nil},
+		{"RON wins cycle", PairwisePreferences{{0, 6, 2}, {3, 0, 5}, {7, 4, 0}}, 2, 2, true, true, []int{0, 1}},
+	}
+	for _, test := range tests {
+		r := test.prefs.Result(test.ron)
+		if test.winner != r.Winner {
+			t.Errorf("%s: expected winner %d, got %d", test.name, test.winner, r.Winner)
+		}
+		if test.paths != (nil != r.Paths) {
+			t.Errorf("%s: expected paths: %v, got %v", test.name, test.paths, r.Paths)
+		}
+		if test.failed != r.Failed {
+			t.Errorf("%s: expected failed %v, got %v", test.name, test.failed, r.Failed)
+		}
+		if !reflect.DeepEqual(test.ineligible, r.Ineligible) {
+			t.Errorf("%s: expected ineligible %v, got %v", test.name, test.ineligible, r.Ineligible)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stbuehler/go-vote/client"
+	"github.com/stbuehler/go-vote/types"
+)
+
+// verify published ballots against the published result; the ballot of
+// receipt (if not nil) must be published with the hash from the receipt,
+// or the hash it was adjusted to after the candidates changed
+func verifyElection(baseUrl, election string, receipt *types.Receipt) error {
+	c := client.New(baseUrl, client.Auth{})
+	published, err := c.Ballots(election)
+	if nil != err {
+		return err
+	}
+	official, err := c.Results(election)
+	if nil != err {
+		return err
+	}
+
+	foundReceipt := false
+	for _, b := range published.Ballots {
+		if !b.Valid() {
+			return fmt.Errorf("ballot %s: hash mismatch", b.Id)
+		}
+		if nil != receipt && b.Id == receipt.Ballot {
+			// a valid ballot with a different hash was modified (and
+			// rehashed) after the receipt was issued, unless it was
+			// adjusted to changed candidates
+			if b.Hash != receipt.Hash {
+				if !adjustedTo(published.Adjusted, receipt.Hash, b.Hash) {
+					return fmt.Errorf("ballot %s: published hash %s doesn't match the receipt hash %s", b.Id, b.Hash, receipt.Hash)
+				}
+				fmt.Printf("Found ballot %s with the receipt hash %s, adjusted to %s\n", b.Id, receipt.Hash, b.Hash)
+			} else {
+				fmt.Printf("Found ballot %s with the receipt hash %s\n", b.Id, b.Hash)
+			}
+			foundReceipt = true
+		}
+	}
+	if nil != receipt && !foundReceipt {
+		return fmt.Errorf("ballot %s not published", receipt.Ballot)
+	}
+
+	prefs, err := types.PairwisePreferencesFromBallots(len(published.Candidates), published.Ballots, "abstain" == published.Unranked)
+	if nil != err {
+		return fmt.Errorf("invalid ballots: %v", err)
+	}
+	if !prefs.Equal(official.Preferences) {
+		fmt.Print(prefs.AsciiTable(published.Candidates))
+		return fmt.Errorf("recomputed preferences differ from official result")
+	}
+	ron := -1
+	if nil != official.Ron {
+		ron = *official.Ron
+	}
+	if r := prefs.Result(ron); r.Failed != official.Failed || (-1 == r.Winner) != (nil == official.Winner) || (nil != official.Winner && r.Winner != *official.Winner) {
+		return fmt.Errorf("recomputed winner differs from official result")
+	}
+	fmt.Printf("Verified %d ballots: result matches\n", len(published.Ballots))
+	return nil
+}
+
+// whether following the adjusted hashes leads from hash to target
+func adjustedTo(adjusted map[string]string, hash, target string) bool {
+	// a ballot can get an earlier hash again: stop after visiting all
+	for steps := 0; steps < len(adjusted); steps++ {
+		next, ok := adjusted[hash]
+		if !ok {
+			return false
+		} else if next == target {
+			return true
+		}
+		hash = next
+	}
+	return false
+}